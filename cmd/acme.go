@@ -0,0 +1,1053 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/n-creativesystem/self-signed-certificate/pkg/ca"
+	"github.com/spf13/cobra"
+)
+
+func acmeCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "acme",
+		Short: "ACMEサーバー",
+		Long:  "CAの秘密鍵で証明書を発行するACME(RFC 8555)サーバー",
+	}
+	cmd.AddCommand(serveACMECommand())
+	return &cmd
+}
+
+const (
+	acmeStatusPending     = "pending"
+	acmeStatusReady       = "ready"
+	acmeStatusProcessing  = "processing"
+	acmeStatusValid       = "valid"
+	acmeStatusInvalid     = "invalid"
+	acmeStatusDeactivated = "deactivated"
+	acmeStatusExpired     = "expired"
+)
+
+const (
+	acmeChallengeHTTP01    = "http-01"
+	acmeChallengeDNS01     = "dns-01"
+	acmeChallengeTLSALPN01 = "tls-alpn-01"
+)
+
+const (
+	acmeIdentifierDNS = "dns"
+	acmeIdentifierIP  = "ip"
+)
+
+const acmeOrderLifetime = 24 * time.Hour
+
+var acmeSignatureAlgorithms = map[string]bool{
+	string(jose.RS256): true,
+	string(jose.RS384): true,
+	string(jose.RS512): true,
+	string(jose.PS256): true,
+	string(jose.PS384): true,
+	string(jose.PS512): true,
+	string(jose.ES256): true,
+	string(jose.ES384): true,
+	string(jose.ES512): true,
+	string(jose.EdDSA): true,
+}
+
+type acmeProblem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail,omitempty"`
+	Status int    `json:"status,omitempty"`
+}
+
+func (p *acmeProblem) Error() string {
+	return p.Type + ": " + p.Detail
+}
+
+func acmeError(status int, typ string, format string, a ...interface{}) *acmeProblem {
+	return &acmeProblem{
+		Type:   "urn:ietf:params:acme:error:" + typ,
+		Detail: fmt.Sprintf(format, a...),
+		Status: status,
+	}
+}
+
+type acmeIdentifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type acmeAccount struct {
+	id         string
+	key        *jose.JSONWebKey
+	thumbprint string
+	status     string
+	contact    []string
+	orders     []string
+}
+
+type acmeOrder struct {
+	id          string
+	accountID   string
+	status      string
+	expires     time.Time
+	identifiers []acmeIdentifier
+	authzs      []string
+	certID      string
+	err         *acmeProblem
+}
+
+type acmeAuthz struct {
+	id         string
+	accountID  string
+	identifier acmeIdentifier
+	wildcard   bool
+	status     string
+	expires    time.Time
+	challenges []*acmeChallenge
+}
+
+type acmeChallenge struct {
+	id        string
+	authzID   string
+	typ       string
+	token     string
+	status    string
+	validated time.Time
+	err       *acmeProblem
+}
+
+type acmeCertificate struct {
+	accountID string
+	chain     []byte
+}
+
+type acmeServer struct {
+	mu         sync.Mutex
+	caCert     []byte
+	caKey      crypto.Signer
+	database   string
+	serialType string
+	days       int
+	profile    *certificateProfile
+	policy     *signingPolicy
+	validator  *acmeValidator
+	nonces     map[string]bool
+	accounts   map[string]*acmeAccount
+	orders     map[string]*acmeOrder
+	authzs     map[string]*acmeAuthz
+	challenges map[string]*acmeChallenge
+	certs      map[string]*acmeCertificate
+}
+
+func newACMEServer(caCert []byte, caKey crypto.Signer, database string, validator *acmeValidator) *acmeServer {
+	return &acmeServer{
+		caCert:     caCert,
+		caKey:      caKey,
+		database:   database,
+		serialType: serialTypeSequential,
+		days:       90,
+		validator:  validator,
+		nonces:     map[string]bool{},
+		accounts:   map[string]*acmeAccount{},
+		orders:     map[string]*acmeOrder{},
+		authzs:     map[string]*acmeAuthz{},
+		challenges: map[string]*acmeChallenge{},
+		certs:      map[string]*acmeCertificate{},
+	}
+}
+
+type acmeRequest struct {
+	base    string
+	url     string
+	payload []byte
+	jwk     *jose.JSONWebKey
+	account *acmeAccount
+}
+
+func (req *acmeRequest) postAsGet() bool {
+	return len(req.payload) == 0
+}
+
+func (req *acmeRequest) decode(v interface{}) error {
+	if err := json.Unmarshal(req.payload, v); err != nil {
+		return acmeError(http.StatusBadRequest, "malformed", "invalid payload: %v", err)
+	}
+	return nil
+}
+
+const (
+	acmeKeyID = iota
+	acmeKeyJWK
+	acmeKeyAny
+)
+
+func (s *acmeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	base := "http://" + r.Host
+	if r.TLS != nil {
+		base = "https://" + r.Host
+	}
+	w.Header().Set("Replay-Nonce", s.newNonce())
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Add("Link", fmt.Sprintf(`<%s/acme/directory>;rel="index"`, base))
+
+	path := strings.TrimPrefix(r.URL.Path, "/acme/")
+	if path == r.URL.Path {
+		http.NotFound(w, r)
+		return
+	}
+	switch path {
+	case "directory":
+		s.directory(w, base)
+		return
+	case "new-nonce":
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeACMEProblem(w, acmeError(http.StatusMethodNotAllowed, "malformed", "method %s is not allowed", r.Method))
+		return
+	}
+
+	resource := strings.SplitN(path, "/", 3)
+	var id, sub string
+	if len(resource) > 1 {
+		id = resource[1]
+	}
+	if len(resource) > 2 {
+		sub = resource[2]
+	}
+	keyMode := acmeKeyID
+	switch resource[0] {
+	case "new-account":
+		keyMode = acmeKeyJWK
+	case "revoke-cert":
+		keyMode = acmeKeyAny
+	}
+	req, err := s.parseJWS(r, base, keyMode)
+	if err == nil {
+		switch {
+		case path == "new-account":
+			err = s.newAccount(w, req)
+		case resource[0] == "account" && sub == "":
+			err = s.updateAccount(w, req, id)
+		case resource[0] == "account" && sub == "orders":
+			err = s.listOrders(w, req, id)
+		case path == "new-order":
+			err = s.newOrder(w, req)
+		case resource[0] == "order" && sub == "":
+			err = s.getOrder(w, req, id)
+		case resource[0] == "order" && sub == "finalize":
+			err = s.finalize(w, req, id)
+		case resource[0] == "authz" && sub == "":
+			err = s.getAuthz(w, req, id)
+		case resource[0] == "chall" && sub == "":
+			err = s.challenge(w, req, id)
+		case resource[0] == "cert" && sub == "":
+			err = s.certificate(w, req, id)
+		case path == "revoke-cert":
+			err = s.revokeCert(w, req)
+		case path == "key-change":
+			err = s.keyChange(w, req)
+		default:
+			err = acmeError(http.StatusNotFound, "malformed", "unknown resource %s", r.URL.Path)
+		}
+	}
+	if err != nil {
+		problem, ok := err.(*acmeProblem)
+		if !ok {
+			log.Printf("acme: %s: %v", r.URL.Path, err)
+			problem = acmeError(http.StatusInternalServerError, "serverInternal", "%v", err)
+		}
+		writeACMEProblem(w, problem)
+	}
+}
+
+func (s *acmeServer) newNonce() string {
+	if len(s.nonces) >= 1<<16 {
+		s.nonces = map[string]bool{}
+	}
+	nonce := acmeRandomID()
+	s.nonces[nonce] = true
+	return nonce
+}
+
+func (s *acmeServer) parseJWS(r *http.Request, base string, keyMode int) (*acmeRequest, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return nil, acmeError(http.StatusBadRequest, "malformed", "%v", err)
+	}
+	jws, err := jose.ParseSigned(string(body))
+	if err != nil {
+		return nil, acmeError(http.StatusBadRequest, "malformed", "invalid jws: %v", err)
+	}
+	if len(jws.Signatures) != 1 {
+		return nil, acmeError(http.StatusBadRequest, "malformed", "jws must have exactly one signature")
+	}
+	header := jws.Signatures[0].Protected
+	if !acmeSignatureAlgorithms[header.Algorithm] {
+		return nil, acmeError(http.StatusBadRequest, "badSignatureAlgorithm", "unsupported signature algorithm %s", header.Algorithm)
+	}
+	if !s.nonces[header.Nonce] {
+		return nil, acmeError(http.StatusBadRequest, "badNonce", "invalid nonce %q", header.Nonce)
+	}
+	delete(s.nonces, header.Nonce)
+	req := &acmeRequest{base: base, url: base + r.URL.Path}
+	if u, _ := header.ExtraHeaders[jose.HeaderKey("url")].(string); u != req.url {
+		return nil, acmeError(http.StatusUnauthorized, "unauthorized", "url %q does not match the request url", u)
+	}
+
+	switch {
+	case header.JSONWebKey != nil && header.KeyID != "":
+		return nil, acmeError(http.StatusBadRequest, "malformed", "jwk and kid are mutually exclusive")
+	case header.JSONWebKey != nil:
+		if keyMode == acmeKeyID {
+			return nil, acmeError(http.StatusBadRequest, "malformed", "kid is required")
+		}
+		if !header.JSONWebKey.Valid() || !header.JSONWebKey.IsPublic() {
+			return nil, acmeError(http.StatusBadRequest, "badPublicKey", "invalid jwk")
+		}
+		req.jwk = header.JSONWebKey
+	case header.KeyID != "":
+		if keyMode == acmeKeyJWK {
+			return nil, acmeError(http.StatusBadRequest, "malformed", "jwk is required")
+		}
+		account := s.accounts[strings.TrimPrefix(header.KeyID, base+"/acme/account/")]
+		if account == nil {
+			return nil, acmeError(http.StatusBadRequest, "accountDoesNotExist", "account %s does not exist", header.KeyID)
+		}
+		if account.status != acmeStatusValid {
+			return nil, acmeError(http.StatusUnauthorized, "unauthorized", "account is %s", account.status)
+		}
+		req.account = account
+		req.jwk = account.key
+	default:
+		return nil, acmeError(http.StatusBadRequest, "malformed", "jwk or kid is required")
+	}
+	req.payload, err = jws.Verify(req.jwk)
+	if err != nil {
+		return nil, acmeError(http.StatusBadRequest, "malformed", "invalid signature: %v", err)
+	}
+	return req, nil
+}
+
+func (s *acmeServer) directory(w http.ResponseWriter, base string) {
+	writeACMEJSON(w, http.StatusOK, map[string]interface{}{
+		"newNonce":   base + "/acme/new-nonce",
+		"newAccount": base + "/acme/new-account",
+		"newOrder":   base + "/acme/new-order",
+		"revokeCert": base + "/acme/revoke-cert",
+		"keyChange":  base + "/acme/key-change",
+		"meta": map[string]interface{}{
+			"externalAccountRequired": false,
+		},
+	})
+}
+
+func (s *acmeServer) newAccount(w http.ResponseWriter, req *acmeRequest) error {
+	var payload struct {
+		Contact            []string `json:"contact"`
+		OnlyReturnExisting bool     `json:"onlyReturnExisting"`
+	}
+	if err := req.decode(&payload); err != nil {
+		return err
+	}
+	thumbprint, err := acmeThumbprint(req.jwk)
+	if err != nil {
+		return err
+	}
+	for _, account := range s.accounts {
+		if account.thumbprint == thumbprint {
+			w.Header().Set("Location", req.base+"/acme/account/"+account.id)
+			writeACMEJSON(w, http.StatusOK, s.accountJSON(req.base, account))
+			return nil
+		}
+	}
+	if payload.OnlyReturnExisting {
+		return acmeError(http.StatusBadRequest, "accountDoesNotExist", "account does not exist")
+	}
+	// アカウントIDは登録時の鍵のthumbprintにし、再起動後も同じ鍵で登録すれば同じIDになるようにします。
+	// 鍵を変更したアカウントの以前の鍵で登録した場合は、IDが重複しないよう乱数にします
+	id := thumbprint
+	if _, ok := s.accounts[id]; ok {
+		id = acmeRandomID()
+	}
+	account := &acmeAccount{
+		id:         id,
+		key:        req.jwk,
+		thumbprint: thumbprint,
+		status:     acmeStatusValid,
+		contact:    payload.Contact,
+	}
+	s.accounts[account.id] = account
+	log.Printf("acme: new account %s", account.id)
+	w.Header().Set("Location", req.base+"/acme/account/"+account.id)
+	writeACMEJSON(w, http.StatusCreated, s.accountJSON(req.base, account))
+	return nil
+}
+
+func (s *acmeServer) updateAccount(w http.ResponseWriter, req *acmeRequest, id string) error {
+	if req.account.id != id {
+		return acmeError(http.StatusUnauthorized, "unauthorized", "account %s is not owned by the requester", id)
+	}
+	if !req.postAsGet() {
+		var payload struct {
+			Contact []string `json:"contact"`
+			Status  string   `json:"status"`
+		}
+		if err := req.decode(&payload); err != nil {
+			return err
+		}
+		switch payload.Status {
+		case "", acmeStatusValid:
+		case acmeStatusDeactivated:
+			req.account.status = acmeStatusDeactivated
+		default:
+			return acmeError(http.StatusBadRequest, "malformed", "invalid account status %s", payload.Status)
+		}
+		if payload.Contact != nil {
+			req.account.contact = payload.Contact
+		}
+	}
+	writeACMEJSON(w, http.StatusOK, s.accountJSON(req.base, req.account))
+	return nil
+}
+
+func (s *acmeServer) accountJSON(base string, account *acmeAccount) interface{} {
+	return struct {
+		Status  string   `json:"status"`
+		Contact []string `json:"contact,omitempty"`
+		Orders  string   `json:"orders"`
+	}{
+		Status:  account.status,
+		Contact: account.contact,
+		Orders:  base + "/acme/account/" + account.id + "/orders",
+	}
+}
+
+func (s *acmeServer) listOrders(w http.ResponseWriter, req *acmeRequest, id string) error {
+	if req.account.id != id {
+		return acmeError(http.StatusUnauthorized, "unauthorized", "account %s is not owned by the requester", id)
+	}
+	orders := make([]string, 0, len(req.account.orders))
+	for _, orderID := range req.account.orders {
+		orders = append(orders, req.base+"/acme/order/"+orderID)
+	}
+	writeACMEJSON(w, http.StatusOK, map[string][]string{"orders": orders})
+	return nil
+}
+
+func (s *acmeServer) newOrder(w http.ResponseWriter, req *acmeRequest) error {
+	var payload struct {
+		Identifiers []acmeIdentifier `json:"identifiers"`
+	}
+	if err := req.decode(&payload); err != nil {
+		return err
+	}
+	if len(payload.Identifiers) == 0 {
+		return acmeError(http.StatusBadRequest, "malformed", "identifiers are required")
+	}
+	order := &acmeOrder{
+		id:        acmeRandomID(),
+		accountID: req.account.id,
+		status:    acmeStatusPending,
+		expires:   time.Now().Add(acmeOrderLifetime),
+	}
+	var authzs []*acmeAuthz
+	for _, identifier := range payload.Identifiers {
+		authz, err := newACMEAuthz(req.account.id, identifier, order.expires)
+		if err != nil {
+			return err
+		}
+		authzs = append(authzs, authz)
+		order.authzs = append(order.authzs, authz.id)
+		value := authz.identifier.Value
+		if authz.wildcard {
+			value = "*." + value
+		}
+		order.identifiers = append(order.identifiers, acmeIdentifier{Type: authz.identifier.Type, Value: value})
+	}
+	for _, authz := range authzs {
+		s.authzs[authz.id] = authz
+		for _, challenge := range authz.challenges {
+			s.challenges[challenge.id] = challenge
+		}
+	}
+	s.orders[order.id] = order
+	req.account.orders = append(req.account.orders, order.id)
+	w.Header().Set("Location", req.base+"/acme/order/"+order.id)
+	writeACMEJSON(w, http.StatusCreated, s.orderJSON(req.base, order))
+	return nil
+}
+
+func newACMEAuthz(accountID string, identifier acmeIdentifier, expires time.Time) (*acmeAuthz, error) {
+	authz := &acmeAuthz{
+		id:        acmeRandomID(),
+		accountID: accountID,
+		status:    acmeStatusPending,
+		expires:   expires,
+	}
+	var challengeTypes []string
+	switch identifier.Type {
+	case acmeIdentifierDNS:
+		value := strings.TrimSuffix(strings.ToLower(identifier.Value), ".")
+		if strings.HasPrefix(value, "*.") {
+			value = strings.TrimPrefix(value, "*.")
+			authz.wildcard = true
+		}
+		if !validDNSName(value) {
+			return nil, acmeError(http.StatusBadRequest, "rejectedIdentifier", "invalid dns identifier %q", identifier.Value)
+		}
+		authz.identifier = acmeIdentifier{Type: acmeIdentifierDNS, Value: value}
+		challengeTypes = []string{acmeChallengeHTTP01, acmeChallengeDNS01, acmeChallengeTLSALPN01}
+		if authz.wildcard {
+			challengeTypes = []string{acmeChallengeDNS01}
+		}
+	case acmeIdentifierIP:
+		ip := net.ParseIP(identifier.Value)
+		if ip == nil {
+			return nil, acmeError(http.StatusBadRequest, "rejectedIdentifier", "invalid ip identifier %q", identifier.Value)
+		}
+		authz.identifier = acmeIdentifier{Type: acmeIdentifierIP, Value: ip.String()}
+		challengeTypes = []string{acmeChallengeHTTP01}
+	default:
+		return nil, acmeError(http.StatusBadRequest, "unsupportedIdentifier", "unsupported identifier type %s", identifier.Type)
+	}
+	for _, typ := range challengeTypes {
+		authz.challenges = append(authz.challenges, &acmeChallenge{
+			id:      acmeRandomID(),
+			authzID: authz.id,
+			typ:     typ,
+			token:   acmeRandomID(),
+			status:  acmeStatusPending,
+		})
+	}
+	return authz, nil
+}
+
+func validDNSName(name string) bool {
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (s *acmeServer) getOrder(w http.ResponseWriter, req *acmeRequest, id string) error {
+	order, err := s.order(req, id)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Location", req.base+"/acme/order/"+order.id)
+	writeACMEJSON(w, http.StatusOK, s.orderJSON(req.base, order))
+	return nil
+}
+
+func (s *acmeServer) order(req *acmeRequest, id string) (*acmeOrder, error) {
+	order := s.orders[id]
+	if order == nil {
+		return nil, acmeError(http.StatusNotFound, "malformed", "order %s does not exist", id)
+	}
+	if order.accountID != req.account.id {
+		return nil, acmeError(http.StatusUnauthorized, "unauthorized", "order %s is not owned by the requester", id)
+	}
+	s.updateOrder(order)
+	return order, nil
+}
+
+func (s *acmeServer) updateOrder(order *acmeOrder) {
+	if order.status != acmeStatusPending {
+		return
+	}
+	if time.Now().After(order.expires) {
+		order.status = acmeStatusInvalid
+		order.err = acmeError(http.StatusForbidden, "malformed", "order has expired")
+		return
+	}
+	ready := true
+	for _, authzID := range order.authzs {
+		authz := s.authzs[authzID]
+		switch authz.status {
+		case acmeStatusValid:
+		case acmeStatusPending:
+			ready = false
+		default:
+			order.status = acmeStatusInvalid
+			order.err = acmeError(http.StatusForbidden, "unauthorized", "authorization for %s is %s", authz.identifier.Value, authz.status)
+			return
+		}
+	}
+	if ready {
+		order.status = acmeStatusReady
+	}
+}
+
+func (s *acmeServer) orderJSON(base string, order *acmeOrder) interface{} {
+	authorizations := make([]string, 0, len(order.authzs))
+	for _, authzID := range order.authzs {
+		authorizations = append(authorizations, base+"/acme/authz/"+authzID)
+	}
+	v := struct {
+		Status         string           `json:"status"`
+		Expires        string           `json:"expires"`
+		Identifiers    []acmeIdentifier `json:"identifiers"`
+		Authorizations []string         `json:"authorizations"`
+		Finalize       string           `json:"finalize"`
+		Certificate    string           `json:"certificate,omitempty"`
+		Error          *acmeProblem     `json:"error,omitempty"`
+	}{
+		Status:         order.status,
+		Expires:        order.expires.UTC().Format(time.RFC3339),
+		Identifiers:    order.identifiers,
+		Authorizations: authorizations,
+		Finalize:       base + "/acme/order/" + order.id + "/finalize",
+		Error:          order.err,
+	}
+	if order.certID != "" {
+		v.Certificate = base + "/acme/cert/" + order.certID
+	}
+	return v
+}
+
+func (s *acmeServer) getAuthz(w http.ResponseWriter, req *acmeRequest, id string) error {
+	authz := s.authzs[id]
+	if authz == nil {
+		return acmeError(http.StatusNotFound, "malformed", "authorization %s does not exist", id)
+	}
+	if authz.accountID != req.account.id {
+		return acmeError(http.StatusUnauthorized, "unauthorized", "authorization %s is not owned by the requester", id)
+	}
+	if !req.postAsGet() {
+		var payload struct {
+			Status string `json:"status"`
+		}
+		if err := req.decode(&payload); err != nil {
+			return err
+		}
+		if payload.Status != acmeStatusDeactivated {
+			return acmeError(http.StatusBadRequest, "malformed", "invalid authorization status %s", payload.Status)
+		}
+		authz.status = acmeStatusDeactivated
+	}
+	if authz.status == acmeStatusPending && time.Now().After(authz.expires) {
+		authz.status = acmeStatusExpired
+	}
+	writeACMEJSON(w, http.StatusOK, s.authzJSON(req.base, authz))
+	return nil
+}
+
+func (s *acmeServer) authzJSON(base string, authz *acmeAuthz) interface{} {
+	challenges := make([]interface{}, 0, len(authz.challenges))
+	for _, challenge := range authz.challenges {
+		challenges = append(challenges, s.challengeJSON(base, challenge))
+	}
+	return struct {
+		Identifier acmeIdentifier `json:"identifier"`
+		Status     string         `json:"status"`
+		Expires    string         `json:"expires"`
+		Challenges []interface{}  `json:"challenges"`
+		Wildcard   bool           `json:"wildcard,omitempty"`
+	}{
+		Identifier: authz.identifier,
+		Status:     authz.status,
+		Expires:    authz.expires.UTC().Format(time.RFC3339),
+		Challenges: challenges,
+		Wildcard:   authz.wildcard,
+	}
+}
+
+func (s *acmeServer) challengeJSON(base string, challenge *acmeChallenge) interface{} {
+	v := struct {
+		Type      string       `json:"type"`
+		URL       string       `json:"url"`
+		Status    string       `json:"status"`
+		Token     string       `json:"token"`
+		Validated string       `json:"validated,omitempty"`
+		Error     *acmeProblem `json:"error,omitempty"`
+	}{
+		Type:   challenge.typ,
+		URL:    base + "/acme/chall/" + challenge.id,
+		Status: challenge.status,
+		Token:  challenge.token,
+		Error:  challenge.err,
+	}
+	if !challenge.validated.IsZero() {
+		v.Validated = challenge.validated.UTC().Format(time.RFC3339)
+	}
+	return v
+}
+
+func (s *acmeServer) challenge(w http.ResponseWriter, req *acmeRequest, id string) error {
+	challenge := s.challenges[id]
+	if challenge == nil {
+		return acmeError(http.StatusNotFound, "malformed", "challenge %s does not exist", id)
+	}
+	authz := s.authzs[challenge.authzID]
+	if authz.accountID != req.account.id {
+		return acmeError(http.StatusUnauthorized, "unauthorized", "challenge %s is not owned by the requester", id)
+	}
+	if !req.postAsGet() && challenge.status == acmeStatusPending && authz.status == acmeStatusPending {
+		keyAuthorization := challenge.token + "." + req.account.thumbprint
+		challenge.status = acmeStatusProcessing
+		go s.validate(challenge, authz, keyAuthorization)
+	}
+	w.Header().Add("Link", fmt.Sprintf(`<%s/acme/authz/%s>;rel="up"`, req.base, authz.id))
+	writeACMEJSON(w, http.StatusOK, s.challengeJSON(req.base, challenge))
+	return nil
+}
+
+func (s *acmeServer) validate(challenge *acmeChallenge, authz *acmeAuthz, keyAuthorization string) {
+	problem := s.validator.validate(challenge.typ, authz.identifier, challenge.token, keyAuthorization)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if problem != nil {
+		log.Printf("acme: %s %s: %s", challenge.typ, authz.identifier.Value, problem.Detail)
+		challenge.status = acmeStatusInvalid
+		challenge.err = problem
+		authz.status = acmeStatusInvalid
+		return
+	}
+	log.Printf("acme: %s %s: valid", challenge.typ, authz.identifier.Value)
+	challenge.status = acmeStatusValid
+	challenge.validated = time.Now()
+	authz.status = acmeStatusValid
+}
+
+func (s *acmeServer) finalize(w http.ResponseWriter, req *acmeRequest, id string) error {
+	order, err := s.order(req, id)
+	if err != nil {
+		return err
+	}
+	if order.status != acmeStatusReady {
+		return acmeError(http.StatusForbidden, "orderNotReady", "order is %s", order.status)
+	}
+	var payload struct {
+		CSR string `json:"csr"`
+	}
+	if err := req.decode(&payload); err != nil {
+		return err
+	}
+	der, err := base64.RawURLEncoding.DecodeString(payload.CSR)
+	if err != nil {
+		return acmeError(http.StatusBadRequest, "badCSR", "invalid csr encoding: %v", err)
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return acmeError(http.StatusBadRequest, "badCSR", "invalid csr: %v", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return acmeError(http.StatusBadRequest, "badCSR", "invalid csr signature: %v", err)
+	}
+	// RFC 8555 11.1 アカウントの鍵を証明書の鍵にはできません
+	if publicKeyEqual(csr.PublicKey, req.account.key.Key) {
+		return acmeError(http.StatusBadRequest, "badCSR", "csr public key must not be the account key")
+	}
+	if err := checkACMECSR(csr, order.identifiers); err != nil {
+		return err
+	}
+
+	db, err := loadDatabase(s.database)
+	if err != nil {
+		return err
+	}
+	var args serverArgs
+	args.serialType = s.serialType
+	args.days = s.days
+	args.profile = s.profile
+	args.policy = s.policy
+	// 証明書には検証済みの識別子だけを設定します
+	args.sanMode = sanModeFlags
+	for _, identifier := range order.identifiers {
+		switch identifier.Type {
+		case acmeIdentifierDNS:
+			args.dnsNames = append(args.dnsNames, identifier.Value)
+		case acmeIdentifierIP:
+			args.ipAddresses = append(args.ipAddresses, net.ParseIP(identifier.Value))
+		}
+	}
+	args.extKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	args.caCert = s.caCert
+	args.caKey = s.caKey
+	args.certFilename = "acme:" + req.account.id
+	args.db = db
+	derCertificate, err := signCSR(args, csr)
+	if err != nil {
+		var perr *policyError
+		if errors.As(err, &perr) {
+			return acmeError(http.StatusBadRequest, "badCSR", "%v", err)
+		}
+		return err
+	}
+	if err := db.save(); err != nil {
+		return err
+	}
+	certificate, err := x509.ParseCertificate(derCertificate)
+	if err != nil {
+		return err
+	}
+	chain := &bytes.Buffer{}
+	if err := writeChain(chain, derCertificate, s.caCert); err != nil {
+		return err
+	}
+	order.certID = formatSerial(certificate.SerialNumber)
+	order.status = acmeStatusValid
+	s.certs[order.certID] = &acmeCertificate{accountID: req.account.id, chain: chain.Bytes()}
+	names := append(append([]string{}, args.dnsNames...), ipStrings(args.ipAddresses)...)
+	log.Printf("acme: issued serial %s for %s", order.certID, strings.Join(names, ","))
+	w.Header().Set("Location", req.base+"/acme/order/"+order.id)
+	writeACMEJSON(w, http.StatusOK, s.orderJSON(req.base, order))
+	return nil
+}
+
+var oidExtensionSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+
+// checkACMECSR はCSRのSANがオーダーの識別子と一致することを確認します。
+// 識別子にできないSAN(email、URIなど)を含むCSRは拒否します。
+func checkACMECSR(csr *x509.CertificateRequest, identifiers []acmeIdentifier) error {
+	for _, ext := range csr.Extensions {
+		if !ext.Id.Equal(oidExtensionSubjectAltName) {
+			continue
+		}
+		var names []asn1.RawValue
+		if rest, err := asn1.Unmarshal(ext.Value, &names); err != nil || len(rest) > 0 {
+			return acmeError(http.StatusBadRequest, "badCSR", "invalid subject alternative names")
+		}
+		for _, name := range names {
+			// dNSName [2]、iPAddress [7] 以外は識別子にできません
+			if name.Class != asn1.ClassContextSpecific || (name.Tag != 2 && name.Tag != 7) {
+				return acmeError(http.StatusBadRequest, "badCSR", "csr must contain only dns and ip subject alternative names")
+			}
+		}
+	}
+	want := map[string]bool{}
+	for _, identifier := range identifiers {
+		want[identifier.Type+":"+identifier.Value] = true
+	}
+	got := map[string]bool{}
+	for _, name := range csr.DNSNames {
+		got[acmeIdentifierDNS+":"+strings.ToLower(name)] = true
+	}
+	for _, ip := range csr.IPAddresses {
+		got[acmeIdentifierIP+":"+ip.String()] = true
+	}
+	if cn := csr.Subject.CommonName; cn != "" {
+		if !got[acmeIdentifierDNS+":"+strings.ToLower(cn)] && !got[acmeIdentifierIP+":"+cn] {
+			return acmeError(http.StatusBadRequest, "badCSR", "common name %s is not in the subject alternative names", cn)
+		}
+	}
+	if len(got) != len(want) {
+		return acmeError(http.StatusBadRequest, "badCSR", "csr names %s do not match the order identifiers %s", sortedKeys(got), sortedKeys(want))
+	}
+	for name := range got {
+		if !want[name] {
+			return acmeError(http.StatusBadRequest, "badCSR", "csr names %s do not match the order identifiers %s", sortedKeys(got), sortedKeys(want))
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func ipStrings(ips []net.IP) []string {
+	values := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		values = append(values, ip.String())
+	}
+	return values
+}
+
+func (s *acmeServer) certificate(w http.ResponseWriter, req *acmeRequest, id string) error {
+	cert := s.certs[id]
+	if cert == nil {
+		return acmeError(http.StatusNotFound, "malformed", "certificate %s does not exist", id)
+	}
+	if cert.accountID != req.account.id {
+		return acmeError(http.StatusUnauthorized, "unauthorized", "certificate %s is not owned by the requester", id)
+	}
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	w.WriteHeader(http.StatusOK)
+	w.Write(cert.chain)
+	return nil
+}
+
+func (s *acmeServer) revokeCert(w http.ResponseWriter, req *acmeRequest) error {
+	var payload struct {
+		Certificate string `json:"certificate"`
+		Reason      int    `json:"reason"`
+	}
+	if err := req.decode(&payload); err != nil {
+		return err
+	}
+	der, err := base64.RawURLEncoding.DecodeString(payload.Certificate)
+	if err != nil {
+		return acmeError(http.StatusBadRequest, "malformed", "invalid certificate encoding: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return acmeError(http.StatusBadRequest, "malformed", "invalid certificate: %v", err)
+	}
+	caTpl, err := ca.ParseCertificate(s.caCert)
+	if err != nil {
+		return err
+	}
+	if err := cert.CheckSignatureFrom(caTpl); err != nil {
+		return acmeError(http.StatusNotFound, "malformed", "certificate is not issued by the CA")
+	}
+	db, err := loadDatabase(s.database)
+	if err != nil {
+		return err
+	}
+	entry := db.find(cert.SerialNumber)
+	if req.account != nil {
+		// 再起動後も確認できるよう、データベースに記録した発行先のアカウントと比較します
+		if entry == nil || entry.filename != "acme:"+req.account.id {
+			return acmeError(http.StatusForbidden, "unauthorized", "certificate is not issued to the account")
+		}
+	} else {
+		certKey, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+		if err != nil {
+			return err
+		}
+		jwkKey, err := x509.MarshalPKIXPublicKey(req.jwk.Key)
+		if err != nil || !bytes.Equal(certKey, jwkKey) {
+			return acmeError(http.StatusForbidden, "unauthorized", "jwk does not match the certificate key")
+		}
+	}
+	if payload.Reason < 0 || payload.Reason >= len(revocationReasons) || revocationReasons[payload.Reason] == "" {
+		return acmeError(http.StatusBadRequest, "badRevocationReason", "invalid revocation reason %d", payload.Reason)
+	}
+
+	if entry != nil && entry.status == statusRevoked {
+		return acmeError(http.StatusBadRequest, "alreadyRevoked", "serial %s is already revoked", formatSerial(cert.SerialNumber))
+	}
+	if err := db.revoke(newIndexEntry(cert), time.Now(), payload.Reason); err != nil {
+		return err
+	}
+	if err := db.save(); err != nil {
+		return err
+	}
+	log.Printf("acme: revoked serial %s", formatSerial(cert.SerialNumber))
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (s *acmeServer) keyChange(w http.ResponseWriter, req *acmeRequest) error {
+	inner, err := jose.ParseSigned(string(req.payload))
+	if err != nil {
+		return acmeError(http.StatusBadRequest, "malformed", "invalid inner jws: %v", err)
+	}
+	if len(inner.Signatures) != 1 {
+		return acmeError(http.StatusBadRequest, "malformed", "inner jws must have exactly one signature")
+	}
+	header := inner.Signatures[0].Protected
+	if !acmeSignatureAlgorithms[header.Algorithm] {
+		return acmeError(http.StatusBadRequest, "badSignatureAlgorithm", "unsupported signature algorithm %s", header.Algorithm)
+	}
+	if header.JSONWebKey == nil || !header.JSONWebKey.Valid() || !header.JSONWebKey.IsPublic() {
+		return acmeError(http.StatusBadRequest, "badPublicKey", "inner jws must have a valid jwk")
+	}
+	if u, _ := header.ExtraHeaders[jose.HeaderKey("url")].(string); u != req.url {
+		return acmeError(http.StatusBadRequest, "malformed", "inner url %q does not match the request url", u)
+	}
+	raw, err := inner.Verify(header.JSONWebKey)
+	if err != nil {
+		return acmeError(http.StatusBadRequest, "malformed", "invalid inner signature: %v", err)
+	}
+	var payload struct {
+		Account string          `json:"account"`
+		OldKey  jose.JSONWebKey `json:"oldKey"`
+	}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return acmeError(http.StatusBadRequest, "malformed", "invalid inner payload: %v", err)
+	}
+	if payload.Account != req.base+"/acme/account/"+req.account.id {
+		return acmeError(http.StatusBadRequest, "malformed", "account %q does not match the kid", payload.Account)
+	}
+	oldThumbprint, err := acmeThumbprint(&payload.OldKey)
+	if err != nil || oldThumbprint != req.account.thumbprint {
+		return acmeError(http.StatusBadRequest, "malformed", "oldKey does not match the account key")
+	}
+	thumbprint, err := acmeThumbprint(header.JSONWebKey)
+	if err != nil {
+		return err
+	}
+	for _, account := range s.accounts {
+		if account.thumbprint == thumbprint {
+			w.Header().Set("Location", req.base+"/acme/account/"+account.id)
+			return acmeError(http.StatusConflict, "malformed", "key is already in use")
+		}
+	}
+	req.account.key = header.JSONWebKey
+	req.account.thumbprint = thumbprint
+	writeACMEJSON(w, http.StatusOK, s.accountJSON(req.base, req.account))
+	return nil
+}
+
+func acmeThumbprint(key *jose.JSONWebKey) (string, error) {
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", acmeError(http.StatusBadRequest, "badPublicKey", "%v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(thumbprint), nil
+}
+
+func acmeRandomID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func writeACMEJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+func writeACMEProblem(w http.ResponseWriter, problem *acmeProblem) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(problem.Status)
+	json.NewEncoder(w).Encode(problem)
+}
@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const acmeTLSALPNProtocol = "acme-tls/1"
+
+var oidExtensionACMEIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+type acmeValidator struct {
+	httpPort int
+	tlsPort  int
+	resolver *net.Resolver
+	timeout  time.Duration
+}
+
+func newACMEResolver(address string) *net.Resolver {
+	if address == "" {
+		return net.DefaultResolver
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "53")
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, address)
+		},
+	}
+}
+
+func (v *acmeValidator) validate(challengeType string, identifier acmeIdentifier, token, keyAuthorization string) *acmeProblem {
+	switch challengeType {
+	case acmeChallengeHTTP01:
+		return v.validateHTTP01(identifier, token, keyAuthorization)
+	case acmeChallengeDNS01:
+		return v.validateDNS01(identifier, keyAuthorization)
+	case acmeChallengeTLSALPN01:
+		return v.validateTLSALPN01(identifier, keyAuthorization)
+	}
+	return acmeError(http.StatusBadRequest, "malformed", "unsupported challenge type %s", challengeType)
+}
+
+func (v *acmeValidator) dialer() *net.Dialer {
+	return &net.Dialer{Timeout: v.timeout, Resolver: v.resolver}
+}
+
+func (v *acmeValidator) validateHTTP01(identifier acmeIdentifier, token, keyAuthorization string) *acmeProblem {
+	url := fmt.Sprintf("http://%s/.well-known/acme-challenge/%s", net.JoinHostPort(identifier.Value, strconv.Itoa(v.httpPort)), token)
+	client := http.Client{
+		Timeout:   v.timeout,
+		Transport: &http.Transport{DialContext: v.dialer().DialContext},
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return acmeError(http.StatusBadRequest, "connection", "%v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return acmeError(http.StatusForbidden, "unauthorized", "%s returned status %d", url, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<12))
+	if err != nil {
+		return acmeError(http.StatusBadRequest, "connection", "%v", err)
+	}
+	if strings.TrimSpace(string(body)) != keyAuthorization {
+		return acmeError(http.StatusForbidden, "incorrectResponse", "%s returned %q, expected %q", url, strings.TrimSpace(string(body)), keyAuthorization)
+	}
+	return nil
+}
+
+func (v *acmeValidator) validateDNS01(identifier acmeIdentifier, keyAuthorization string) *acmeProblem {
+	name := "_acme-challenge." + identifier.Value
+	ctx, cancel := context.WithTimeout(context.Background(), v.timeout)
+	defer cancel()
+	records, err := v.resolver.LookupTXT(ctx, name)
+	if err != nil {
+		return acmeError(http.StatusBadRequest, "dns", "%v", err)
+	}
+	digest := sha256.Sum256([]byte(keyAuthorization))
+	expected := base64.RawURLEncoding.EncodeToString(digest[:])
+	for _, record := range records {
+		if record == expected {
+			return nil
+		}
+	}
+	return acmeError(http.StatusForbidden, "incorrectResponse", "no TXT record %q found at %s", expected, name)
+}
+
+func (v *acmeValidator) validateTLSALPN01(identifier acmeIdentifier, keyAuthorization string) *acmeProblem {
+	address := net.JoinHostPort(identifier.Value, strconv.Itoa(v.tlsPort))
+	conn, err := tls.DialWithDialer(v.dialer(), "tcp", address, &tls.Config{
+		ServerName:         identifier.Value,
+		NextProtos:         []string{acmeTLSALPNProtocol},
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return acmeError(http.StatusBadRequest, "tls", "%v", err)
+	}
+	defer conn.Close()
+	state := conn.ConnectionState()
+	if state.NegotiatedProtocol != acmeTLSALPNProtocol {
+		return acmeError(http.StatusForbidden, "tls", "%s did not negotiate %s", address, acmeTLSALPNProtocol)
+	}
+	cert := state.PeerCertificates[0]
+	if len(cert.DNSNames) != 1 || !strings.EqualFold(cert.DNSNames[0], identifier.Value) || len(cert.IPAddresses) != 0 {
+		return acmeError(http.StatusForbidden, "incorrectResponse", "certificate must have only %s as subject alternative name", identifier.Value)
+	}
+	digest := sha256.Sum256([]byte(keyAuthorization))
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidExtensionACMEIdentifier) {
+			continue
+		}
+		if !ext.Critical {
+			return acmeError(http.StatusForbidden, "incorrectResponse", "acmeIdentifier extension must be critical")
+		}
+		var value []byte
+		if rest, err := asn1.Unmarshal(ext.Value, &value); err != nil || len(rest) != 0 {
+			return acmeError(http.StatusForbidden, "incorrectResponse", "invalid acmeIdentifier extension")
+		}
+		if !bytes.Equal(value, digest[:]) {
+			return acmeError(http.StatusForbidden, "incorrectResponse", "acmeIdentifier extension does not match the key authorization")
+		}
+		return nil
+	}
+	return acmeError(http.StatusForbidden, "incorrectResponse", "certificate has no acmeIdentifier extension")
+}
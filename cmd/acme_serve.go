@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func serveACMECommand() *cobra.Command {
+	initialize := initialize("acme_config")
+	cmd := cobra.Command{
+		Use:   "serve",
+		Short: "ACMEサーバー起動",
+		Long:  "http-01, dns-01, tls-alpn-01 チャレンジを検証し、CAの秘密鍵で証明書を発行するACMEサーバーをHTTPSで起動します。--tlsCert を指定しない場合は --hostname のサーバー証明書をCAから発行して使用します",
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := cmd.Flags().GetString("config")
+			if err != nil {
+				errorExit(err)
+			}
+			initialize(cmd, config)
+			caCertFilename := viper.GetString("caCert")
+			caCert, caKey, err := readCERTandKEY(caCertFilename, viper.GetString("caKey"), viper.GetString("caKeyURI"))
+			if err != nil {
+				errorExit(err)
+			}
+			validator := &acmeValidator{
+				httpPort: viper.GetInt("httpPort"),
+				tlsPort:  viper.GetInt("tlsPort"),
+				resolver: newACMEResolver(viper.GetString("dnsResolver")),
+				timeout:  viper.GetDuration("timeout"),
+			}
+			server := newACMEServer(caCert, caKey, databasePath(caCertFilename, viper.GetString("database")), validator)
+			server.serialType = viper.GetString("serialType")
+			server.days = viper.GetInt("days")
+			server.profile, err = parseProfile()
+			if err != nil {
+				errorExit(err)
+			}
+			server.profile.setDefaults(&server.days, nil, nil)
+			server.policy, err = parseSigningPolicy()
+			if err != nil {
+				errorExit(err)
+			}
+
+			var certificate tls.Certificate
+			if tlsCertFilename := viper.GetString("tlsCert"); tlsCertFilename != "" {
+				certificate, err = tls.LoadX509KeyPair(tlsCertFilename, viper.GetString("tlsKey"))
+			} else {
+				var tlsArg serverArgs
+				tlsArg.serialType = server.serialType
+				tlsArg.days = server.days
+				tlsArg.caCert = caCert
+				tlsArg.caKey = caKey
+				tlsArg.certFilename = "acme:tls"
+				tlsArg.db, err = loadDatabase(server.database)
+				if err != nil {
+					errorExit(err)
+				}
+				certificate, err = servingCertificate(tlsArg, viper.GetStringSlice("hostname"))
+				if err == nil {
+					err = tlsArg.db.save()
+				}
+			}
+			if err != nil {
+				errorExit(err)
+			}
+			listen := viper.GetString("listen")
+			httpServer := &http.Server{
+				Addr:      listen,
+				Handler:   server,
+				TLSConfig: &tls.Config{Certificates: []tls.Certificate{certificate}},
+			}
+			log.Printf("acme: listening on %s (directory: /acme/directory)", listen)
+			if err := httpServer.ListenAndServeTLS("", ""); err != nil {
+				errorExit(err)
+			}
+		},
+	}
+	flags := cmd.Flags()
+	flags.String("config", "", "acme configuration")
+	flags.String("caCert", "ca.crt", "ca cert file name")
+	flags.String("caKey", "ca.key", "ca private key file name")
+	flags.String("caKeyURI", "", "ca private key pkcs11 uri (e.g. pkcs11:token=ca;object=ca-key?module-path=/usr/lib/softhsm/libsofthsm2.so)")
+	flags.String("database", "", "certificate database file name (default: <ca cert name>.index.txt)")
+	flags.String("serialType", serialTypeSequential, "serial number allocation (sequential, random)")
+	flags.Int("days", 90, "days of issued certificates")
+	flags.String("listen", ":14000", "listen address")
+	flags.String("tlsCert", "", "https server cert file name (default: issued from the ca for --hostname)")
+	flags.String("tlsKey", "", "https server private key file name")
+	flags.StringSlice("hostname", []string{"localhost"}, "host names of the https server cert issued from the ca")
+	flags.Int("httpPort", 80, "port for http-01 validation")
+	flags.Int("tlsPort", 443, "port for tls-alpn-01 validation")
+	flags.String("dnsResolver", "", "dns server address for dns-01 validation (default: system resolver)")
+	flags.Duration("timeout", 10*time.Second, "challenge validation timeout")
+	addProfileFlag(flags)
+	return &cmd
+}
@@ -0,0 +1,242 @@
+package cmd
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/n-creativesystem/self-signed-certificate/pkg/ca"
+)
+
+func newTestACMEServer(t *testing.T) *acmeServer {
+	t.Helper()
+	authority, err := ca.New(&ca.IssueRequest{Subject: pkix.Name{CommonName: "ACME Test CA"}, KeyType: ca.KeyTypeECDSAP256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newACMEServer(authority.CertificatePEM(), authority.Signer, filepath.Join(t.TempDir(), "ca.index.txt"), nil)
+}
+
+// newTestACMEOrder は identifiers の検証が完了した(ready の)オーダーを作成します。
+func newTestACMEOrder(t *testing.T, s *acmeServer, identifiers ...acmeIdentifier) (*acmeRequest, *acmeOrder) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk := &jose.JSONWebKey{Key: key.Public()}
+	thumbprint, err := acmeThumbprint(jwk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account := &acmeAccount{id: thumbprint, key: jwk, thumbprint: thumbprint, status: acmeStatusValid}
+	s.accounts[account.id] = account
+	order := &acmeOrder{
+		id:          acmeRandomID(),
+		accountID:   account.id,
+		status:      acmeStatusReady,
+		expires:     time.Now().Add(acmeOrderLifetime),
+		identifiers: identifiers,
+	}
+	s.orders[order.id] = order
+	return &acmeRequest{base: "https://acme.test", account: account, jwk: jwk}, order
+}
+
+func newTestCSR(t *testing.T, key crypto.Signer, tpl *x509.CertificateRequest) string {
+	t.Helper()
+	der, err := x509.CreateCertificateRequest(rand.Reader, tpl, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(map[string]string{"csr": base64.RawURLEncoding.EncodeToString(der)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(payload)
+}
+
+func finalizeTestOrder(s *acmeServer, req *acmeRequest, order *acmeOrder, payload string) (*x509.Certificate, error) {
+	req.payload = []byte(payload)
+	if err := s.finalize(httptest.NewRecorder(), req, order.id); err != nil {
+		return nil, err
+	}
+	return ca.ParseCertificate(s.certs[order.certID].chain)
+}
+
+func TestACMEFinalizeSANs(t *testing.T) {
+	s := newTestACMEServer(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identifiers := []acmeIdentifier{{Type: acmeIdentifierIP, Value: "127.0.0.1"}, {Type: acmeIdentifierDNS, Value: "app.test"}}
+	spiffe, _ := url.Parse("spiffe://prod/ns/payments/sa/admin")
+	otherName, err := asn1.Marshal([]asn1.RawValue{
+		{Class: asn1.ClassContextSpecific, Tag: 2, Bytes: []byte("app.test")},
+		{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: []byte{0x06, 0x01, 0x2a, 0xa0, 0x03, 0x0c, 0x01, 0x78}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 検証していないSANを含むCSRは拒否します
+	tests := []struct {
+		name string
+		tpl  *x509.CertificateRequest
+		err  string
+	}{
+		{
+			name: "email",
+			tpl:  &x509.CertificateRequest{DNSNames: []string{"app.test"}, IPAddresses: []net.IP{net.ParseIP("127.0.0.1")}, EmailAddresses: []string{"ceo@example.com"}},
+			err:  "only dns and ip",
+		},
+		{
+			name: "uri",
+			tpl:  &x509.CertificateRequest{DNSNames: []string{"app.test"}, IPAddresses: []net.IP{net.ParseIP("127.0.0.1")}, URIs: []*url.URL{spiffe}},
+			err:  "only dns and ip",
+		},
+		{
+			name: "other name",
+			tpl:  &x509.CertificateRequest{ExtraExtensions: []pkix.Extension{{Id: oidExtensionSubjectAltName, Value: otherName}}},
+			err:  "only dns and ip",
+		},
+		{
+			name: "unvalidated dns name",
+			tpl:  &x509.CertificateRequest{DNSNames: []string{"app.test", "evil.test"}, IPAddresses: []net.IP{net.ParseIP("127.0.0.1")}},
+			err:  "do not match the order identifiers",
+		},
+		{
+			name: "common name",
+			tpl:  &x509.CertificateRequest{Subject: pkix.Name{CommonName: "evil.test"}, DNSNames: []string{"app.test"}, IPAddresses: []net.IP{net.ParseIP("127.0.0.1")}},
+			err:  "common name evil.test",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, order := newTestACMEOrder(t, s, identifiers...)
+			_, err := finalizeTestOrder(s, req, order, newTestCSR(t, key, tt.tpl))
+			problem, ok := err.(*acmeProblem)
+			if !ok || problem.Type != "urn:ietf:params:acme:error:badCSR" || !strings.Contains(problem.Detail, tt.err) {
+				t.Errorf("got %v, want badCSR containing %q", err, tt.err)
+			}
+			if order.status != acmeStatusReady {
+				t.Errorf("order status: %s", order.status)
+			}
+		})
+	}
+
+	req, order := newTestACMEOrder(t, s, identifiers...)
+	cert, err := finalizeTestOrder(s, req, order, newTestCSR(t, key, &x509.CertificateRequest{
+		Subject:     pkix.Name{CommonName: "app.test", Organization: []string{"Test"}},
+		DNSNames:    []string{"APP.test"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameStrings(cert.DNSNames, []string{"app.test"}) || !sameStrings(ipStrings(cert.IPAddresses), []string{"127.0.0.1"}) ||
+		len(cert.EmailAddresses) != 0 || len(cert.URIs) != 0 {
+		t.Errorf("sans: %v %v %v %v", cert.DNSNames, cert.IPAddresses, cert.EmailAddresses, cert.URIs)
+	}
+	if len(cert.ExtKeyUsage) != 1 || cert.ExtKeyUsage[0] != x509.ExtKeyUsageServerAuth {
+		t.Errorf("ext key usage: %v", cert.ExtKeyUsage)
+	}
+}
+
+func TestACMEFinalizeAccountKey(t *testing.T) {
+	s := newTestACMEServer(t)
+	req, order := newTestACMEOrder(t, s, acmeIdentifier{Type: acmeIdentifierDNS, Value: "app.test"})
+	accountKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.account.key = &jose.JSONWebKey{Key: accountKey.Public()}
+	_, err = finalizeTestOrder(s, req, order, newTestCSR(t, accountKey, &x509.CertificateRequest{DNSNames: []string{"app.test"}}))
+	problem, ok := err.(*acmeProblem)
+	if !ok || problem.Type != "urn:ietf:params:acme:error:badCSR" || !strings.Contains(problem.Detail, "account key") {
+		t.Errorf("got %v", err)
+	}
+}
+
+// TestACMERevokeAfterRestart は再起動後も同じ鍵で登録したアカウントで証明書を失効できることを確認します。
+func TestACMERevokeAfterRestart(t *testing.T) {
+	s := newTestACMEServer(t)
+	accountKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	register := func(s *acmeServer, key *ecdsa.PrivateKey) *acmeAccount {
+		t.Helper()
+		req := &acmeRequest{base: "https://acme.test", jwk: &jose.JSONWebKey{Key: key.Public()}, payload: []byte("{}")}
+		if err := s.newAccount(httptest.NewRecorder(), req); err != nil {
+			t.Fatal(err)
+		}
+		thumbprint, err := acmeThumbprint(req.jwk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, account := range s.accounts {
+			if account.thumbprint == thumbprint {
+				return account
+			}
+		}
+		t.Fatal("account not found")
+		return nil
+	}
+	account := register(s, accountKey)
+	req, order := newTestACMEOrder(t, s, acmeIdentifier{Type: acmeIdentifierDNS, Value: "app.test"})
+	req.account, req.jwk = account, account.key
+	order.accountID = account.id
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := finalizeTestOrder(s, req, order, newTestCSR(t, certKey, &x509.CertificateRequest{DNSNames: []string{"app.test"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 同じCA、データベースで起動し直します
+	restarted := newACMEServer(s.caCert, s.caKey, s.database, nil)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(map[string]string{"certificate": base64.RawURLEncoding.EncodeToString(cert.Raw)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := register(restarted, otherKey)
+	err = restarted.revokeCert(httptest.NewRecorder(), &acmeRequest{account: other, jwk: other.key, payload: payload})
+	if problem, ok := err.(*acmeProblem); !ok || problem.Type != "urn:ietf:params:acme:error:unauthorized" {
+		t.Errorf("other account: %v", err)
+	}
+	again := register(restarted, accountKey)
+	if again.id != account.id {
+		t.Errorf("account id: got %s, want %s", again.id, account.id)
+	}
+	if err := restarted.revokeCert(httptest.NewRecorder(), &acmeRequest{account: again, jwk: again.key, payload: payload}); err != nil {
+		t.Fatal(err)
+	}
+	db, err := loadDatabase(s.database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry := db.find(cert.SerialNumber); entry == nil || entry.status != statusRevoked {
+		t.Errorf("entry: %+v", entry)
+	}
+}
@@ -0,0 +1,431 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/n-creativesystem/self-signed-certificate/pkg/ca"
+	"github.com/spf13/cobra"
+)
+
+func apiCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "api",
+		Short: "REST API",
+		Long:  "CAの秘密鍵で証明書の発行、一覧、失効を行うHTTP REST API",
+	}
+	cmd.AddCommand(serveAPICommand())
+	return &cmd
+}
+
+type apiServer struct {
+	mu         sync.Mutex
+	caCert     []byte
+	caKey      crypto.Signer
+	database   string
+	serialType string
+	days       int
+	profile    *certificateProfile
+	policy     *signingPolicy
+	tokens     []string
+	clientCA   *x509.CertPool
+}
+
+type apiError struct {
+	status  int
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+func newAPIError(status int, format string, a ...interface{}) *apiError {
+	return &apiError{status: status, message: fmt.Sprintf(format, a...)}
+}
+
+// apiRSABits はAPIで生成できるRSA鍵の鍵長です。鍵の生成中は他のリクエストを待たせるため制限します。
+var apiRSABits = []int{2048, 3072, 4096}
+
+func containsInt(values []int, n int) bool {
+	for _, v := range values {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+type apiIssueRequest struct {
+	CSR              string   `json:"csr"`
+	Usage            string   `json:"usage"`
+	KeyType          string   `json:"keyType"`
+	Bits             int      `json:"bits"`
+	Days             int      `json:"days"`
+	Country          []string `json:"country"`
+	Organization     []string `json:"organization"`
+	OrganizationUnit []string `json:"organizationUnit"`
+	CommonName       string   `json:"commonName"`
+	DNSNames         []string `json:"dnsNames"`
+	IPAddresses      []string `json:"ipAddresses"`
+	EmailAddresses   []string `json:"emailAddresses"`
+	URLs             []string `json:"urls"`
+}
+
+type apiCertificate struct {
+	Serial      string `json:"serial"`
+	Certificate string `json:"certificate"`
+	Chain       string `json:"chain"`
+	PrivateKey  string `json:"privateKey,omitempty"`
+}
+
+type apiRevokeRequest struct {
+	Reason string `json:"reason"`
+	Time   string `json:"time"`
+}
+
+type apiEntry struct {
+	Serial   string `json:"serial"`
+	Status   string `json:"status"`
+	NotAfter string `json:"notAfter,omitempty"`
+	Revoked  string `json:"revoked,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	File     string `json:"file"`
+	Subject  string `json:"subject"`
+}
+
+func (s *apiServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1/")
+	if path == r.URL.Path {
+		writeAPIError(w, newAPIError(http.StatusNotFound, "not found"))
+		return
+	}
+	if path == "ca" && r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		w.Write(s.caCert)
+		return
+	}
+	principal, ok := s.authenticate(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeAPIError(w, newAPIError(http.StatusUnauthorized, "unauthorized"))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resource := strings.Split(path, "/")
+	var v interface{}
+	var err error
+	status := http.StatusOK
+	switch {
+	case path == "certificates" && r.Method == http.MethodGet:
+		v, err = s.list(r.URL.Query().Get("status"))
+	case path == "certificates" && r.Method == http.MethodPost:
+		var req apiIssueRequest
+		if err = decodeAPIRequest(r, &req); err == nil {
+			v, err = s.issue(req, principal)
+			status = http.StatusCreated
+		}
+	case len(resource) == 2 && resource[0] == "certificates" && r.Method == http.MethodGet:
+		v, err = s.get(resource[1])
+	case len(resource) == 3 && resource[0] == "certificates" && resource[2] == "revoke" && r.Method == http.MethodPost:
+		var req apiRevokeRequest
+		if err = decodeAPIRequest(r, &req); err == nil {
+			v, err = s.revoke(resource[1], req, principal)
+		}
+	default:
+		err = newAPIError(http.StatusNotFound, "%s %s is not found", r.Method, r.URL.Path)
+	}
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeAPIJSON(w, status, v)
+}
+
+func (s *apiServer) authenticate(r *http.Request) (string, bool) {
+	if s.clientCA != nil && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return "cert:" + r.TLS.VerifiedChains[0][0].Subject.CommonName, true
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", false
+	}
+	token := []byte(strings.TrimPrefix(auth, "Bearer "))
+	for _, t := range s.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), token) == 1 {
+			return "token", true
+		}
+	}
+	return "", false
+}
+
+func (s *apiServer) issue(req apiIssueRequest, principal string) (*apiCertificate, error) {
+	var args serverArgs
+	args.serialType = s.serialType
+	args.caCert = s.caCert
+	args.caKey = s.caKey
+	args.certFilename = "api:" + principal
+	args.days = s.days
+	args.profile = s.profile
+	args.policy = s.policy
+	if req.Days != 0 {
+		maxDays := s.days
+		if s.profile != nil && s.profile.maxDays > 0 && s.profile.maxDays < maxDays {
+			maxDays = s.profile.maxDays
+		}
+		if req.Days < 0 || req.Days > maxDays {
+			return nil, newAPIError(http.StatusBadRequest, "days must be between 1 and %d", maxDays)
+		}
+		args.days = req.Days
+	}
+	switch req.Usage {
+	case "", "server":
+		args.extKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	case "client":
+		args.extKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	case "peer":
+		args.extKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	default:
+		return nil, newAPIError(http.StatusBadRequest, "invalid usage %s", req.Usage)
+	}
+	db, err := loadDatabase(s.database)
+	if err != nil {
+		return nil, err
+	}
+	args.db = db
+	cert := &bytes.Buffer{}
+	chain := &bytes.Buffer{}
+	key := &bytes.Buffer{}
+	args.cert = cert
+	args.chain = chain
+	if req.CSR != "" {
+		csr, err := readCSR(strings.NewReader(req.CSR))
+		if err != nil {
+			return nil, newAPIError(http.StatusBadRequest, "invalid csr: %v", err)
+		}
+		if err := csr.CheckSignature(); err != nil {
+			return nil, newAPIError(http.StatusBadRequest, "invalid csr signature: %v", err)
+		}
+		args.dnsNames = csr.DNSNames
+		args.ipAddresses = csr.IPAddresses
+		args.emails = csr.EmailAddresses
+		args.urls = csr.URIs
+		derCertificate, err := signCSR(args, csr)
+		if err != nil {
+			return nil, issueError(err)
+		}
+		if err := pem.Encode(cert, &pem.Block{Type: "CERTIFICATE", Bytes: derCertificate}); err != nil {
+			return nil, err
+		}
+		if err := writeChain(chain, derCertificate, s.caCert); err != nil {
+			return nil, err
+		}
+	} else {
+		args.keyType = req.KeyType
+		if args.keyType == "" {
+			args.keyType = ca.KeyTypeRSA
+		}
+		if !containsString(supportedKeyTypes, args.keyType) {
+			return nil, newAPIError(http.StatusBadRequest, "invalid key type %s", args.keyType)
+		}
+		args.bits = req.Bits
+		if args.bits == 0 {
+			args.bits = 2048
+		}
+		if args.keyType == ca.KeyTypeRSA && !containsInt(apiRSABits, args.bits) {
+			return nil, newAPIError(http.StatusBadRequest, "bits must be one of %v", apiRSABits)
+		}
+		args.country = req.Country
+		args.organization = req.Organization
+		args.organizationUnit = req.OrganizationUnit
+		args.commonName = req.CommonName
+		args.dnsNames = req.DNSNames
+		args.emails = req.EmailAddresses
+		for _, strIP := range req.IPAddresses {
+			ip := net.ParseIP(strIP)
+			if ip == nil {
+				return nil, newAPIError(http.StatusBadRequest, "invalid ip address %s", strIP)
+			}
+			args.ipAddresses = append(args.ipAddresses, ip)
+		}
+		for _, raw := range req.URLs {
+			u, err := url.Parse(raw)
+			if err != nil {
+				return nil, newAPIError(http.StatusBadRequest, "invalid url %s", raw)
+			}
+			args.urls = append(args.urls, u)
+		}
+		args.key = key
+		if err := runServerCertificate(args); err != nil {
+			return nil, issueError(err)
+		}
+	}
+	if err := db.save(); err != nil {
+		return nil, err
+	}
+	certificate, err := ca.ParseCertificate(cert.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	serial := formatSerial(certificate.SerialNumber)
+	log.Printf("api: %s issued serial %s (%s)", principal, serial, formatSubject(certificate.Subject))
+	return &apiCertificate{
+		Serial:      serial,
+		Certificate: cert.String(),
+		Chain:       chain.String(),
+		PrivateKey:  key.String(),
+	}, nil
+}
+
+func (s *apiServer) list(status string) ([]apiEntry, error) {
+	switch status {
+	case "", "valid", "revoked", "expired":
+	default:
+		return nil, newAPIError(http.StatusBadRequest, "invalid status %s", status)
+	}
+	db, err := loadDatabase(s.database)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	entries := []apiEntry{}
+	for _, entry := range db.entries {
+		e := newAPIEntry(entry, now)
+		if status != "" && status != e.Status {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func (s *apiServer) get(rawSerial string) (*apiEntry, error) {
+	serial, err := parseAPISerial(rawSerial)
+	if err != nil {
+		return nil, err
+	}
+	db, err := loadDatabase(s.database)
+	if err != nil {
+		return nil, err
+	}
+	entry := db.find(serial)
+	if entry == nil {
+		return nil, newAPIError(http.StatusNotFound, "serial %s is not found", rawSerial)
+	}
+	e := newAPIEntry(entry, time.Now())
+	return &e, nil
+}
+
+func (s *apiServer) revoke(rawSerial string, req apiRevokeRequest, principal string) (*apiEntry, error) {
+	serial, err := parseAPISerial(rawSerial)
+	if err != nil {
+		return nil, err
+	}
+	reason, err := parseRevocationReason(req.Reason)
+	if err != nil {
+		return nil, newAPIError(http.StatusBadRequest, "%v", err)
+	}
+	revoked := time.Now()
+	if req.Time != "" {
+		revoked, err = time.Parse(time.RFC3339, req.Time)
+		if err != nil {
+			return nil, newAPIError(http.StatusBadRequest, "invalid time %s (RFC 3339)", req.Time)
+		}
+	}
+	db, err := loadDatabase(s.database)
+	if err != nil {
+		return nil, err
+	}
+	entry := db.find(serial)
+	if entry == nil {
+		return nil, newAPIError(http.StatusNotFound, "serial %s is not found", rawSerial)
+	}
+	if err := db.revoke(entry, revoked, reason); err != nil {
+		return nil, newAPIError(http.StatusConflict, "%v", err)
+	}
+	if err := db.save(); err != nil {
+		return nil, err
+	}
+	log.Printf("api: %s revoked serial %s", principal, formatSerial(serial))
+	e := newAPIEntry(entry, time.Now())
+	return &e, nil
+}
+
+func newAPIEntry(entry *indexEntry, now time.Time) apiEntry {
+	e := apiEntry{
+		Serial:  formatSerial(entry.serial),
+		Status:  statusNames[entry.currentStatus(now)],
+		File:    entry.filename,
+		Subject: entry.subject,
+	}
+	if !entry.expiry.IsZero() {
+		e.NotAfter = entry.expiry.UTC().Format(time.RFC3339)
+	}
+	if entry.status == statusRevoked {
+		e.Revoked = entry.revoked.UTC().Format(time.RFC3339)
+		e.Reason = revocationReasons[entry.reason]
+	}
+	return e
+}
+
+// issueError は発行時のエラーのうち、ポリシーや名前制約に違反するリクエストによるものを400にします。
+// それ以外(秘密鍵、データベースなど)のエラーはそのまま返し、writeAPIError で500にします。
+func issueError(err error) error {
+	var perr *policyError
+	var nerr *ca.NameConstraintError
+	if errors.As(err, &perr) || errors.As(err, &nerr) {
+		return newAPIError(http.StatusBadRequest, "%v", err)
+	}
+	return err
+}
+
+func parseAPISerial(s string) (*big.Int, error) {
+	serial, ok := new(big.Int).SetString(strings.ReplaceAll(s, ":", ""), 16)
+	if !ok || serial.Sign() <= 0 {
+		return nil, newAPIError(http.StatusBadRequest, "invalid serial number %s", s)
+	}
+	return serial, nil
+}
+
+func decodeAPIRequest(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(io.LimitReader(r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil && err != io.EOF {
+		return newAPIError(http.StatusBadRequest, "invalid request: %v", err)
+	}
+	return nil
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, err error) {
+	e, ok := err.(*apiError)
+	if !ok {
+		log.Printf("api: %v", err)
+		e = newAPIError(http.StatusInternalServerError, "internal server error")
+	}
+	writeAPIJSON(w, e.status, map[string]string{"error": e.message})
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func serveAPICommand() *cobra.Command {
+	initialize := initialize("api_config")
+	cmd := cobra.Command{
+		Use:   "serve",
+		Short: "REST API起動",
+		Long:  "証明書の発行(CSR または subject/SAN 指定)、一覧、失効を行うJSON APIをHTTPSで起動します。Bearerトークン(--token)またはクライアント証明書(--clientCA)で認証します",
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := cmd.Flags().GetString("config")
+			if err != nil {
+				errorExit(err)
+			}
+			initialize(cmd, config)
+			caCertFilename := viper.GetString("caCert")
+			var server apiServer
+			server.caCert, server.caKey, err = readCERTandKEY(caCertFilename, viper.GetString("caKey"), viper.GetString("caKeyURI"))
+			if err != nil {
+				errorExit(err)
+			}
+			server.database = databasePath(caCertFilename, viper.GetString("database"))
+			server.serialType = viper.GetString("serialType")
+			server.days = viper.GetInt("days")
+			server.profile, err = parseProfile()
+			if err != nil {
+				errorExit(err)
+			}
+			server.profile.setDefaults(&server.days, nil, nil)
+			server.policy, err = parseSigningPolicy()
+			if err != nil {
+				errorExit(err)
+			}
+			server.tokens = viper.GetStringSlice("token")
+			tlsConfig := &tls.Config{}
+			if clientCAFilename := viper.GetString("clientCA"); clientCAFilename != "" {
+				clientCA, err := os.ReadFile(clientCAFilename)
+				if err != nil {
+					errorExit(err)
+				}
+				server.clientCA = x509.NewCertPool()
+				if !server.clientCA.AppendCertsFromPEM(clientCA) {
+					errorExit(errors.New("no certificate found in " + clientCAFilename))
+				}
+				tlsConfig.ClientCAs = server.clientCA
+				tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+			}
+			if len(server.tokens) == 0 && server.clientCA == nil {
+				errorExit(errors.New("--token or --clientCA is required"))
+			}
+
+			var certificate tls.Certificate
+			if tlsCertFilename := viper.GetString("tlsCert"); tlsCertFilename != "" {
+				certificate, err = tls.LoadX509KeyPair(tlsCertFilename, viper.GetString("tlsKey"))
+			} else {
+				var tlsArg serverArgs
+				tlsArg.serialType = server.serialType
+				tlsArg.days = server.days
+				tlsArg.caCert = server.caCert
+				tlsArg.caKey = server.caKey
+				tlsArg.certFilename = "api:tls"
+				tlsArg.db, err = loadDatabase(server.database)
+				if err != nil {
+					errorExit(err)
+				}
+				certificate, err = servingCertificate(tlsArg, viper.GetStringSlice("hostname"))
+				if err == nil {
+					err = tlsArg.db.save()
+				}
+			}
+			if err != nil {
+				errorExit(err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{certificate}
+			listen := viper.GetString("listen")
+			httpServer := &http.Server{
+				Addr:      listen,
+				Handler:   &server,
+				TLSConfig: tlsConfig,
+			}
+			log.Printf("api: listening on %s", listen)
+			if err := httpServer.ListenAndServeTLS("", ""); err != nil {
+				errorExit(err)
+			}
+		},
+	}
+	flags := cmd.Flags()
+	flags.String("config", "", "api configuration")
+	flags.String("caCert", "ca.crt", "ca cert file name")
+	flags.String("caKey", "ca.key", "ca private key file name")
+	flags.String("caKeyURI", "", "ca private key pkcs11 uri (e.g. pkcs11:token=ca;object=ca-key?module-path=/usr/lib/softhsm/libsofthsm2.so)")
+	flags.String("database", "", "certificate database file name (default: <ca cert name>.index.txt)")
+	flags.String("serialType", serialTypeSequential, "serial number allocation (sequential, random)")
+	flags.Int("days", 365, "default and maximum days of issued certificates")
+	flags.String("listen", ":8443", "listen address")
+	flags.String("tlsCert", "", "https server cert file name (default: issued from the ca for --hostname)")
+	flags.String("tlsKey", "", "https server private key file name")
+	flags.StringSlice("hostname", []string{"localhost"}, "host names of the https server cert issued from the ca")
+	flags.StringSlice("token", nil, "bearer tokens allowed to call the api (env: SELF_CERT_TOKEN)")
+	flags.String("clientCA", "", "ca cert file name to verify client certificates (mTLS)")
+	addProfileFlag(flags)
+	return &cmd
+}
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/n-creativesystem/self-signed-certificate/pkg/ca"
+)
+
+func newTestAPIServer(t *testing.T) *apiServer {
+	t.Helper()
+	authority, err := ca.New(&ca.IssueRequest{
+		Subject:         pkix.Name{CommonName: "API Test CA"},
+		KeyType:         ca.KeyTypeECDSAP256,
+		NameConstraints: ca.NameConstraints{PermittedDNSDomains: []string{"internal"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &apiServer{
+		caCert:     authority.CertificatePEM(),
+		caKey:      authority.Signer,
+		database:   filepath.Join(t.TempDir(), "ca.index.txt"),
+		serialType: serialTypeSequential,
+		days:       90,
+		tokens:     []string{"secret"},
+	}
+}
+
+func postTestAPI(s *apiServer, body string) (int, string) {
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/certificates", strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	var resp map[string]string
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w.Code, resp["error"]
+}
+
+// TestAPIIssueError はリクエストによるエラーを400、サーバー側のエラーを詳細を返さずに500にすることを確認します。
+func TestAPIIssueError(t *testing.T) {
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		setup  func(s *apiServer)
+		body   string
+		status int
+		err    string
+	}{
+		{
+			name:   "issued",
+			body:   `{"commonName":"app.internal","dnsNames":["app.internal"],"keyType":"ecdsa-p256"}`,
+			status: http.StatusCreated,
+		},
+		{
+			name:   "policy",
+			setup:  func(s *apiServer) { s.policy = &signingPolicy{allowedDomains: []string{"app.internal"}} },
+			body:   `{"commonName":"db.internal","dnsNames":["db.internal"],"keyType":"ecdsa-p256"}`,
+			status: http.StatusBadRequest,
+			err:    "policy: common name db.internal is not allowed",
+		},
+		{
+			name:   "name constraints",
+			body:   `{"commonName":"app.example.com","dnsNames":["app.example.com"],"keyType":"ecdsa-p256"}`,
+			status: http.StatusBadRequest,
+			err:    "dns name app.example.com is not permitted by the ca name constraints",
+		},
+		{
+			name:   "key type",
+			body:   `{"commonName":"app.internal","keyType":"dsa"}`,
+			status: http.StatusBadRequest,
+			err:    "invalid key type dsa",
+		},
+		{
+			name:   "profile max days",
+			setup:  func(s *apiServer) { s.profile = &certificateProfile{name: "short", maxDays: 30} },
+			body:   `{"commonName":"app.internal","keyType":"ecdsa-p256","days":60}`,
+			status: http.StatusBadRequest,
+			err:    "days must be between 1 and 30",
+		},
+		{
+			name:   "database",
+			setup:  func(s *apiServer) { s.database = t.TempDir() },
+			body:   `{"commonName":"app.internal","keyType":"ecdsa-p256"}`,
+			status: http.StatusInternalServerError,
+			err:    "internal server error",
+		},
+		{
+			name:   "ca key",
+			setup:  func(s *apiServer) { s.caKey = otherKey },
+			body:   `{"commonName":"app.internal","keyType":"ecdsa-p256"}`,
+			status: http.StatusInternalServerError,
+			err:    "internal server error",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestAPIServer(t)
+			if tt.setup != nil {
+				tt.setup(s)
+			}
+			status, message := postTestAPI(s, tt.body)
+			if status != tt.status || message != tt.err {
+				t.Errorf("got %d %q, want %d %q", status, message, tt.status, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,455 @@
+package cmd
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/n-creativesystem/self-signed-certificate/pkg/ca"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func applyCommand() *cobra.Command {
+	initialize := initialize("apply_config")
+	cmd := cobra.Command{
+		Use:   "apply",
+		Short: "manifestに定義したCAと証明書の作成、更新",
+		Long: `manifestファイルに定義したCA、中間CA、証明書のうち、存在しないもの、有効期限が近いもの、定義と異なるものだけを作成、更新します。
+実行前に変更内容(plan)を表示し、確認します`,
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := cmd.Flags().GetString("config")
+			if err != nil {
+				errorExit(err)
+			}
+			initialize(cmd, config)
+			var applyArg applyArgs
+			applyArg.manifest, err = loadManifest(viper.GetString("file"))
+			if err != nil {
+				errorExit(err)
+			}
+			applyArg.planOnly = viper.GetBool("plan")
+			applyArg.autoApprove = viper.GetBool("autoApprove")
+			applyArg.keyEncryption = parseKeyEncryption()
+			applyArg.in = os.Stdin
+			applyArg.out = os.Stdout
+			if err := runApply(applyArg); err != nil {
+				errorExit(err)
+			}
+		},
+	}
+	flags := cmd.Flags()
+	flags.String("config", "", "apply configuration")
+	flags.StringP("file", "f", "pki.yaml", "manifest file name")
+	flags.Bool("plan", false, "show the plan without applying it")
+	flags.Bool("autoApprove", false, "apply the plan without confirmation")
+	addKeyEncryptionFlags(flags)
+	return &cmd
+}
+
+const (
+	applyKindCA          = "ca"
+	applyKindCertificate = "cert"
+)
+
+const (
+	applyNoChange = ""
+	applyCreate   = "create"
+	applyRenew    = "renew"
+	applyReplace  = "replace"
+)
+
+var applySymbols = map[string]string{
+	applyNoChange: "=",
+	applyCreate:   "+",
+	applyRenew:    "~",
+	applyReplace:  "-/+",
+}
+
+type applyArgs struct {
+	manifest      *manifest
+	planOnly      bool
+	autoApprove   bool
+	keyEncryption keyEncryption
+	in            io.Reader
+	out           io.Writer
+}
+
+// applyStep はmanifestのCAまたは証明書1つと、その変更内容です。
+// renew は既存の秘密鍵で証明書を再発行し、replace は秘密鍵も作り直します。
+type applyStep struct {
+	kind    string
+	entry   manifestEntry
+	issuer  *applyStep
+	action  string
+	reasons []string
+	cert    *x509.Certificate
+	// key は再利用する既存の秘密鍵です。nil の場合は新しく生成します。
+	key       crypto.Signer
+	authority *ca.CA
+}
+
+func runApply(args applyArgs) error {
+	steps, err := args.manifest.resolve()
+	if err != nil {
+		return err
+	}
+	renewBefore := time.Hour * 24 * time.Duration(args.manifest.RenewBefore)
+	now := time.Now()
+	for _, step := range steps {
+		if err := step.plan(now, renewBefore); err != nil {
+			return fmt.Errorf("%s %s: %w", step.kind, step.entry.Name, err)
+		}
+	}
+	if changes := printPlan(args.out, steps); changes == 0 || args.planOnly {
+		return nil
+	}
+	if !args.autoApprove {
+		fmt.Fprint(args.out, "\nDo you want to perform these actions? Only 'yes' will be accepted: ")
+		answer, _ := bufio.NewReader(args.in).ReadString('\n')
+		if strings.TrimSpace(answer) != "yes" {
+			return errors.New("apply cancelled")
+		}
+	}
+	fmt.Fprintln(args.out)
+	// 新しく作成する秘密鍵のパスフレーズは1回だけ取得します
+	encryption := args.keyEncryption
+	if encryption.encrypt && encryption.passphrase == nil {
+		for _, step := range steps {
+			if step.action != applyNoChange && step.key == nil {
+				if encryption.passphrase, err = encryption.readPassphrase(); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+	dbs := map[string]*database{}
+	for _, step := range steps {
+		if err := step.apply(dbs, encryption); err != nil {
+			return fmt.Errorf("%s %s: %w", step.kind, step.entry.Name, err)
+		}
+		if step.action != applyNoChange {
+			fmt.Fprintf(args.out, "%s %s: %s (%s)\n", step.kind, step.entry.Name, step.action, step.entry.Cert)
+		}
+	}
+	return nil
+}
+
+// plan は既存のファイルとmanifestの定義を比較して action を決めます。
+// 上位のCAから順に呼び出す必要があります。
+func (step *applyStep) plan(now time.Time, renewBefore time.Duration) error {
+	certPEM, err := os.ReadFile(step.entry.Cert)
+	if errors.Is(err, os.ErrNotExist) {
+		step.action = applyCreate
+		// 証明書がなく秘密鍵だけがある場合は、鍵の種類が同じであれば再利用します
+		if key, err := readExistingKey(step.entry.Key); err == nil && key != nil && step.keyMatches(key.Public()) == "" {
+			step.key = key
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if step.cert, err = ca.ParseCertificate(certPEM); err != nil {
+		return fmt.Errorf("%s: %w", step.entry.Cert, err)
+	}
+	key, err := readExistingKey(step.entry.Key)
+	if err != nil {
+		return err
+	}
+	switch {
+	case key == nil:
+		step.replace("private key not found")
+	case !publicKeyEqual(key.Public(), step.cert.PublicKey):
+		step.replace("private key does not match the certificate")
+	default:
+		if reason := step.keyMatches(key.Public()); reason != "" {
+			step.replace(reason)
+		}
+	}
+	if step.action == applyReplace {
+		return nil
+	}
+	step.key = key
+	step.reasons = step.drift()
+	switch {
+	case step.issuer == nil:
+		if err := step.cert.CheckSignatureFrom(step.cert); err != nil {
+			step.reasons = append(step.reasons, "certificate is not self-signed")
+		}
+	case step.issuer.action != applyNoChange:
+		step.reasons = append(step.reasons, fmt.Sprintf("issuer %s: %s", step.issuer.entry.Name, step.issuer.action))
+	default:
+		if err := step.cert.CheckSignatureFrom(step.issuer.cert); err != nil {
+			step.reasons = append(step.reasons, fmt.Sprintf("certificate is not issued by %s", step.issuer.entry.Name))
+		}
+	}
+	// 有効期間が renewBefore 以下の証明書を毎回更新しないよう、有効期間の1/3までにします
+	if validity := time.Hour * 24 * time.Duration(step.entry.Days) / 3; renewBefore > validity {
+		renewBefore = validity
+	}
+	if remaining := step.cert.NotAfter.Sub(now); remaining <= 0 {
+		step.reasons = append(step.reasons, "expired")
+	} else if remaining < renewBefore {
+		step.reasons = append(step.reasons, fmt.Sprintf("expires in %d days", int(remaining.Hours()/24)))
+	}
+	if len(step.reasons) > 0 {
+		step.action = applyRenew
+	}
+	return nil
+}
+
+func (step *applyStep) replace(reason string) {
+	step.action = applyReplace
+	step.reasons = append(step.reasons, reason)
+}
+
+// keyMatches は公開鍵がmanifestの鍵の種類と異なる場合に理由を返します。
+func (step *applyStep) keyMatches(pub crypto.PublicKey) string {
+	keyType, bits, err := ca.KeyTypeOf(pub)
+	if err != nil {
+		return err.Error()
+	}
+	if keyType != step.entry.KeyType {
+		return fmt.Sprintf("key type %s -> %s", keyType, step.entry.KeyType)
+	}
+	if keyType == ca.KeyTypeRSA && bits != step.entry.Bits {
+		return fmt.Sprintf("rsa bits %d -> %d", bits, step.entry.Bits)
+	}
+	return ""
+}
+
+// drift は既存の証明書とmanifestの定義の差分を返します。
+func (step *applyStep) drift() []string {
+	var reasons []string
+	cert := step.cert
+	entry := &step.entry
+	subject := entry.subject()
+	if cert.Subject.CommonName != subject.CommonName ||
+		!sameStrings(cert.Subject.Country, subject.Country) ||
+		!sameStrings(cert.Subject.Organization, subject.Organization) ||
+		!sameStrings(cert.Subject.OrganizationalUnit, subject.OrganizationalUnit) {
+		reasons = append(reasons, "subject changed")
+	}
+	if step.kind == applyKindCA {
+		if !cert.IsCA {
+			return append(reasons, "certificate is not a CA")
+		}
+		if step.issuer != nil && (cert.MaxPathLen != entry.MaxPathLen || (entry.MaxPathLen == 0 && !cert.MaxPathLenZero)) {
+			reasons = append(reasons, "maxPathLen changed")
+		}
+		if !sameStrings(cert.PermittedDNSDomains, entry.PermittedDNSDomains) ||
+			!sameStrings(cert.ExcludedDNSDomains, entry.ExcludedDNSDomains) ||
+			!sameStrings(ipNetStrings(cert.PermittedIPRanges), entry.PermittedIPRanges) ||
+			!sameStrings(ipNetStrings(cert.ExcludedIPRanges), entry.ExcludedIPRanges) ||
+			!sameStrings(cert.PermittedEmailAddresses, entry.PermittedEmailAddresses) ||
+			!sameStrings(cert.ExcludedEmailAddresses, entry.ExcludedEmailAddresses) ||
+			!sameStrings(cert.PermittedURIDomains, entry.PermittedURIDomains) ||
+			!sameStrings(cert.ExcludedURIDomains, entry.ExcludedURIDomains) {
+			reasons = append(reasons, "name constraints changed")
+		}
+		return reasons
+	}
+	if cert.IsCA {
+		return append(reasons, "certificate is a CA")
+	}
+	var urls []string
+	for _, u := range cert.URIs {
+		urls = append(urls, u.String())
+	}
+	if !sameStrings(cert.DNSNames, entry.DNSNames) ||
+		!sameStrings(ipStrings(cert.IPAddresses), normalizeIPs(entry.IPAddresses)) ||
+		!sameStrings(cert.EmailAddresses, entry.EmailAddresses) ||
+		!sameStrings(urls, entry.URLs) {
+		reasons = append(reasons, "subject alternative names changed")
+	}
+	extKeyUsage, _ := entry.extKeyUsage()
+	var want, got []string
+	for _, usage := range extKeyUsage {
+		want = append(want, extKeyUsageName(usage))
+	}
+	for _, usage := range cert.ExtKeyUsage {
+		got = append(got, extKeyUsageName(usage))
+	}
+	if !sameStrings(got, want) {
+		reasons = append(reasons, "type changed")
+	}
+	return reasons
+}
+
+func (step *applyStep) apply(dbs map[string]*database, encryption keyEncryption) error {
+	var issuer *ca.CA
+	if step.issuer != nil {
+		issuer = step.issuer.authority
+	}
+	if step.action == applyNoChange {
+		if step.kind == applyKindCA {
+			step.authority = &ca.CA{Certificate: step.cert, Signer: step.key}
+			if issuer != nil {
+				step.authority.Chain = append([]*x509.Certificate{issuer.Certificate}, issuer.Chain...)
+			}
+		}
+		return nil
+	}
+	req, err := step.request()
+	if err != nil {
+		return err
+	}
+	var db *database
+	if issuer != nil {
+		if db, err = step.issuer.database(dbs); err != nil {
+			return err
+		}
+		db.reserve(issuer.Certificate)
+		if req.SerialNumber, err = db.allocateSerial(0, serialTypeSequential); err != nil {
+			return err
+		}
+	} else if step.action == applyCreate {
+		req.SerialNumber = big.NewInt(1)
+	}
+	var cert *x509.Certificate
+	var chainPEM []byte
+	key := step.key
+	switch {
+	case step.kind == applyKindCA && issuer == nil:
+		if key != nil {
+			step.authority, err = ca.NewWithSigner(req, key)
+		} else {
+			step.authority, err = ca.New(req)
+		}
+	case step.kind == applyKindCA:
+		if key != nil {
+			step.authority, err = issuer.NewIntermediateWithSigner(req, key)
+		} else {
+			step.authority, err = issuer.NewIntermediate(req)
+		}
+	default:
+		if key != nil {
+			req.PublicKey = key.Public()
+		}
+		var issued *ca.Certificate
+		if issued, err = issuer.Issue(req); err == nil {
+			cert, chainPEM = issued.Certificate, issued.ChainPEM()
+			if key == nil {
+				key = issued.PrivateKey
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if step.authority != nil {
+		cert, chainPEM = step.authority.Certificate, step.authority.ChainPEM()
+		key = step.authority.Signer
+	}
+	if db != nil {
+		if err := db.add(cert, step.entry.Cert); err != nil {
+			return err
+		}
+		if err := db.save(); err != nil {
+			return err
+		}
+	}
+	if step.key == nil {
+		keyPEM, err := encryption.marshal(key)
+		if err != nil {
+			return err
+		}
+		if err := writeApplyFile(step.entry.Key, keyPEM, 0600); err != nil {
+			return err
+		}
+	}
+	if err := writeApplyFile(step.entry.Cert, encodeCertificate(cert), 0644); err != nil {
+		return err
+	}
+	if step.entry.Chain != "" {
+		return writeApplyFile(step.entry.Chain, chainPEM, 0644)
+	}
+	return nil
+}
+
+// database はCAの証明書データベースを読み込みます。同じCAのデータベースは1回だけ読み込みます。
+func (step *applyStep) database(dbs map[string]*database) (*database, error) {
+	filename := databasePath(step.entry.Cert, "")
+	if db, ok := dbs[filename]; ok {
+		return db, nil
+	}
+	db, err := loadDatabase(filename)
+	if err != nil {
+		return nil, err
+	}
+	dbs[filename] = db
+	return db, nil
+}
+
+func printPlan(w io.Writer, steps []*applyStep) int {
+	width := 0
+	for _, step := range steps {
+		if len(step.entry.Name) > width {
+			width = len(step.entry.Name)
+		}
+	}
+	counts := map[string]int{}
+	for _, step := range steps {
+		counts[step.action]++
+		fmt.Fprintf(w, "%3s %-4s %-*s %s\n", applySymbols[step.action], step.kind, width, step.entry.Name, step.entry.Cert)
+		for _, reason := range step.reasons {
+			fmt.Fprintf(w, "%*s- %s\n", width+10, "", reason)
+		}
+	}
+	changes := counts[applyCreate] + counts[applyRenew] + counts[applyReplace]
+	if changes == 0 {
+		fmt.Fprintf(w, "\nNo changes. %d unchanged.\n", counts[applyNoChange])
+		return 0
+	}
+	fmt.Fprintf(w, "\nPlan: %d to create, %d to renew, %d to replace, %d unchanged.\n",
+		counts[applyCreate], counts[applyRenew], counts[applyReplace], counts[applyNoChange])
+	return changes
+}
+
+// readExistingKey は秘密鍵ファイルを読み込みます。ファイルが存在しない場合は nil を返します。
+func readExistingKey(filename string) (crypto.Signer, error) {
+	if _, err := os.Stat(filename); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return readKeyFile(filename)
+}
+
+func publicKeyEqual(a, b crypto.PublicKey) bool {
+	public, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && public.Equal(b)
+}
+
+func writeApplyFile(filename string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, perm)
+}
+
+// sameStrings は順序を区別せずに2つの文字列の集合を比較します。
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	x := append([]string(nil), a...)
+	y := append([]string(nil), b...)
+	sort.Strings(x)
+	sort.Strings(y)
+	for i := range x {
+		if x[i] != y[i] {
+			return false
+		}
+	}
+	return true
+}
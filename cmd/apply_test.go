@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/n-creativesystem/self-signed-certificate/pkg/ca"
+)
+
+func runTestApply(t *testing.T, m *manifest) string {
+	t.Helper()
+	out := &bytes.Buffer{}
+	if err := runApply(applyArgs{manifest: m, autoApprove: true, in: strings.NewReader(""), out: out}); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+// TestApplyShortLived は renewBefore より有効期間の短い証明書を毎回更新しないことを確認します。
+func TestApplyShortLived(t *testing.T) {
+	m := &manifest{
+		Dir:         t.TempDir(),
+		RenewBefore: 30,
+		CAs:         []manifestEntry{{Name: "root", KeyType: ca.KeyTypeECDSAP256}},
+		Certificates: []manifestEntry{
+			{Name: "app", Issuer: "root", KeyType: ca.KeyTypeECDSAP256, Days: 7, DNSNames: []string{"app.test"}},
+		},
+	}
+	if out := runTestApply(t, m); !strings.Contains(out, "Plan: 2 to create") {
+		t.Fatalf("first apply:\n%s", out)
+	}
+	if out := runTestApply(t, m); !strings.Contains(out, "No changes. 2 unchanged.") {
+		t.Errorf("second apply:\n%s", out)
+	}
+}
+
+func TestApplyEncryptKey(t *testing.T) {
+	t.Setenv(envPrefix+"_NEW_PASSPHRASE", "secret")
+	t.Setenv(envPrefix+"_PASSPHRASE", "secret")
+	m := &manifest{
+		Dir:          t.TempDir(),
+		RenewBefore:  30,
+		CAs:          []manifestEntry{{Name: "root", KeyType: ca.KeyTypeECDSAP256}},
+		Certificates: []manifestEntry{{Name: "app", Issuer: "root", KeyType: ca.KeyTypeECDSAP256, DNSNames: []string{"app.test"}}},
+	}
+	out := &bytes.Buffer{}
+	args := applyArgs{manifest: m, autoApprove: true, keyEncryption: keyEncryption{encrypt: true, kdf: ca.KDFPBKDF2}, out: out}
+	if err := runApply(args); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"root.key", "app.key"} {
+		keyPEM, err := os.ReadFile(filepath.Join(m.Dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ca.ParsePrivateKeyPEM(keyPEM); !errors.Is(err, ca.ErrEncryptedPrivateKey) {
+			t.Errorf("%s must be encrypted: %v", name, err)
+		}
+	}
+	// 暗号化した秘密鍵を読み込んで、変更がないことを確認します
+	if out := runTestApply(t, m); !strings.Contains(out, "No changes. 2 unchanged.") {
+		t.Errorf("second apply:\n%s", out)
+	}
+}
@@ -1,7 +1,14 @@
 package cmd
 
 import (
+	"crypto/x509/pkix"
+	"fmt"
+	"net"
+
+	"github.com/n-creativesystem/self-signed-certificate/pkg/ca"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
 )
 
 func caCommand() *cobra.Command {
@@ -12,12 +19,19 @@ func caCommand() *cobra.Command {
 	}
 	cmd.AddCommand(newCACommand())
 	cmd.AddCommand(updateCACommand())
+	cmd.AddCommand(rolloverCACommand())
+	cmd.AddCommand(intermediateCACommand())
+	cmd.AddCommand(revokeCACommand())
+	cmd.AddCommand(crlCACommand())
+	cmd.AddCommand(listCACommand())
+	cmd.AddCommand(showCACommand())
 	return &cmd
 }
 
 type caArgs struct {
 	serialNumber     int
 	bits             int
+	keyType          string
 	country          []string
 	organization     []string
 	organizationUnit []string
@@ -25,4 +39,53 @@ type caArgs struct {
 	certFile         readWrite
 	keyFile          readWrite
 	days             int
+	nameConstraints  ca.NameConstraints
+	keyEncryption    keyEncryption
+	keyURI           string
+	profile          *certificateProfile
+	extraExtensions  []pkix.Extension
+}
+
+func addNameConstraintFlags(flags *pflag.FlagSet) {
+	flags.StringSlice("permittedDNSDomains", nil, "name constraints: permitted dns domains")
+	flags.StringSlice("excludedDNSDomains", nil, "name constraints: excluded dns domains")
+	flags.StringSlice("permittedIPRanges", nil, "name constraints: permitted ip ranges (cidr)")
+	flags.StringSlice("excludedIPRanges", nil, "name constraints: excluded ip ranges (cidr)")
+	flags.StringSlice("permittedEmailAddresses", nil, "name constraints: permitted email addresses or domains")
+	flags.StringSlice("excludedEmailAddresses", nil, "name constraints: excluded email addresses or domains")
+	flags.StringSlice("permittedURIDomains", nil, "name constraints: permitted uri domains")
+	flags.StringSlice("excludedURIDomains", nil, "name constraints: excluded uri domains")
+	flags.Bool("nameConstraintsCritical", true, "mark the name constraints extension critical")
+}
+
+func parseNameConstraints() (ca.NameConstraints, error) {
+	nc := ca.NameConstraints{
+		Critical:                viper.GetBool("nameConstraintsCritical"),
+		PermittedDNSDomains:     viper.GetStringSlice("permittedDNSDomains"),
+		ExcludedDNSDomains:      viper.GetStringSlice("excludedDNSDomains"),
+		PermittedEmailAddresses: viper.GetStringSlice("permittedEmailAddresses"),
+		ExcludedEmailAddresses:  viper.GetStringSlice("excludedEmailAddresses"),
+		PermittedURIDomains:     viper.GetStringSlice("permittedURIDomains"),
+		ExcludedURIDomains:      viper.GetStringSlice("excludedURIDomains"),
+	}
+	var err error
+	if nc.PermittedIPRanges, err = parseIPRanges(viper.GetStringSlice("permittedIPRanges")); err != nil {
+		return nc, err
+	}
+	if nc.ExcludedIPRanges, err = parseIPRanges(viper.GetStringSlice("excludedIPRanges")); err != nil {
+		return nc, err
+	}
+	return nc, nil
+}
+
+func parseIPRanges(cidrs []string) ([]*net.IPNet, error) {
+	var ranges []*net.IPNet
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ip range %s", cidr)
+		}
+		ranges = append(ranges, ipNet)
+	}
+	return ranges, nil
 }
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/n-creativesystem/self-signed-certificate/pkg/ca"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func crlCACommand() *cobra.Command {
+	initialize := initialize("ca_config")
+	cmd := cobra.Command{
+		Use:   "crl",
+		Short: "証明書失効リスト(CRL)作成",
+		Long:  `CAのデータベースに記録された失効情報から、CAの秘密鍵で署名したCRLを作成します`,
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := cmd.Flags().GetString("config")
+			if err != nil {
+				errorExit(err)
+			}
+			initialize(cmd, config)
+			var crlArg crlArgs
+			certFilename := viper.GetString("cert")
+			crlArg.caCert, crlArg.caKey, err = readCERTandKEY(certFilename, viper.GetString("key"), viper.GetString("keyURI"))
+			if err != nil {
+				errorExit(err)
+			}
+			crlArg.database = databasePath(certFilename, viper.GetString("database"))
+			crlArg.crlNumber = viper.GetString("crlNumber")
+			if crlArg.crlNumber == "" {
+				crlArg.crlNumber = caFilePath(certFilename, ".crlnumber")
+			}
+			crlArg.days = viper.GetInt("crlDays")
+			crlArg.format = viper.GetString("format")
+			crlFilename := viper.GetString("crl")
+			crlArg.crl = &bytes.Buffer{}
+			if err := runCRL(crlArg); err != nil {
+				errorExit(err)
+			}
+			fileCreate(crlFilename, crlArg.crl)
+		},
+	}
+	flags := cmd.Flags()
+	flags.String("config", "", "CA configuration")
+	flags.String("cert", "ca.crt", "ca cert file name")
+	flags.String("key", "ca.key", "ca private key file name")
+	flags.String("keyURI", "", "ca private key pkcs11 uri (instead of --key)")
+	flags.String("database", "", "certificate database file name (default: <ca cert name>.index.txt)")
+	flags.String("crlNumber", "", "crl number file name (default: <ca cert name>.crlnumber)")
+	flags.Int("crlDays", 30, "days until next update")
+	flags.String("format", "pem", "crl format (pem, der)")
+	flags.String("crl", "ca.crl", "crl file name")
+	return &cmd
+}
+
+type crlArgs struct {
+	caCert    []byte
+	caKey     crypto.Signer
+	database  string
+	crlNumber string
+	days      int
+	format    string
+	crl       readWrite
+}
+
+func runCRL(args crlArgs) error {
+	caTpl, err := ca.ParseCertificate(args.caCert)
+	if err != nil {
+		return err
+	}
+	db, err := loadDatabase(args.database)
+	if err != nil {
+		return err
+	}
+	number, err := readNumberFile(args.crlNumber)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	tpl := &x509.RevocationList{
+		Number:              number,
+		ThisUpdate:          now,
+		NextUpdate:          now.Add(time.Hour * 24 * time.Duration(args.days)),
+		RevokedCertificates: db.revokedCertificates(),
+	}
+	derCRL, err := x509.CreateRevocationList(rand.Reader, tpl, caTpl, args.caKey)
+	if err != nil {
+		return err
+	}
+	switch args.format {
+	case "pem":
+		err = pem.Encode(args.crl, &pem.Block{Type: "X509 CRL", Bytes: derCRL})
+	case "der":
+		_, err = args.crl.Write(derCRL)
+	default:
+		return fmt.Errorf("unsupported crl format %s", args.format)
+	}
+	if err != nil {
+		return err
+	}
+	return writeNumberFile(args.crlNumber, new(big.Int).Add(number, big.NewInt(1)))
+}
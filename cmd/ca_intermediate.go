@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509/pkix"
+	"time"
+
+	"github.com/n-creativesystem/self-signed-certificate/pkg/ca"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func intermediateCACommand() *cobra.Command {
+	initialize := initialize("ca_config")
+	cmd := cobra.Command{
+		Use:   "intermediate",
+		Short: "中間CA証明書作成(key, cert, chain)",
+		Long:  `親CAで署名した中間CA証明書をcertファイル、keyファイル、chainファイルのセットで作成します`,
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := cmd.Flags().GetString("config")
+			if err != nil {
+				errorExit(err)
+			}
+			initialize(cmd, config)
+			var caArg intermediateArgs
+			caArg.serialNumber = viper.GetInt("serialNumber")
+			caArg.serialType = viper.GetString("serialType")
+			caArg.bits = viper.GetInt("bits")
+			caArg.keyType = viper.GetString("keyType")
+			caArg.days = viper.GetInt("days")
+			caArg.maxPathLen = viper.GetInt("maxPathLen")
+			caArg.country = viper.GetStringSlice("country")
+			caArg.commonName = viper.GetString("commonName")
+			caArg.organization = viper.GetStringSlice("organization")
+			caArg.organizationUnit = viper.GetStringSlice("organizationUnit")
+			caArg.keyEncryption = parseKeyEncryption()
+			caArg.nameConstraints, err = parseNameConstraints()
+			if err != nil {
+				errorExit(err)
+			}
+			caArg.profile, err = parseProfile()
+			if err != nil {
+				errorExit(err)
+			}
+			caArg.profile.setDefaults(&caArg.days, &caArg.keyType, &caArg.bits)
+			caArg.extraExtensions, err = parseExtensions()
+			if err != nil {
+				errorExit(err)
+			}
+			if caArg.profile != nil && caArg.profile.basicConstraints && !viper.IsSet("maxPathLen") {
+				caArg.maxPathLen = caArg.profile.maxPathLen
+			}
+			parentCertFilename := viper.GetString("parentCert")
+			caArg.parentCert, caArg.parentKey, err = readCERTandKEY(parentCertFilename, viper.GetString("parentKey"), viper.GetString("parentKeyURI"))
+			if err != nil {
+				errorExit(err)
+			}
+			caArg.db, err = loadDatabase(databasePath(parentCertFilename, viper.GetString("database")))
+			if err != nil {
+				errorExit(err)
+			}
+			certFilename := viper.GetString("cert")
+			caArg.certFilename = certFilename
+			keyFilename := viper.GetString("key")
+			chainFilename := viper.GetString("chain")
+			caArg.certFile = &bytes.Buffer{}
+			caArg.keyFile = &bytes.Buffer{}
+			caArg.chainFile = &bytes.Buffer{}
+			if err := runIntermediateCA(caArg); err != nil {
+				errorExit(err)
+			}
+			fileCreate(certFilename, caArg.certFile)
+			fileCreate(keyFilename, caArg.keyFile)
+			fileCreate(chainFilename, caArg.chainFile)
+			if err := caArg.db.save(); err != nil {
+				errorExit(err)
+			}
+		},
+	}
+	flags := cmd.Flags()
+	flags.String("config", "", "CA configuration")
+	flags.Int("serialNumber", 0, "serial number (0: allocate from the parent certificate database)")
+	flags.String("serialType", serialTypeSequential, "serial number allocation (sequential, random)")
+	flags.String("database", "", "parent certificate database file name (default: <parent ca cert name>.index.txt)")
+	flags.Int("bits", 2048, "key length")
+	flags.String("keyType", ca.KeyTypeRSA, "key type (rsa, ecdsa-p256, ecdsa-p384, ecdsa-p521, ed25519)")
+	flags.StringSlice("country", []string{"JP"}, "country")
+	flags.StringSlice("organization", nil, "organization")
+	flags.StringSlice("organizationUnit", nil, "organization unit")
+	flags.String("commonName", "", "common name")
+	flags.Int("days", 365, "days")
+	flags.Int("maxPathLen", 0, "maximum number of intermediate CAs below this CA")
+	addNameConstraintFlags(flags)
+	addKeyEncryptionFlags(flags)
+	flags.String("parentCert", "ca.crt", "parent ca cert file name")
+	flags.String("parentKey", "ca.key", "parent ca private key file name")
+	flags.String("parentKeyURI", "", "parent ca private key pkcs11 uri (instead of --parentKey)")
+	flags.String("cert", "intermediate.crt", "intermediate ca cert file name")
+	flags.String("key", "intermediate.key", "intermediate ca private key file name")
+	flags.String("chain", "intermediate-chain.crt", "intermediate ca cert chain file name")
+	addProfileFlag(flags)
+	addExtensionFlag(flags)
+	return &cmd
+}
+
+type intermediateArgs struct {
+	caArgs
+	maxPathLen   int
+	serialType   string
+	parentCert   []byte
+	parentKey    crypto.Signer
+	chainFile    readWrite
+	certFilename string
+	db           *database
+}
+
+func runIntermediateCA(args intermediateArgs) error {
+	parent, err := ca.Load(args.parentCert, args.parentKey)
+	if err != nil {
+		return err
+	}
+	args.db.reserve(parent.Certificate)
+	serialNumber, err := args.db.allocateSerial(args.serialNumber, args.serialType)
+	if err != nil {
+		return err
+	}
+	req := &ca.IssueRequest{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			CommonName:         args.commonName,
+			Organization:       args.organization,
+			OrganizationalUnit: args.organizationUnit,
+			Country:            args.country,
+		},
+		KeyType:         args.keyType,
+		Bits:            args.bits,
+		Validity:        time.Hour * 24 * time.Duration(args.days),
+		MaxPathLen:      args.maxPathLen,
+		NameConstraints: args.nameConstraints,
+	}
+	if err := args.profile.apply(req); err != nil {
+		return err
+	}
+	req.MaxPathLen = args.maxPathLen
+	req.ExtraExtensions = mergeExtensions(req.ExtraExtensions, args.extraExtensions)
+	intermediate, err := parent.NewIntermediate(req)
+	if err != nil {
+		return err
+	}
+	if err := args.db.add(intermediate.Certificate, args.certFilename); err != nil {
+		return err
+	}
+	if _, err := args.certFile.Write(intermediate.CertificatePEM()); err != nil {
+		return err
+	}
+	keyPEM, err := args.keyEncryption.marshal(intermediate.Signer)
+	if err != nil {
+		return err
+	}
+	if _, err := args.keyFile.Write(keyPEM); err != nil {
+		return err
+	}
+	_, err = args.chainFile.Write(intermediate.ChainPEM())
+	return err
+}
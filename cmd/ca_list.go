@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func listCACommand() *cobra.Command {
+	initialize := initialize("ca_config")
+	cmd := cobra.Command{
+		Use:   "list",
+		Short: "発行済み証明書の一覧",
+		Long:  `CAのデータベースに記録された発行済み証明書を一覧表示します`,
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := cmd.Flags().GetString("config")
+			if err != nil {
+				errorExit(err)
+			}
+			initialize(cmd, config)
+			db, err := loadDatabase(databasePath(viper.GetString("cert"), viper.GetString("database")))
+			if err != nil {
+				errorExit(err)
+			}
+			if err := runList(os.Stdout, db, viper.GetString("status")); err != nil {
+				errorExit(err)
+			}
+		},
+	}
+	flags := cmd.Flags()
+	flags.String("config", "", "CA configuration")
+	flags.String("cert", "ca.crt", "ca cert file name")
+	flags.String("database", "", "certificate database file name (default: <ca cert name>.index.txt)")
+	flags.String("status", "", "filter by status (valid, revoked, expired)")
+	return &cmd
+}
+
+var statusNames = map[string]string{
+	statusValid:   "valid",
+	statusRevoked: "revoked",
+	statusExpired: "expired",
+}
+
+func runList(w io.Writer, db *database, status string) error {
+	switch status {
+	case "", "valid", "revoked", "expired":
+	default:
+		return fmt.Errorf("invalid status %s", status)
+	}
+	now := time.Now()
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERIAL\tSTATUS\tNOT AFTER\tREVOKED\tFILE\tSUBJECT")
+	for _, entry := range db.entries {
+		current := statusNames[entry.currentStatus(now)]
+		if status != "" && status != current {
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			formatSerial(entry.serial),
+			current,
+			formatTime(entry.expiry),
+			formatTime(entry.revoked),
+			entry.filename,
+			entry.subject,
+		)
+	}
+	return tw.Flush()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(time.RFC3339)
+}
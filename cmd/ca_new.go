@@ -2,14 +2,11 @@ package cmd
 
 import (
 	"bytes"
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/x509"
 	"crypto/x509/pkix"
-	"encoding/pem"
 	"math/big"
 	"time"
 
+	"github.com/n-creativesystem/self-signed-certificate/pkg/ca"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -29,11 +26,27 @@ func newCACommand() *cobra.Command {
 			var caArg caArgs
 			caArg.serialNumber = viper.GetInt("serialNumber")
 			caArg.bits = viper.GetInt("bits")
+			caArg.keyType = viper.GetString("keyType")
 			caArg.days = viper.GetInt("days")
 			caArg.country = viper.GetStringSlice("country")
 			caArg.commonName = viper.GetString("commonName")
 			caArg.organization = viper.GetStringSlice("organization")
 			caArg.organizationUnit = viper.GetStringSlice("organizationUnit")
+			caArg.keyEncryption = parseKeyEncryption()
+			caArg.keyURI = viper.GetString("keyURI")
+			caArg.nameConstraints, err = parseNameConstraints()
+			if err != nil {
+				errorExit(err)
+			}
+			caArg.profile, err = parseProfile()
+			if err != nil {
+				errorExit(err)
+			}
+			caArg.profile.setDefaults(&caArg.days, &caArg.keyType, &caArg.bits)
+			caArg.extraExtensions, err = parseExtensions()
+			if err != nil {
+				errorExit(err)
+			}
 			certFilename := viper.GetString("cert")
 			keyFilename := viper.GetString("key")
 			caArg.certFile = &bytes.Buffer{}
@@ -42,60 +55,76 @@ func newCACommand() *cobra.Command {
 				errorExit(err)
 			}
 			fileCreate(certFilename, caArg.certFile)
-			fileCreate(keyFilename, caArg.keyFile)
+			if caArg.keyURI == "" {
+				fileCreate(keyFilename, caArg.keyFile)
+			}
 		},
 	}
 	flags := cmd.Flags()
 	flags.String("config", "", "CA configuration")
 	flags.Int("serialNumber", 1, "serial number")
 	flags.Int("bits", 2048, "key length")
+	flags.String("keyType", ca.KeyTypeRSA, "key type (rsa, ecdsa-p256, ecdsa-p384, ecdsa-p521, ed25519)")
 	flags.StringSlice("country", []string{"JP"}, "country")
 	flags.StringSlice("organization", nil, "organization")
 	flags.StringSlice("organizationUnit", nil, "organization unit")
 	flags.String("commonName", "", "common name")
 	flags.String("cert", "ca.crt", "ca cert file name")
 	flags.String("key", "ca.key", "ca private key file name")
+	flags.String("keyURI", "", "generate the ca private key in the pkcs11 token (instead of --key)")
 	flags.Int("days", 365, "days")
+	addNameConstraintFlags(flags)
+	addKeyEncryptionFlags(flags)
+	addProfileFlag(flags)
+	addExtensionFlag(flags)
 	return &cmd
 }
 
 func certificateRun(args caArgs) error {
-	privateCaKey, err := rsa.GenerateKey(rand.Reader, args.bits)
-	if err != nil {
-		return err
+	req := &ca.IssueRequest{
+		SerialNumber: big.NewInt(int64(args.serialNumber)),
+		Subject: pkix.Name{
+			CommonName:         args.commonName,
+			Organization:       args.organization,
+			OrganizationalUnit: args.organizationUnit,
+			Country:            args.country,
+		},
+		KeyType:         args.keyType,
+		Bits:            args.bits,
+		Validity:        time.Hour * 24 * time.Duration(args.days),
+		NameConstraints: args.nameConstraints,
 	}
-	publicCaKey := privateCaKey.Public()
-
-	subjectCa := pkix.Name{
-		CommonName:         args.commonName,
-		Organization:       args.organization,
-		OrganizationalUnit: args.organizationUnit,
-		Country:            args.country,
+	if err := args.profile.apply(req); err != nil {
+		return err
 	}
-	caTpl := &x509.Certificate{
-		SerialNumber:          big.NewInt(int64(args.serialNumber)),
-		Subject:               subjectCa,
-		IsCA:                  true,
-		NotAfter:              time.Now().Add(time.Hour * 24 * time.Duration(args.days)),
-		NotBefore:             time.Now(),
-		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
-		BasicConstraintsValid: true,
+	req.ExtraExtensions = mergeExtensions(req.ExtraExtensions, args.extraExtensions)
+	if args.keyURI != "" {
+		uri, err := parsePKCS11URI(args.keyURI)
+		if err != nil {
+			return err
+		}
+		key, err := generatePKCS11Key(uri, args.keyType, args.bits)
+		if err != nil {
+			return err
+		}
+		authority, err := ca.NewWithSigner(req, key)
+		if err != nil {
+			return err
+		}
+		_, err = args.certFile.Write(authority.CertificatePEM())
+		return err
 	}
-	caCertificate, err := x509.CreateCertificate(rand.Reader, caTpl, caTpl, publicCaKey, privateCaKey)
+	authority, err := ca.New(req)
 	if err != nil {
 		return err
 	}
-	err = pem.Encode(args.certFile, &pem.Block{
-		Type:  "CERTIFICATE",
-		Bytes: caCertificate,
-	})
-	if err != nil {
+	if _, err := args.certFile.Write(authority.CertificatePEM()); err != nil {
 		return err
 	}
-	derCaPrivateKey := x509.MarshalPKCS1PrivateKey(privateCaKey)
-	err = pem.Encode(args.keyFile, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: derCaPrivateKey})
+	keyPEM, err := args.keyEncryption.marshal(authority.Signer)
 	if err != nil {
 		return err
 	}
-	return nil
+	_, err = args.keyFile.Write(keyPEM)
+	return err
 }
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/n-creativesystem/self-signed-certificate/pkg/ca"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func revokeCACommand() *cobra.Command {
+	initialize := initialize("ca_config")
+	cmd := cobra.Command{
+		Use:   "revoke [cert file]",
+		Short: "証明書の失効",
+		Long:  `証明書ファイルまたはserial numberを指定して、失効理由と失効日時をCAのデータベースに記録します`,
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := cmd.Flags().GetString("config")
+			if err != nil {
+				errorExit(err)
+			}
+			initialize(cmd, config)
+			var revokeArg revokeArgs
+			certFilename := viper.GetString("cert")
+			revokeArg.caCert, err = os.ReadFile(certFilename)
+			if err != nil {
+				errorExit(err)
+			}
+			revokeArg.database = databasePath(certFilename, viper.GetString("database"))
+			if len(args) > 0 {
+				revokeArg.cert, err = os.ReadFile(args[0])
+				if err != nil {
+					errorExit(err)
+				}
+			}
+			if serial := viper.GetString("serial"); serial != "" {
+				revokeArg.serial, err = parseSerial(serial)
+				if err != nil {
+					errorExit(err)
+				}
+			}
+			revokeArg.reason, err = parseRevocationReason(viper.GetString("reason"))
+			if err != nil {
+				errorExit(err)
+			}
+			revokeArg.revoked = time.Now()
+			if t := viper.GetString("time"); t != "" {
+				revokeArg.revoked, err = time.Parse(time.RFC3339, t)
+				if err != nil {
+					errorExit(err)
+				}
+			}
+			if err := runRevoke(revokeArg); err != nil {
+				errorExit(err)
+			}
+		},
+	}
+	flags := cmd.Flags()
+	flags.String("config", "", "CA configuration")
+	flags.String("cert", "ca.crt", "ca cert file name")
+	flags.String("database", "", "certificate database file name (default: <ca cert name>.index.txt)")
+	flags.String("serial", "", "serial number to revoke (decimal, 0x prefixed or colon separated hex)")
+	flags.String("reason", "unspecified", "revocation reason (unspecified, keyCompromise, CACompromise, affiliationChanged, superseded, cessationOfOperation, certificateHold, removeFromCRL, privilegeWithdrawn, AACompromise)")
+	flags.String("time", "", "revocation time in RFC3339 (default: now)")
+	return &cmd
+}
+
+type revokeArgs struct {
+	caCert   []byte
+	cert     []byte
+	serial   *big.Int
+	reason   int
+	revoked  time.Time
+	database string
+}
+
+func runRevoke(args revokeArgs) error {
+	caTpl, err := ca.ParseCertificate(args.caCert)
+	if err != nil {
+		return err
+	}
+	db, err := loadDatabase(args.database)
+	if err != nil {
+		return err
+	}
+	var entry *indexEntry
+	switch {
+	case args.cert != nil:
+		cert, err := ca.ParseCertificate(args.cert)
+		if err != nil {
+			return err
+		}
+		if err := cert.CheckSignatureFrom(caTpl); err != nil {
+			return fmt.Errorf("certificate is not issued by the CA: %w", err)
+		}
+		if args.serial != nil && args.serial.Cmp(cert.SerialNumber) != 0 {
+			return errors.New("serial number does not match the certificate")
+		}
+		entry = newIndexEntry(cert)
+	case args.serial != nil:
+		entry = &indexEntry{serial: args.serial, filename: "unknown"}
+	default:
+		return errors.New("cert file or serial number is required")
+	}
+	if err := db.revoke(entry, args.revoked, args.reason); err != nil {
+		return err
+	}
+	return db.save()
+}
@@ -0,0 +1,218 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/pem"
+	"errors"
+	"time"
+
+	"github.com/n-creativesystem/self-signed-certificate/pkg/ca"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func rolloverCACommand() *cobra.Command {
+	initialize := initialize("ca_config")
+	cmd := cobra.Command{
+		Use:   "rollover",
+		Short: "自己署名CAの鍵の更新(new cert, new key, cross cert, bundle)",
+		Long: `新しい秘密鍵で自己署名CA証明書を作成し、新旧のCAで相互にクロス署名した証明書と、新旧のCA証明書を連結したbundleファイルを作成します。
+移行期間中は既存の証明書も新しいCA証明書で発行した証明書も検証できます。`,
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := cmd.Flags().GetString("config")
+			if err != nil {
+				errorExit(err)
+			}
+			initialize(cmd, config)
+			var caArg rolloverArgs
+			caArg.bits = viper.GetInt("bits")
+			caArg.keyType = viper.GetString("keyType")
+			caArg.days = viper.GetInt("days")
+			caArg.commonName = viper.GetString("commonName")
+			caArg.serialType = viper.GetString("serialType")
+			caArg.keyEncryption = parseKeyEncryption()
+			certFilename := viper.GetString("cert")
+			caArg.oldCert, caArg.oldKey, err = readCERTandKEY(certFilename, viper.GetString("key"), viper.GetString("keyURI"))
+			if err != nil {
+				errorExit(err)
+			}
+			caArg.oldDB, err = loadDatabase(databasePath(certFilename, ""))
+			if err != nil {
+				errorExit(err)
+			}
+			newCertFilename := viper.GetString("newCert")
+			caArg.newDB, err = loadDatabase(databasePath(newCertFilename, ""))
+			if err != nil {
+				errorExit(err)
+			}
+			crossNewFilename := viper.GetString("crossNew")
+			crossOldFilename := viper.GetString("crossOld")
+			caArg.crossNewFilename = crossNewFilename
+			caArg.crossOldFilename = crossOldFilename
+			caArg.certFile = &bytes.Buffer{}
+			caArg.keyFile = &bytes.Buffer{}
+			caArg.crossNewFile = &bytes.Buffer{}
+			caArg.crossOldFile = &bytes.Buffer{}
+			caArg.bundleFile = &bytes.Buffer{}
+			if err := runCARollover(caArg); err != nil {
+				errorExit(err)
+			}
+			fileCreate(newCertFilename, caArg.certFile)
+			fileCreate(viper.GetString("newKey"), caArg.keyFile)
+			fileCreate(crossNewFilename, caArg.crossNewFile)
+			fileCreate(crossOldFilename, caArg.crossOldFile)
+			fileCreate(viper.GetString("bundle"), caArg.bundleFile)
+			if err := caArg.oldDB.save(); err != nil {
+				errorExit(err)
+			}
+			if err := caArg.newDB.save(); err != nil {
+				errorExit(err)
+			}
+		},
+	}
+	flags := cmd.Flags()
+	flags.String("config", "", "CA configuration")
+	flags.String("cert", "ca.crt", "current ca cert file name")
+	flags.String("key", "ca.key", "current ca private key file name")
+	flags.String("keyURI", "", "ca private key pkcs11 uri (instead of --key)")
+	flags.Int("bits", 2048, "key length")
+	flags.String("keyType", "", "key type of the new ca (rsa, ecdsa-p256, ecdsa-p384, ecdsa-p521, ed25519. default: same as the current ca)")
+	flags.String("commonName", "", "common name of the new ca (default: same as the current ca)")
+	flags.Int("days", 0, "days (0: same validity period as the current ca)")
+	flags.String("serialType", serialTypeSequential, "serial number allocation of the cross certificates (sequential, random)")
+	flags.String("newCert", "ca-new.crt", "new ca cert file name")
+	flags.String("newKey", "ca-new.key", "new ca private key file name")
+	flags.String("crossNew", "ca-new-by-old.crt", "new ca cert signed by the current ca file name")
+	flags.String("crossOld", "ca-old-by-new.crt", "current ca cert signed by the new ca file name")
+	flags.String("bundle", "ca-bundle.crt", "trust bundle (current and new ca cert) file name")
+	addKeyEncryptionFlags(flags)
+	return &cmd
+}
+
+type rolloverArgs struct {
+	caArgs
+	serialType       string
+	oldCert          []byte
+	oldKey           crypto.Signer
+	oldDB            *database
+	newDB            *database
+	crossNewFilename string
+	crossOldFilename string
+	crossNewFile     readWrite
+	crossOldFile     readWrite
+	bundleFile       readWrite
+}
+
+func runCARollover(args rolloverArgs) error {
+	old, err := ca.Load(args.oldCert, args.oldKey)
+	if err != nil {
+		return err
+	}
+	oldCert := old.Certificate
+	if !oldCert.IsCA {
+		return errors.New("certificate is not a CA")
+	}
+	if !bytes.Equal(oldCert.RawSubject, oldCert.RawIssuer) || oldCert.CheckSignatureFrom(oldCert) != nil {
+		return errors.New("certificate is not a self-signed CA (use ca intermediate to reissue an intermediate CA)")
+	}
+
+	req := &ca.IssueRequest{
+		Subject:  oldCert.Subject,
+		KeyType:  args.keyType,
+		Bits:     args.bits,
+		Validity: oldCert.NotAfter.Sub(oldCert.NotBefore),
+		NameConstraints: ca.NameConstraints{
+			Critical:                oldCert.PermittedDNSDomainsCritical,
+			PermittedDNSDomains:     oldCert.PermittedDNSDomains,
+			ExcludedDNSDomains:      oldCert.ExcludedDNSDomains,
+			PermittedIPRanges:       oldCert.PermittedIPRanges,
+			ExcludedIPRanges:        oldCert.ExcludedIPRanges,
+			PermittedEmailAddresses: oldCert.PermittedEmailAddresses,
+			ExcludedEmailAddresses:  oldCert.ExcludedEmailAddresses,
+			PermittedURIDomains:     oldCert.PermittedURIDomains,
+			ExcludedURIDomains:      oldCert.ExcludedURIDomains,
+		},
+	}
+	for _, name := range oldCert.Subject.Names {
+		if !containsOID(standardNameAttributes, name.Type) {
+			req.Subject.ExtraNames = append(req.Subject.ExtraNames, name)
+		}
+	}
+	if args.commonName != "" {
+		req.Subject.CommonName = args.commonName
+	}
+	if args.keyType == "" {
+		req.KeyType, req.Bits, err = ca.KeyTypeOf(oldCert.PublicKey)
+		if err != nil {
+			return err
+		}
+	}
+	if args.days > 0 {
+		req.Validity = time.Hour * 24 * time.Duration(args.days)
+	}
+	newCA, err := ca.New(req)
+	if err != nil {
+		return err
+	}
+	newCert := newCA.Certificate
+
+	args.oldDB.reserve(oldCert)
+	serialNumber, err := args.oldDB.allocateSerial(0, args.serialType)
+	if err != nil {
+		return err
+	}
+	crossNew, err := old.CrossSign(newCert, serialNumber)
+	if err != nil {
+		return err
+	}
+	if err := args.oldDB.add(crossNew, args.crossNewFilename); err != nil {
+		return err
+	}
+
+	// subjectが同じ場合は発行者名が同じになるため、新しいCAのserial numberは旧CAの続きから割り当てます。
+	if bytes.Equal(newCert.RawSubject, oldCert.RawSubject) && args.newDB.nextSerial == nil && len(args.newDB.entries) == 0 {
+		args.newDB.nextSerial = args.oldDB.nextSerial
+		if args.newDB.nextSerial == nil {
+			args.newDB.nextSerial, err = readNumberFile(args.oldDB.serialFile)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	args.newDB.reserve(newCert)
+	serialNumber, err = args.newDB.allocateSerial(0, args.serialType)
+	if err != nil {
+		return err
+	}
+	crossOld, err := newCA.CrossSign(oldCert, serialNumber)
+	if err != nil {
+		return err
+	}
+	if err := args.newDB.add(crossOld, args.crossOldFilename); err != nil {
+		return err
+	}
+
+	if _, err := args.certFile.Write(newCA.CertificatePEM()); err != nil {
+		return err
+	}
+	keyPEM, err := args.keyEncryption.marshal(newCA.Signer)
+	if err != nil {
+		return err
+	}
+	if _, err := args.keyFile.Write(keyPEM); err != nil {
+		return err
+	}
+	if err := pem.Encode(args.crossNewFile, &pem.Block{Type: "CERTIFICATE", Bytes: crossNew.Raw}); err != nil {
+		return err
+	}
+	if err := pem.Encode(args.crossOldFile, &pem.Block{Type: "CERTIFICATE", Bytes: crossOld.Raw}); err != nil {
+		return err
+	}
+	for _, cert := range [][]byte{oldCert.Raw, newCert.Raw} {
+		if err := pem.Encode(args.bundleFile, &pem.Block{Type: "CERTIFICATE", Bytes: cert}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func showCACommand() *cobra.Command {
+	initialize := initialize("ca_config")
+	cmd := cobra.Command{
+		Use:   "show <serial>",
+		Short: "発行済み証明書の参照",
+		Long:  `serial numberを指定してCAのデータベースに記録された証明書の情報を表示します`,
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := cmd.Flags().GetString("config")
+			if err != nil {
+				errorExit(err)
+			}
+			initialize(cmd, config)
+			db, err := loadDatabase(databasePath(viper.GetString("cert"), viper.GetString("database")))
+			if err != nil {
+				errorExit(err)
+			}
+			if err := runShow(os.Stdout, db, args[0]); err != nil {
+				errorExit(err)
+			}
+		},
+	}
+	flags := cmd.Flags()
+	flags.String("config", "", "CA configuration")
+	flags.String("cert", "ca.crt", "ca cert file name")
+	flags.String("database", "", "certificate database file name (default: <ca cert name>.index.txt)")
+	return &cmd
+}
+
+func runShow(w io.Writer, db *database, serial string) error {
+	n, err := parseSerial(serial)
+	if err != nil {
+		return err
+	}
+	entry := db.find(n)
+	if entry == nil {
+		return fmt.Errorf("serial number %s is not found", serial)
+	}
+	fmt.Fprintf(w, "Serial:    %s (%s)\n", formatSerial(entry.serial), entry.serial.String())
+	fmt.Fprintf(w, "Status:    %s\n", statusNames[entry.currentStatus(time.Now())])
+	fmt.Fprintf(w, "Subject:   %s\n", entry.subject)
+	fmt.Fprintf(w, "Not After: %s\n", formatTime(entry.expiry))
+	if entry.status == statusRevoked {
+		fmt.Fprintf(w, "Revoked:   %s\n", formatTime(entry.revoked))
+		fmt.Fprintf(w, "Reason:    %s\n", revocationReasons[entry.reason])
+	}
+	fmt.Fprintf(w, "File:      %s\n", entry.filename)
+	return nil
+}
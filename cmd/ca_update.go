@@ -2,12 +2,14 @@ package cmd
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/rand"
-	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"time"
 
+	"github.com/n-creativesystem/self-signed-certificate/pkg/ca"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -15,9 +17,9 @@ import (
 func updateCACommand() *cobra.Command {
 	initialize := initialize("ca_config")
 	cmd := cobra.Command{
-		Use:   "update",
-		Short: "自己署名CA証明書serial numberの更新",
-		Long:  `certファイルのserial numberをインクリメントしてファイルの更新を行います。`,
+		Use:   "update [serial] [after]",
+		Short: "自己署名CA証明書serial number、有効期限の更新",
+		Long:  `serial を指定するとcertファイルのserial numberを証明書データベースの次の番号に変更し、after を指定すると有効期限を --days 日延長してファイルの更新を行います。`,
 		Run: func(cmd *cobra.Command, args []string) {
 			var err error
 			config, err := cmd.Flags().GetString("config")
@@ -29,73 +31,75 @@ func updateCACommand() *cobra.Command {
 			certFilename := viper.GetString("cert")
 			keyFilename := viper.GetString("key")
 			caArg.days = viper.GetInt("days")
-			caArg.cert, caArg.key, err = readCERTandKEY(certFilename, keyFilename)
+			caArg.cert, caArg.key, err = readCERTandKEY(certFilename, keyFilename, viper.GetString("keyURI"))
+			if err != nil {
+				errorExit(err)
+			}
+			caArg.db, err = loadDatabase(databasePath(certFilename, viper.GetString("database")))
 			if err != nil {
 				errorExit(err)
 			}
 			caArg.certFile = &bytes.Buffer{}
-			caArg.keyFile = &bytes.Buffer{}
 			if err := runCAUpdate(caArg, args); err != nil {
 				errorExit(err)
 			}
 			fileCreate(certFilename, caArg.certFile)
-			fileCreate(keyFilename, caArg.keyFile)
+			if err := caArg.db.save(); err != nil {
+				errorExit(err)
+			}
 		},
 	}
 	flags := cmd.Flags()
 	flags.String("config", "", "CA configuration")
 	flags.String("cert", "ca.crt", "ca cert file name")
 	flags.String("key", "ca.key", "ca private key file name")
+	flags.String("keyURI", "", "ca private key pkcs11 uri (instead of --key)")
+	flags.Int("days", 365, "days to extend the validity (after)")
+	flags.String("database", "", "certificate database file name (default: <ca cert name>.index.txt)")
 	return &cmd
 }
 
 type caUpdateArgs struct {
 	cert []byte
-	key  *rsa.PrivateKey
+	key  crypto.Signer
 
 	days int
+	db   *database
 
 	certFile readWrite
-	keyFile  readWrite
 }
 
 func runCAUpdate(caArgs caUpdateArgs, args []string) error {
-	p, _ := pem.Decode(caArgs.cert)
-	caCert := append([]byte{}, p.Bytes...)
-	caTpl, err := x509.ParseCertificate(caCert)
+	caTpl, err := ca.ParseCertificate(caArgs.cert)
 	if err != nil {
 		return err
 	}
+	if !caTpl.IsCA {
+		return errors.New("certificate is not a CA")
+	}
+	if !bytes.Equal(caTpl.RawSubject, caTpl.RawIssuer) || caTpl.CheckSignatureFrom(caTpl) != nil {
+		return errors.New("certificate is not a self-signed CA (use ca intermediate to reissue an intermediate CA)")
+	}
 	public := caArgs.key.Public()
 	for _, arg := range args {
 		switch arg {
 		case "serial":
-			updateSerialNumber(caTpl)
+			// 発行済みの証明書と同じserial numberにならないよう、データベースから割り当てます
+			caArgs.db.reserve(caTpl)
+			caTpl.SerialNumber, err = caArgs.db.allocateSerial(0, serialTypeSequential)
+			if err != nil {
+				return err
+			}
 		case "after":
-			caTpl.NotAfter.Add(time.Hour * 24 * time.Duration(caArgs.days))
+			caTpl.NotAfter = caTpl.NotAfter.Add(time.Hour * 24 * time.Duration(caArgs.days))
 		}
 	}
 	caCertificate, err := x509.CreateCertificate(rand.Reader, caTpl, caTpl, public, caArgs.key)
 	if err != nil {
 		return err
 	}
-	err = pem.Encode(caArgs.certFile, &pem.Block{
+	return pem.Encode(caArgs.certFile, &pem.Block{
 		Type:  "CERTIFICATE",
 		Bytes: caCertificate,
 	})
-	if err != nil {
-		return err
-	}
-	derCaPrivateKey := x509.MarshalPKCS1PrivateKey(caArgs.key)
-	err = pem.Encode(caArgs.keyFile, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: derCaPrivateKey})
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func updateSerialNumber(caTpl *x509.Certificate) {
-	serialNumber := caTpl.SerialNumber.Int64()
-	serialNumber++
-	caTpl.SerialNumber.SetInt64(serialNumber)
 }
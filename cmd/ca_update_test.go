@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/n-creativesystem/self-signed-certificate/pkg/ca"
+)
+
+func TestCAUpdate(t *testing.T) {
+	root, err := ca.New(&ca.IssueRequest{Subject: pkix.Name{CommonName: "Update Test CA"}, KeyType: ca.KeyTypeECDSAP256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := &bytes.Buffer{}
+	if err := runCAUpdate(caUpdateArgs{cert: root.CertificatePEM(), key: root.Signer, days: 10, db: &database{}, certFile: certFile}, []string{"after"}); err != nil {
+		t.Fatal(err)
+	}
+	updated, err := ca.ParseCertificate(certFile.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !updated.NotAfter.Equal(root.Certificate.NotAfter.Add(10*24*time.Hour)) || updated.SerialNumber.Cmp(root.Certificate.SerialNumber) != 0 {
+		t.Errorf("not after %v, serial %v", updated.NotAfter, updated.SerialNumber)
+	}
+	if err := updated.CheckSignatureFrom(root.Certificate); err != nil {
+		t.Error(err)
+	}
+
+	// 中間CAを自己署名証明書にしません
+	inter, err := root.NewIntermediate(&ca.IssueRequest{Subject: pkix.Name{CommonName: "inter"}, KeyType: ca.KeyTypeECDSAP256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = runCAUpdate(caUpdateArgs{cert: inter.CertificatePEM(), key: inter.Signer, days: 10, certFile: &bytes.Buffer{}}, []string{"after"})
+	if err == nil || !strings.Contains(err.Error(), "not a self-signed CA") {
+		t.Errorf("intermediate: %v", err)
+	}
+	leaf, err := inter.Issue(&ca.IssueRequest{Subject: pkix.Name{CommonName: "leaf"}, KeyType: ca.KeyTypeECDSAP256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = runCAUpdate(caUpdateArgs{cert: leaf.CertificatePEM(), key: leaf.PrivateKey, days: 10, certFile: &bytes.Buffer{}}, []string{"after"})
+	if err == nil || !strings.Contains(err.Error(), "not a CA") {
+		t.Errorf("leaf: %v", err)
+	}
+}
+
+// TestCAUpdateSerial はCA証明書のserial numberが発行済みの証明書と重複しないことを確認します。
+func TestCAUpdateSerial(t *testing.T) {
+	root, err := ca.New(&ca.IssueRequest{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "Update Test CA"}, KeyType: ca.KeyTypeECDSAP256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbFile := filepath.Join(t.TempDir(), "ca.index.txt")
+	db, err := loadDatabase(dbFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.reserve(root.Certificate)
+	serial, err := db.allocateSerial(0, serialTypeSequential)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := root.Issue(&ca.IssueRequest{SerialNumber: serial, Subject: pkix.Name{CommonName: "leaf"}, KeyType: ca.KeyTypeECDSAP256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.add(leaf.Certificate, "leaf.crt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	cert := root.CertificatePEM()
+	var serials []string
+	for i := 0; i < 2; i++ {
+		db, err := loadDatabase(dbFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certFile := &bytes.Buffer{}
+		if err := runCAUpdate(caUpdateArgs{cert: cert, key: root.Signer, db: db, certFile: certFile}, []string{"serial"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.save(); err != nil {
+			t.Fatal(err)
+		}
+		cert = certFile.Bytes()
+		updated, err := ca.ParseCertificate(cert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		serials = append(serials, formatSerial(updated.SerialNumber))
+		if entry := db.find(updated.SerialNumber); entry != nil {
+			t.Errorf("serial %s is already issued", formatSerial(updated.SerialNumber))
+		}
+		roots := x509.NewCertPool()
+		roots.AddCert(updated)
+		if _, err := leaf.Certificate.Verify(x509.VerifyOptions{Roots: roots}); err != nil {
+			t.Error(err)
+		}
+	}
+	// ca new → server new で leaf が 02 を使用するため、CA証明書は 03、04 になります
+	if got := formatSerial(leaf.Certificate.SerialNumber); got != "02" {
+		t.Errorf("leaf serial: %s", got)
+	}
+	if strings.Join(serials, ",") != "03,04" {
+		t.Errorf("ca serials: %v", serials)
+	}
+}
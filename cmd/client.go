@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"crypto/x509"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func clientCertificateCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "client",
+		Short: "クライアント証明書作成",
+		Long:  "相互TLS(mTLS)用のクライアント証明書作成",
+	}
+	cmd.AddCommand(newClientCertificateCommand())
+	cmd.AddCommand(clientCSRCommand())
+	return &cmd
+}
+
+func clientExtKeyUsage() []x509.ExtKeyUsage {
+	if viper.GetBool("peer") {
+		return []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	}
+	return []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+}
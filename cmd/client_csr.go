@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"bytes"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func clientCSRCommand() *cobra.Command {
+	initialize := initialize("client_config")
+	cmd := cobra.Command{
+		Use:   "csr",
+		Short: "クライアント証明書作成(cert)",
+		Long:  "証明書要求(CSR)からクライアント証明書を作成します",
+		Run: func(cmd *cobra.Command, args []string) {
+			var err error
+			config, err := cmd.Flags().GetString("config")
+			if err != nil {
+				errorExit(err)
+			}
+			initialize(cmd, config)
+			var srvArg serverArgs = parseServerArgs()
+			srvArg.extKeyUsage = clientExtKeyUsage()
+			certFilename := viper.GetString("cert")
+			srvArg.certFilename = certFilename
+			chainFilename := viper.GetString("chain")
+			srvArg.cert = &bytes.Buffer{}
+			if chainFilename != "" {
+				srvArg.chain = &bytes.Buffer{}
+			}
+			if err := runServerCSR(srvArg); err != nil {
+				errorExit(err)
+			}
+			fileCreate(certFilename, srvArg.cert)
+			if chainFilename != "" {
+				fileCreate(chainFilename, srvArg.chain)
+			}
+			if err := srvArg.db.save(); err != nil {
+				errorExit(err)
+			}
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.String("config", "", "client configuration")
+	flags.Int("serialNumber", 0, "serial number (0: allocate from the certificate database)")
+	flags.String("serialType", serialTypeSequential, "serial number allocation (sequential, random)")
+	flags.String("database", "", "certificate database file name (default: <ca cert name>.index.txt)")
+	flags.Int("days", 365, "days")
+	flags.StringSlice("dnsNames", nil, "subject alternate name dns names")
+	flags.StringSlice("ipAddresses", nil, "subject alternate name ip addresses")
+	flags.StringSlice("emailAddresses", nil, "subject alternate name email addresses")
+	flags.StringSlice("urls", nil, "subject alternate name urls (e.g. spiffe://example.internal/service)")
+	flags.String("sanMode", sanModeMerge, "subject alternate names of the certificate (merge: csr and flags, csr: csr only, flags: flags only)")
+	flags.Bool("peer", false, "issue a server and client (peer) certificate")
+	flags.String("caCert", "ca.crt", "ca cert file name")
+	flags.String("caKey", "ca.key", "ca private key file name")
+	flags.String("caKeyURI", "", "ca private key pkcs11 uri (e.g. pkcs11:token=ca;object=ca-key?module-path=/usr/lib/softhsm/libsofthsm2.so)")
+	flags.String("csr", "client.csr", "client certificate request file name")
+	flags.String("cert", "client.crt", "client cert file name")
+	flags.String("chain", "", "client cert chain file name (client cert and ca certs)")
+	addProfileFlag(flags)
+	addExtensionFlag(flags)
+	return &cmd
+}
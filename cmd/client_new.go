@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"bytes"
+
+	"github.com/n-creativesystem/self-signed-certificate/pkg/ca"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func newClientCertificateCommand() *cobra.Command {
+	initialize := initialize("client_config")
+	cmd := cobra.Command{
+		Use:   "new",
+		Short: "クライアント証明書作成(cert,key)",
+		Long:  "certファイルとkeyファイルのセットでクライアント証明書を作成します",
+		Run: func(cmd *cobra.Command, args []string) {
+			var err error
+			config, err := cmd.Flags().GetString("config")
+			if err != nil {
+				errorExit(err)
+			}
+			initialize(cmd, config)
+			var srvArg serverArgs = parseServerArgs()
+			srvArg.extKeyUsage = clientExtKeyUsage()
+			format := viper.GetString("format")
+			if err := checkOutputFormat(format); err != nil {
+				errorExit(err)
+			}
+			certFilename := viper.GetString("cert")
+			p12Filename := viper.GetString("p12")
+			srvArg.certFilename = certFilename
+			if format == formatPKCS12 {
+				srvArg.certFilename = p12Filename
+			} else {
+				srvArg.keyEncryption = parseKeyEncryption()
+			}
+			chainFilename := viper.GetString("chain")
+			keyFilename := viper.GetString("key")
+			srvArg.cert = &bytes.Buffer{}
+			if chainFilename != "" {
+				srvArg.chain = &bytes.Buffer{}
+			}
+			srvArg.key = &bytes.Buffer{}
+
+			if err := runServerCertificate(srvArg); err != nil {
+				errorExit(err)
+			}
+			if chainFilename != "" {
+				fileCreate(chainFilename, srvArg.chain)
+			}
+			switch format {
+			case formatPKCS12:
+				p12 := &bytes.Buffer{}
+				if err := writePKCS12(p12, srvArg.cert, srvArg.key, srvArg.caCert, viper.GetString("password")); err != nil {
+					errorExit(err)
+				}
+				fileCreate(p12Filename, p12)
+			default:
+				fileCreate(certFilename, srvArg.cert)
+				fileCreate(keyFilename, srvArg.key)
+			}
+			if err := srvArg.db.save(); err != nil {
+				errorExit(err)
+			}
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.String("config", "", "client configuration")
+	flags.Int("serialNumber", 0, "serial number (0: allocate from the certificate database)")
+	flags.String("serialType", serialTypeSequential, "serial number allocation (sequential, random)")
+	flags.String("database", "", "certificate database file name (default: <ca cert name>.index.txt)")
+	flags.Int("bits", 2048, "rsa bits")
+	flags.String("keyType", ca.KeyTypeRSA, "key type (rsa, ecdsa-p256, ecdsa-p384, ecdsa-p521, ed25519)")
+	flags.StringSlice("country", []string{"JP"}, "country")
+	flags.StringSlice("organization", nil, "organization")
+	flags.StringSlice("organizationUnit", nil, "organization unit")
+	flags.String("commonName", "", "common name (client identity)")
+	flags.Int("days", 365, "days")
+	flags.StringSlice("dnsNames", nil, "subject alternate name dns names")
+	flags.StringSlice("ipAddresses", nil, "subject alternate name ip addresses")
+	flags.StringSlice("emailAddresses", nil, "subject alternate name email addresses")
+	flags.StringSlice("urls", nil, "subject alternate name urls (e.g. spiffe://example.internal/service)")
+	flags.Bool("peer", false, "issue a server and client (peer) certificate")
+	flags.String("caCert", "ca.crt", "ca cert file name")
+	flags.String("caKey", "ca.key", "ca private key file name")
+	flags.String("caKeyURI", "", "ca private key pkcs11 uri (e.g. pkcs11:token=ca;object=ca-key?module-path=/usr/lib/softhsm/libsofthsm2.so)")
+	flags.String("cert", "client.crt", "client cert file name")
+	flags.String("chain", "", "client cert chain file name (client cert and ca certs)")
+	flags.String("key", "client.key", "client private key file name")
+	flags.String("format", formatPEM, "output format (pem, pkcs12)")
+	flags.String("p12", "client.p12", "pkcs12 file name (--format pkcs12)")
+	flags.String("password", "", "pkcs12 password (--format pkcs12)")
+	addKeyEncryptionFlags(flags)
+	addProfileFlag(flags)
+	addExtensionFlag(flags)
+	return &cmd
+}
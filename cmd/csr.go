@@ -0,0 +1,15 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+func csrCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "csr",
+		Short: "証明書要求(CSR)作成",
+		Long:  "証明書要求(CSR)作成",
+	}
+	cmd.AddCommand(newCSRCommand())
+	return &cmd
+}
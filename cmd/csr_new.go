@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+
+	"github.com/n-creativesystem/self-signed-certificate/pkg/ca"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func newCSRCommand() *cobra.Command {
+	initialize := initialize("csr_config")
+	cmd := cobra.Command{
+		Use:   "new",
+		Short: "証明書要求作成(csr,key)",
+		Long:  "秘密鍵とPKCS#10形式の証明書要求(CSR)を作成します。--reuseKey を指定すると既存の秘密鍵を使用します",
+		Run: func(cmd *cobra.Command, args []string) {
+			var err error
+			config, err := cmd.Flags().GetString("config")
+			if err != nil {
+				errorExit(err)
+			}
+			initialize(cmd, config)
+			var csrArg csrArgs
+			csrArg.serverArgs = parseRequestArgs()
+			csrFilename := viper.GetString("csr")
+			keyFilename := viper.GetString("key")
+			reuseKey := viper.GetBool("reuseKey")
+			if reuseKey {
+				csrArg.privateKey, err = readKeyFile(keyFilename)
+				if err != nil {
+					errorExit(err)
+				}
+			}
+			csrArg.keyEncryption = parseKeyEncryption()
+			csrArg.csr = &bytes.Buffer{}
+			csrArg.key = &bytes.Buffer{}
+			if err := runCSR(csrArg); err != nil {
+				errorExit(err)
+			}
+			fileCreate(csrFilename, csrArg.csr)
+			if !reuseKey {
+				fileCreate(keyFilename, csrArg.key)
+			}
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.String("config", "", "certificate request configuration")
+	flags.Int("bits", 2048, "rsa bits")
+	flags.String("keyType", ca.KeyTypeRSA, "key type (rsa, ecdsa-p256, ecdsa-p384, ecdsa-p521, ed25519)")
+	flags.StringSlice("country", []string{"JP"}, "country")
+	flags.StringSlice("organization", nil, "organization")
+	flags.StringSlice("organizationUnit", nil, "organization unit")
+	flags.String("commonName", "", "common name")
+	flags.StringSlice("dnsNames", nil, "subject alternate name dns names")
+	flags.StringSlice("ipAddresses", nil, "subject alternate name ip addresses")
+	flags.StringSlice("emailAddresses", nil, "subject alternate name email addresses")
+	flags.StringSlice("urls", nil, "subject alternate name urls")
+	flags.Bool("reuseKey", false, "use the existing private key file instead of generating a new one")
+	flags.String("csr", "server.csr", "certificate request file name")
+	flags.String("key", "server.key", "private key file name")
+	addKeyEncryptionFlags(flags)
+	return &cmd
+}
+
+type csrArgs struct {
+	serverArgs
+	privateKey crypto.Signer
+	csr        readWrite
+}
+
+func runCSR(args csrArgs) error {
+	privateKey := args.privateKey
+	if privateKey == nil {
+		var err error
+		privateKey, err = ca.GenerateKey(args.keyType, args.bits)
+		if err != nil {
+			return err
+		}
+	}
+	subject := pkix.Name{
+		CommonName:         args.commonName,
+		Organization:       args.organization,
+		OrganizationalUnit: args.organizationUnit,
+		Country:            args.country,
+	}
+	csrTpl := x509.CertificateRequest{
+		Subject:        subject,
+		DNSNames:       args.dnsNames,
+		IPAddresses:    args.ipAddresses,
+		EmailAddresses: args.emails,
+		URIs:           args.urls,
+	}
+	derCSR, err := x509.CreateCertificateRequest(rand.Reader, &csrTpl, privateKey)
+	if err != nil {
+		return err
+	}
+	err = pem.Encode(args.csr, &pem.Block{Type: "CERTIFICATE REQUEST", Bytes: derCSR})
+	if err != nil {
+		return err
+	}
+	if args.privateKey != nil {
+		return nil
+	}
+	keyPEM, err := args.keyEncryption.marshal(privateKey)
+	if err != nil {
+		return err
+	}
+	_, err = args.key.Write(keyPEM)
+	return err
+}
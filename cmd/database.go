@@ -0,0 +1,412 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/n-creativesystem/self-signed-certificate/pkg/ca"
+)
+
+const (
+	statusValid   = "V"
+	statusRevoked = "R"
+	statusExpired = "E"
+)
+
+var revocationReasons = []string{
+	"unspecified",
+	"keyCompromise",
+	"CACompromise",
+	"affiliationChanged",
+	"superseded",
+	"cessationOfOperation",
+	"certificateHold",
+	"",
+	"removeFromCRL",
+	"privilegeWithdrawn",
+	"AACompromise",
+}
+
+func parseRevocationReason(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	for code, name := range revocationReasons {
+		if name != "" && strings.EqualFold(name, s) {
+			return code, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid revocation reason %s", s)
+}
+
+// indexEntry は openssl ca の index.txt と同じ形式の1行を表します。
+type indexEntry struct {
+	status   string
+	expiry   time.Time
+	revoked  time.Time
+	reason   int
+	serial   *big.Int
+	filename string
+	subject  string
+}
+
+const (
+	serialTypeSequential = "sequential"
+	serialTypeRandom     = "random"
+)
+
+type database struct {
+	filename   string
+	serialFile string
+	entries    []*indexEntry
+	nextSerial *big.Int
+	reserved   []*big.Int
+}
+
+// caFilePath はCA証明書ファイル名を元に、CAごとの管理ファイル名を返します。
+// ca.crt であれば ca.index.txt、intermediate-chain.crt であれば intermediate.index.txt になります。
+func caFilePath(caCertFile, suffix string) string {
+	stem := strings.TrimSuffix(filepath.Base(caCertFile), filepath.Ext(caCertFile))
+	stem = strings.TrimSuffix(stem, "-chain")
+	return filepath.Join(filepath.Dir(caCertFile), stem+suffix)
+}
+
+func databasePath(caCertFile, database string) string {
+	if database != "" {
+		return database
+	}
+	return caFilePath(caCertFile, ".index.txt")
+}
+
+func loadDatabase(filename string) (*database, error) {
+	db := &database{
+		filename:   filename,
+		serialFile: strings.TrimSuffix(filename, ".index.txt") + ".serial",
+	}
+	buf, err := os.ReadFile(filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return db, nil
+		}
+		return nil, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	line := 0
+	for scanner.Scan() {
+		line++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		entry, err := parseIndexEntry(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", filename, line, err)
+		}
+		db.entries = append(db.entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func (db *database) save() error {
+	var buf bytes.Buffer
+	for _, entry := range db.entries {
+		buf.WriteString(entry.String())
+		buf.WriteByte('\n')
+	}
+	if err := os.WriteFile(db.filename, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	if db.nextSerial != nil {
+		return writeNumberFile(db.serialFile, db.nextSerial)
+	}
+	return nil
+}
+
+// reserve は自己署名CA証明書のserial numberを発行済みとして扱います。
+// 発行者名が同じになるため、CA自身と同じserial numberの証明書は発行できません。
+func (db *database) reserve(caCert *x509.Certificate) {
+	if bytes.Equal(caCert.RawSubject, caCert.RawIssuer) {
+		db.reserved = append(db.reserved, caCert.SerialNumber)
+	}
+}
+
+func (db *database) issued(serial *big.Int) bool {
+	for _, reserved := range db.reserved {
+		if reserved.Cmp(serial) == 0 {
+			return true
+		}
+	}
+	return db.find(serial) != nil
+}
+
+func (db *database) allocateSerial(serialNumber int, serialType string) (*big.Int, error) {
+	if serialNumber > 0 {
+		serial := big.NewInt(int64(serialNumber))
+		if db.issued(serial) {
+			return nil, fmt.Errorf("serial number %d is already issued", serialNumber)
+		}
+		return serial, nil
+	}
+	switch serialType {
+	case serialTypeSequential, "":
+		serial := db.nextSerial
+		if serial == nil {
+			var err error
+			serial, err = readNumberFile(db.serialFile)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for db.issued(serial) {
+			serial = new(big.Int).Add(serial, big.NewInt(1))
+		}
+		db.nextSerial = new(big.Int).Add(serial, big.NewInt(1))
+		return serial, nil
+	case serialTypeRandom:
+		for {
+			serial, err := ca.RandomSerialNumber()
+			if err != nil {
+				return nil, err
+			}
+			if !db.issued(serial) {
+				return serial, nil
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported serial type %s", serialType)
+	}
+}
+
+func (db *database) add(cert *x509.Certificate, filename string) error {
+	if db.issued(cert.SerialNumber) {
+		return fmt.Errorf("serial number %s is already issued", cert.SerialNumber.String())
+	}
+	entry := newIndexEntry(cert)
+	if filename != "" {
+		entry.filename = filename
+	}
+	db.entries = append(db.entries, entry)
+	return nil
+}
+
+func (db *database) find(serial *big.Int) *indexEntry {
+	for _, entry := range db.entries {
+		if entry.serial.Cmp(serial) == 0 {
+			return entry
+		}
+	}
+	return nil
+}
+
+func (db *database) revoke(entry *indexEntry, revoked time.Time, reason int) error {
+	if current := db.find(entry.serial); current != nil {
+		if current.status == statusRevoked {
+			return fmt.Errorf("serial %s is already revoked", current.serial.String())
+		}
+		entry = current
+	} else {
+		db.entries = append(db.entries, entry)
+	}
+	entry.status = statusRevoked
+	entry.revoked = revoked
+	entry.reason = reason
+	return nil
+}
+
+func (db *database) revokedCertificates() []pkix.RevokedCertificate {
+	var revoked []pkix.RevokedCertificate
+	for _, entry := range db.entries {
+		if entry.status != statusRevoked {
+			continue
+		}
+		rc := pkix.RevokedCertificate{
+			SerialNumber:   entry.serial,
+			RevocationTime: entry.revoked,
+		}
+		if entry.reason != 0 {
+			value, _ := asn1.Marshal(asn1.Enumerated(entry.reason))
+			rc.Extensions = []pkix.Extension{{Id: oidExtensionReasonCode, Value: value}}
+		}
+		revoked = append(revoked, rc)
+	}
+	return revoked
+}
+
+var oidExtensionReasonCode = asn1.ObjectIdentifier{2, 5, 29, 21}
+
+func newIndexEntry(cert *x509.Certificate) *indexEntry {
+	return &indexEntry{
+		status:   statusValid,
+		expiry:   cert.NotAfter,
+		serial:   cert.SerialNumber,
+		filename: "unknown",
+		subject:  formatSubject(cert.Subject),
+	}
+}
+
+func parseIndexEntry(line string) (*indexEntry, error) {
+	fields := strings.Split(line, "\t")
+	if len(fields) != 6 {
+		return nil, errors.New("invalid index entry")
+	}
+	entry := &indexEntry{
+		status:   fields[0],
+		filename: fields[4],
+		subject:  fields[5],
+	}
+	switch entry.status {
+	case statusValid, statusRevoked, statusExpired:
+	default:
+		return nil, fmt.Errorf("invalid status %s", entry.status)
+	}
+	var err error
+	if fields[1] != "" {
+		if entry.expiry, err = parseIndexTime(fields[1]); err != nil {
+			return nil, err
+		}
+	}
+	if fields[2] != "" {
+		revoked := strings.SplitN(fields[2], ",", 2)
+		if entry.revoked, err = parseIndexTime(revoked[0]); err != nil {
+			return nil, err
+		}
+		if len(revoked) == 2 {
+			if entry.reason, err = parseRevocationReason(revoked[1]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	serial, ok := new(big.Int).SetString(fields[3], 16)
+	if !ok {
+		return nil, fmt.Errorf("invalid serial %s", fields[3])
+	}
+	entry.serial = serial
+	return entry, nil
+}
+
+func (entry *indexEntry) currentStatus(now time.Time) string {
+	if entry.status == statusValid && !entry.expiry.IsZero() && now.After(entry.expiry) {
+		return statusExpired
+	}
+	return entry.status
+}
+
+func (entry *indexEntry) String() string {
+	var expiry, revoked string
+	if !entry.expiry.IsZero() {
+		expiry = formatIndexTime(entry.expiry)
+	}
+	if entry.status == statusRevoked {
+		revoked = formatIndexTime(entry.revoked)
+		if entry.reason != 0 {
+			revoked += "," + revocationReasons[entry.reason]
+		}
+	}
+	return strings.Join([]string{
+		entry.status,
+		expiry,
+		revoked,
+		formatSerial(entry.serial),
+		entry.filename,
+		entry.subject,
+	}, "\t")
+}
+
+func formatSerial(serial *big.Int) string {
+	s := strings.ToUpper(serial.Text(16))
+	if len(s)%2 == 1 {
+		s = "0" + s
+	}
+	return s
+}
+
+func parseIndexTime(s string) (time.Time, error) {
+	if len(s) == len("060102150405Z") {
+		return time.Parse("060102150405Z", s)
+	}
+	return time.Parse("20060102150405Z", s)
+}
+
+func formatIndexTime(t time.Time) string {
+	t = t.UTC()
+	if t.Year() < 2050 {
+		return t.Format("060102150405Z")
+	}
+	return t.Format("20060102150405Z")
+}
+
+var attributeShortNames = map[string]string{
+	"2.5.4.3":                    "CN",
+	"2.5.4.5":                    "serialNumber",
+	"2.5.4.6":                    "C",
+	"2.5.4.7":                    "L",
+	"2.5.4.8":                    "ST",
+	"2.5.4.9":                    "street",
+	"2.5.4.10":                   "O",
+	"2.5.4.11":                   "OU",
+	"2.5.4.17":                   "postalCode",
+	"1.2.840.113549.1.9.1":       "emailAddress",
+	"0.9.2342.19200300.100.1.25": "DC",
+}
+
+func formatSubject(name pkix.Name) string {
+	var b strings.Builder
+	for _, rdn := range name.ToRDNSequence() {
+		for _, atv := range rdn {
+			key, ok := attributeShortNames[atv.Type.String()]
+			if !ok {
+				key = atv.Type.String()
+			}
+			fmt.Fprintf(&b, "/%s=%v", key, atv.Value)
+		}
+	}
+	return b.String()
+}
+
+func readNumberFile(filename string) (*big.Int, error) {
+	buf, err := os.ReadFile(filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return big.NewInt(1), nil
+		}
+		return nil, err
+	}
+	n, ok := new(big.Int).SetString(strings.TrimSpace(string(buf)), 16)
+	if !ok {
+		return nil, fmt.Errorf("%s: invalid number", filename)
+	}
+	return n, nil
+}
+
+func writeNumberFile(filename string, n *big.Int) error {
+	return os.WriteFile(filename, []byte(formatSerial(n)+"\n"), 0644)
+}
+
+func parseSerial(s string) (*big.Int, error) {
+	base := 10
+	switch {
+	case strings.HasPrefix(s, "0x"), strings.HasPrefix(s, "0X"):
+		s = s[2:]
+		base = 16
+	case strings.Contains(s, ":"):
+		s = strings.ReplaceAll(s, ":", "")
+		base = 16
+	}
+	n, ok := new(big.Int).SetString(s, base)
+	if !ok || n.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid serial number %s", s)
+	}
+	return n, nil
+}
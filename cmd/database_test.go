@@ -0,0 +1,240 @@
+package cmd
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestDatabase(t *testing.T) *database {
+	t.Helper()
+	db, err := loadDatabase(filepath.Join(t.TempDir(), "ca.index.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func newTestIndexCert(serial int64, cn string) *x509.Certificate {
+	return &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		NotAfter:     time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC),
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"Example"}},
+	}
+}
+
+func TestAllocateSerialSequential(t *testing.T) {
+	db := newTestDatabase(t)
+	// 自己署名CA証明書のserial numberは使用しません
+	db.reserve(&x509.Certificate{SerialNumber: big.NewInt(1), RawSubject: []byte("ca"), RawIssuer: []byte("ca")})
+	// 中間CA証明書は発行者名が異なるため予約しません
+	db.reserve(&x509.Certificate{SerialNumber: big.NewInt(3), RawSubject: []byte("inter"), RawIssuer: []byte("root")})
+	// インデックスに記録済みのserial numberも使用しません
+	if err := db.add(newTestIndexCert(4, "imported"), "imported.crt"); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for i := 0; i < 3; i++ {
+		serial, err := db.allocateSerial(0, serialTypeSequential)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, formatSerial(serial))
+		if err := db.add(newTestIndexCert(serial.Int64(), "leaf"), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if strings.Join(got, ",") != "02,03,05" {
+		t.Errorf("got %v", got)
+	}
+	if err := db.save(); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := os.ReadFile(db.serialFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "06\n" {
+		t.Errorf("serial file: %q", buf)
+	}
+
+	// 次回は .serial ファイルの番号から割り当てます
+	db, err = loadDatabase(db.filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := db.allocateSerial(0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serial.Int64() != 6 {
+		t.Errorf("got %v", serial)
+	}
+}
+
+func TestAllocateSerialRandom(t *testing.T) {
+	db := newTestDatabase(t)
+	seen := map[string]bool{}
+	for i := 0; i < 10; i++ {
+		serial, err := db.allocateSerial(0, serialTypeRandom)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if serial.Sign() <= 0 || serial.BitLen() > 128 || seen[serial.String()] {
+			t.Errorf("serial %v", serial)
+		}
+		seen[serial.String()] = true
+	}
+	if err := db.save(); err != nil {
+		t.Fatal(err)
+	}
+	// ランダムの場合は .serial ファイルを作成しません
+	if _, err := os.Stat(db.serialFile); !os.IsNotExist(err) {
+		t.Errorf("serial file: %v", err)
+	}
+}
+
+func TestAllocateSerialDuplicate(t *testing.T) {
+	db := newTestDatabase(t)
+	db.reserve(&x509.Certificate{SerialNumber: big.NewInt(1), RawSubject: []byte("ca"), RawIssuer: []byte("ca")})
+	if err := db.add(newTestIndexCert(2, "leaf"), "leaf.crt"); err != nil {
+		t.Fatal(err)
+	}
+	for _, serialNumber := range []int{1, 2} {
+		if _, err := db.allocateSerial(serialNumber, serialTypeSequential); err == nil || !strings.Contains(err.Error(), "already issued") {
+			t.Errorf("serial %d: %v", serialNumber, err)
+		}
+	}
+	serial, err := db.allocateSerial(10, serialTypeSequential)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serial.Int64() != 10 {
+		t.Errorf("got %v", serial)
+	}
+	for _, serial := range []int64{1, 2} {
+		if err := db.add(newTestIndexCert(serial, "dup"), ""); err == nil || !strings.Contains(err.Error(), "already issued") {
+			t.Errorf("add serial %d: %v", serial, err)
+		}
+	}
+	if len(db.entries) != 1 {
+		t.Errorf("entries: %d", len(db.entries))
+	}
+	if _, err := db.allocateSerial(0, "uuid"); err == nil {
+		t.Error("unsupported serial type must be an error")
+	}
+}
+
+func TestDatabaseRevoke(t *testing.T) {
+	db := newTestDatabase(t)
+	if err := db.add(newTestIndexCert(2, "app.internal"), "app.crt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.add(newTestIndexCert(3, "other.internal"), "other.crt"); err != nil {
+		t.Fatal(err)
+	}
+	revoked := time.Date(2025, 6, 7, 8, 9, 10, 0, time.UTC)
+	reason, err := parseRevocationReason("keycompromise")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.revoke(db.find(big.NewInt(2)), revoked, reason); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.revoke(db.find(big.NewInt(2)), revoked, reason); err == nil || !strings.Contains(err.Error(), "already revoked") {
+		t.Errorf("revoke twice: %v", err)
+	}
+	// データベースにない証明書も失効として記録します
+	if err := db.revoke(newIndexEntry(newTestIndexCert(0x1f, "lost.internal")), revoked, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := os.ReadFile(db.filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "R\t300102030405Z\t250607080910Z,keyCompromise\t02\tapp.crt\t/O=Example/CN=app.internal\n" +
+		"V\t300102030405Z\t\t03\tother.crt\t/O=Example/CN=other.internal\n" +
+		"R\t300102030405Z\t250607080910Z\t1F\tunknown\t/O=Example/CN=lost.internal\n"
+	if string(buf) != want {
+		t.Errorf("index:\n%s\nwant:\n%s", buf, want)
+	}
+
+	loaded, err := loadDatabase(db.filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := loaded.find(big.NewInt(2))
+	if entry == nil || entry.status != statusRevoked || !entry.revoked.Equal(revoked) || entry.reason != 1 || entry.filename != "app.crt" {
+		t.Errorf("entry: %+v", entry)
+	}
+	crl := loaded.revokedCertificates()
+	if len(crl) != 2 || crl[0].SerialNumber.Int64() != 2 || len(crl[0].Extensions) != 1 || crl[1].SerialNumber.Int64() != 0x1f || len(crl[1].Extensions) != 0 {
+		t.Errorf("revoked certificates: %+v", crl)
+	}
+}
+
+func TestParseIndexEntry(t *testing.T) {
+	tests := []struct {
+		line string
+		err  string
+	}{
+		// openssl ca が出力する形式
+		{line: "V\t341231235959Z\t\t1000\tunknown\t/C=JP/O=Example/CN=app.example"},
+		{line: "R\t341231235959Z\t240101000000Z,superseded\t1001\tunknown\t/CN=old"},
+		{line: "V\t20991231235959Z\t\t0A\tapi:alice\t/CN=long"},
+		{line: "V\t341231235959Z\t\t1000\tunknown", err: "invalid index entry"},
+		{line: "X\t341231235959Z\t\t1000\tunknown\t/CN=x", err: "invalid status X"},
+		{line: "V\t341231235959Z\t\tzz\tunknown\t/CN=x", err: "invalid serial zz"},
+		{line: "R\t341231235959Z\t240101000000Z,bogus\t1000\tunknown\t/CN=x", err: "invalid revocation reason bogus"},
+	}
+	for _, tt := range tests {
+		entry, err := parseIndexEntry(tt.line)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%q: got %v, want error containing %q", tt.line, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.line, err)
+			continue
+		}
+		if entry.String() != tt.line {
+			t.Errorf("got %q, want %q", entry.String(), tt.line)
+		}
+	}
+}
+
+func TestParseSerial(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int64
+	}{
+		{s: "10", want: 10},
+		{s: "0x1F", want: 0x1f},
+		{s: "01:00", want: 0x100},
+	}
+	for _, tt := range tests {
+		got, err := parseSerial(tt.s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Int64() != tt.want {
+			t.Errorf("%s: got %v, want %d", tt.s, got, tt.want)
+		}
+	}
+	for _, s := range []string{"0", "-1", "0xzz", "abc"} {
+		if _, err := parseSerial(s); err == nil {
+			t.Errorf("%s must be an error", s)
+		}
+	}
+}
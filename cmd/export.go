@@ -0,0 +1,17 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+func exportCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "export",
+		Short: "証明書の形式変換",
+		Long:  "PEM形式の証明書と秘密鍵を他の形式に変換します",
+	}
+	cmd.AddCommand(exportP12Command())
+	cmd.AddCommand(exportKeyStoreCommand())
+	cmd.AddCommand(exportTrustStoreCommand())
+	return &cmd
+}
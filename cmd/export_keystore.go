@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func exportKeyStoreCommand() *cobra.Command {
+	initialize := initialize("export_config")
+	cmd := cobra.Command{
+		Use:   "keystore",
+		Short: "Javaキーストア作成(jks, pkcs12)",
+		Long:  "証明書、秘密鍵、CA証明書チェーンからJavaのキーストアを作成します",
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := cmd.Flags().GetString("config")
+			if err != nil {
+				errorExit(err)
+			}
+			initialize(cmd, config)
+			var ksArg keyStoreArgs
+			ksArg.cert, err = os.ReadFile(viper.GetString("cert"))
+			if err != nil {
+				errorExit(err)
+			}
+			ksArg.key, err = os.ReadFile(viper.GetString("key"))
+			if err != nil {
+				errorExit(err)
+			}
+			if caCertFilename := viper.GetString("caCert"); caCertFilename != "" {
+				ksArg.caCert, err = os.ReadFile(caCertFilename)
+				if err != nil {
+					errorExit(err)
+				}
+			}
+			ksArg.alias = viper.GetString("alias")
+			ksArg.storeType = viper.GetString("storeType")
+			if ksArg.storeType == storeTypePKCS12 && viper.IsSet("alias") {
+				errorExit(errors.New("--alias is not supported with --storeType pkcs12"))
+			}
+			ksArg.storePassword = viper.GetString("storePassword")
+			ksArg.keyPassword = viper.GetString("keyPassword")
+			out := &bytes.Buffer{}
+			ksArg.out = out
+			if err := runKeyStore(ksArg); err != nil {
+				errorExit(err)
+			}
+			outFilename := viper.GetString("out")
+			if outFilename == "" {
+				outFilename = storeFileName("keystore", ksArg.storeType)
+			}
+			fileCreate(outFilename, out)
+		},
+	}
+	flags := cmd.Flags()
+	flags.String("config", "", "export configuration")
+	flags.String("cert", "server.crt", "cert file name")
+	flags.String("key", "server.key", "private key file name")
+	flags.String("caCert", "ca.crt", "ca cert (chain) file name to include (empty: none)")
+	flags.String("alias", "server", "key entry alias (jks only)")
+	flags.String("storeType", storeTypeJKS, "keystore type (jks, pkcs12)")
+	flags.String("storePassword", "changeit", "keystore password")
+	flags.String("keyPassword", "", "key entry password for jks (default: same as storePassword)")
+	flags.String("out", "", "keystore file name (default: keystore.jks, keystore.p12)")
+	return &cmd
+}
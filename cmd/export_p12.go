@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func exportP12Command() *cobra.Command {
+	initialize := initialize("export_config")
+	cmd := cobra.Command{
+		Use:   "p12",
+		Short: "PKCS#12ファイル作成(p12, pfx)",
+		Long:  "証明書、秘密鍵、CA証明書チェーンをパスワード付きのPKCS#12ファイルにまとめます",
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := cmd.Flags().GetString("config")
+			if err != nil {
+				errorExit(err)
+			}
+			initialize(cmd, config)
+			cert, err := os.ReadFile(viper.GetString("cert"))
+			if err != nil {
+				errorExit(err)
+			}
+			key, err := os.ReadFile(viper.GetString("key"))
+			if err != nil {
+				errorExit(err)
+			}
+			var caCert []byte
+			if caCertFilename := viper.GetString("caCert"); caCertFilename != "" {
+				caCert, err = os.ReadFile(caCertFilename)
+				if err != nil {
+					errorExit(err)
+				}
+			}
+			p12 := &bytes.Buffer{}
+			if err := writePKCS12(p12, bytes.NewReader(cert), bytes.NewReader(key), caCert, viper.GetString("password")); err != nil {
+				errorExit(err)
+			}
+			fileCreate(viper.GetString("out"), p12)
+		},
+	}
+	flags := cmd.Flags()
+	flags.String("config", "", "export configuration")
+	flags.String("cert", "server.crt", "cert file name")
+	flags.String("key", "server.key", "private key file name")
+	flags.String("caCert", "ca.crt", "ca cert (chain) file name to include (empty: none)")
+	flags.String("password", "", "pkcs12 password")
+	flags.String("out", "server.p12", "pkcs12 file name")
+	return &cmd
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func exportTrustStoreCommand() *cobra.Command {
+	initialize := initialize("export_config")
+	cmd := cobra.Command{
+		Use:   "truststore",
+		Short: "Javaトラストストア作成(jks, pkcs12)",
+		Long:  "CA証明書からJavaのトラストストアを作成します。複数の証明書を含むファイルの場合は2件目以降のaliasに連番を付与します",
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := cmd.Flags().GetString("config")
+			if err != nil {
+				errorExit(err)
+			}
+			initialize(cmd, config)
+			var tsArg trustStoreArgs
+			tsArg.caCert, err = os.ReadFile(viper.GetString("caCert"))
+			if err != nil {
+				errorExit(err)
+			}
+			tsArg.alias = viper.GetString("alias")
+			tsArg.storeType = viper.GetString("storeType")
+			tsArg.storePassword = viper.GetString("storePassword")
+			out := &bytes.Buffer{}
+			tsArg.out = out
+			if err := runTrustStore(tsArg); err != nil {
+				errorExit(err)
+			}
+			outFilename := viper.GetString("out")
+			if outFilename == "" {
+				outFilename = storeFileName("truststore", tsArg.storeType)
+			}
+			fileCreate(outFilename, out)
+		},
+	}
+	flags := cmd.Flags()
+	flags.String("config", "", "export configuration")
+	flags.String("caCert", "ca.crt", "ca cert file name")
+	flags.String("alias", "ca", "trusted certificate alias")
+	flags.String("storeType", storeTypeJKS, "truststore type (jks, pkcs12)")
+	flags.String("storePassword", "changeit", "truststore password")
+	flags.String("out", "", "truststore file name (default: truststore.jks, truststore.p12)")
+	return &cmd
+}
@@ -0,0 +1,379 @@
+package cmd
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// extensionConfig は設定ファイルの extensions、profiles.<name>.extensions の1項目です。
+type extensionConfig struct {
+	OID      string
+	Critical bool
+	Value    string
+}
+
+// reservedExtensions は発行時に生成、確認する拡張です。上書きすると名前制約や署名ポリシーの確認を迂回できるため指定できません。
+var reservedExtensions = []asn1.ObjectIdentifier{
+	{2, 5, 29, 14}, // subjectKeyIdentifier
+	{2, 5, 29, 15}, // keyUsage
+	{2, 5, 29, 17}, // subjectAltName
+	{2, 5, 29, 19}, // basicConstraints
+	{2, 5, 29, 30}, // nameConstraints
+	{2, 5, 29, 35}, // authorityKeyIdentifier
+	{2, 5, 29, 37}, // extKeyUsage
+}
+
+func parseExtensionOID(s string) (asn1.ObjectIdentifier, error) {
+	oid, err := parseOID(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid extension oid %s", s)
+	}
+	if containsOID(reservedExtensions, oid) {
+		return nil, fmt.Errorf("extension %s (%s) is generated by ssc and can not be set", s, extensionNames[oid.String()])
+	}
+	return oid, nil
+}
+
+func addExtensionFlag(flags *pflag.FlagSet) {
+	flags.StringArray("extension", nil, "custom extension oid=[critical,]value (value: DER:hex, UTF8:text, IA5:text, INT:n or asn1 description, e.g. SEQUENCE{UTF8:a,INT:1})")
+}
+
+// parseExtensions は設定ファイルの extensions と --extension で指定した拡張を返します。
+func parseExtensions() ([]pkix.Extension, error) {
+	var configs []extensionConfig
+	if err := viper.UnmarshalKey("extensions", &configs); err != nil {
+		return nil, err
+	}
+	exts, err := extensionsFromConfig(configs)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range viper.GetStringSlice("extension") {
+		ext, err := parseExtensionFlag(s)
+		if err != nil {
+			return nil, err
+		}
+		exts = setExtension(exts, ext)
+	}
+	return exts, nil
+}
+
+func extensionsFromConfig(configs []extensionConfig) ([]pkix.Extension, error) {
+	var exts []pkix.Extension
+	for _, config := range configs {
+		oid, err := parseExtensionOID(config.OID)
+		if err != nil {
+			return nil, err
+		}
+		value, err := parseExtensionValue(config.Value)
+		if err != nil {
+			return nil, fmt.Errorf("extension %s: %w", config.OID, err)
+		}
+		exts = setExtension(exts, pkix.Extension{Id: oid, Critical: config.Critical, Value: value})
+	}
+	return exts, nil
+}
+
+// parseExtensionFlag は oid=[critical,]value 形式の拡張を読み込みます。
+func parseExtensionFlag(s string) (pkix.Extension, error) {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 {
+		return pkix.Extension{}, fmt.Errorf("invalid extension %s (oid=[critical,]value)", s)
+	}
+	oid, err := parseExtensionOID(strings.TrimSpace(kv[0]))
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	ext := pkix.Extension{Id: oid}
+	value := kv[1]
+	if strings.HasPrefix(strings.ToLower(value), "critical,") {
+		ext.Critical = true
+		value = value[len("critical,"):]
+	}
+	if ext.Value, err = parseExtensionValue(value); err != nil {
+		return pkix.Extension{}, fmt.Errorf("extension %s: %w", kv[0], err)
+	}
+	return ext, nil
+}
+
+// parseExtensionValue は拡張の値(ASN.1の記述。先頭の ASN1: は省略可)をDERに変換します。
+// DERをそのまま指定する場合は DER:16進数 とします。
+func parseExtensionValue(s string) ([]byte, error) {
+	s = strings.TrimLeft(s, " ")
+	if len(s) > 5 && strings.EqualFold(s[:5], "ASN1:") {
+		s = s[5:]
+	}
+	return parseASN1(s)
+}
+
+// parseDERHex は16進数(: 区切り可)のDERを読み込み、1つのASN.1の値であることを確認します。
+func parseDERHex(s string) ([]byte, error) {
+	der, err := hex.DecodeString(strings.ReplaceAll(s, ":", ""))
+	if err != nil {
+		return nil, err
+	}
+	var raw asn1.RawValue
+	if rest, err := asn1.Unmarshal(der, &raw); err != nil {
+		return nil, fmt.Errorf("invalid der: %w", err)
+	} else if len(rest) > 0 {
+		return nil, errors.New("invalid der: trailing data")
+	}
+	return der, nil
+}
+
+// parseASN1 はASN.1の記述をDERに変換します。
+//
+//	UTF8:text, IA5:text, PRINTABLE:text, INT:n, BOOL:true, OID:1.2.3, OCTET:hex, DER:hex, NULL,
+//	SEQUENCE{value,...}, SET{value,...}, [n]{value,...}(context-specificタグ)
+//
+// text の中の , } \ は \ でエスケープします。
+func parseASN1(s string) ([]byte, error) {
+	p := &asn1Parser{s: s}
+	der, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.s) {
+		return nil, fmt.Errorf("asn1: unexpected %q", p.s[p.pos:])
+	}
+	return der, nil
+}
+
+type asn1Parser struct {
+	s   string
+	pos int
+}
+
+func (p *asn1Parser) skipSpace() {
+	for p.pos < len(p.s) && p.s[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *asn1Parser) consume(token string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.s[p.pos:], token) {
+		p.pos += len(token)
+		return true
+	}
+	return false
+}
+
+func (p *asn1Parser) value() ([]byte, error) {
+	if p.consume("[") {
+		end := strings.Index(p.s[p.pos:], "]")
+		if end < 0 {
+			return nil, errors.New("asn1: missing ]")
+		}
+		tag, err := strconv.Atoi(p.s[p.pos : p.pos+end])
+		if err != nil || tag < 0 {
+			return nil, fmt.Errorf("asn1: invalid tag %s", p.s[p.pos:p.pos+end])
+		}
+		p.pos += end + 1
+		return p.constructed(asn1.ClassContextSpecific, tag)
+	}
+	start := p.pos
+	for p.pos < len(p.s) && strings.IndexByte(":{,}", p.s[p.pos]) < 0 {
+		p.pos++
+	}
+	name := strings.ToUpper(strings.TrimSpace(p.s[start:p.pos]))
+	switch name {
+	case "SEQUENCE", "SEQ":
+		return p.constructed(asn1.ClassUniversal, asn1.TagSequence)
+	case "SET":
+		return p.constructed(asn1.ClassUniversal, asn1.TagSet)
+	case "NULL":
+		return asn1.NullBytes, nil
+	}
+	if !p.consume(":") {
+		return nil, fmt.Errorf("asn1: invalid value %q (TYPE:value, SEQUENCE{...}, SET{...}, [n]{...} or NULL. use DER:hex for der)", p.s[start:])
+	}
+	text := p.text()
+	switch name {
+	case "UTF8", "UTF8STRING":
+		return asn1.MarshalWithParams(text, "utf8")
+	case "IA5", "IA5STRING":
+		return asn1.MarshalWithParams(text, "ia5")
+	case "PRINTABLE", "PRINTABLESTRING":
+		return asn1.MarshalWithParams(text, "printable")
+	case "INT", "INTEGER":
+		n, ok := new(big.Int).SetString(text, 0)
+		if !ok {
+			return nil, fmt.Errorf("asn1: invalid integer %s", text)
+		}
+		return asn1.Marshal(n)
+	case "BOOL", "BOOLEAN":
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return nil, fmt.Errorf("asn1: invalid boolean %s", text)
+		}
+		return asn1.Marshal(b)
+	case "OID", "OBJECT":
+		oid, err := parseOID(text)
+		if err != nil {
+			return nil, err
+		}
+		return asn1.Marshal(oid)
+	case "OCTET", "OCTETSTRING":
+		b, err := hex.DecodeString(strings.ReplaceAll(text, ":", ""))
+		if err != nil {
+			return nil, fmt.Errorf("asn1: invalid octet string %s", text)
+		}
+		return asn1.Marshal(b)
+	case "DER":
+		return parseDERHex(text)
+	default:
+		return nil, fmt.Errorf("asn1: unsupported type %s", name)
+	}
+}
+
+func (p *asn1Parser) constructed(class, tag int) ([]byte, error) {
+	if !p.consume("{") {
+		return nil, errors.New("asn1: missing {")
+	}
+	var content []byte
+	if !p.consume("}") {
+		for {
+			der, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			content = append(content, der...)
+			if p.consume(",") {
+				continue
+			}
+			if p.consume("}") {
+				break
+			}
+			return nil, errors.New("asn1: missing }")
+		}
+	}
+	return asn1.Marshal(asn1.RawValue{Class: class, Tag: tag, IsCompound: true, Bytes: content})
+}
+
+// text は , } までの値を返します。前後の空白は \ でエスケープしない限り除きます。
+func (p *asn1Parser) text() string {
+	p.skipSpace()
+	var b []byte
+	keep := 0
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		if c == ',' || c == '}' {
+			break
+		}
+		escaped := c == '\\' && p.pos+1 < len(p.s)
+		if escaped {
+			p.pos++
+			c = p.s[p.pos]
+		}
+		b = append(b, c)
+		if escaped || c != ' ' {
+			keep = len(b)
+		}
+		p.pos++
+	}
+	return string(b[:keep])
+}
+
+// describeASN1 はDERを parseASN1 と同じ形式の記述に変換します。
+func describeASN1(der []byte) (string, error) {
+	var raw asn1.RawValue
+	rest, err := asn1.Unmarshal(der, &raw)
+	if err != nil {
+		return "", err
+	}
+	if len(rest) > 0 {
+		return "", errors.New("trailing data")
+	}
+	return describeRawValue(raw), nil
+}
+
+func describeRawValue(raw asn1.RawValue) string {
+	switch {
+	case raw.IsCompound && raw.Class == asn1.ClassUniversal && (raw.Tag == asn1.TagSequence || raw.Tag == asn1.TagSet):
+		if items, ok := describeItems(raw.Bytes); ok {
+			name := "SEQUENCE"
+			if raw.Tag == asn1.TagSet {
+				name = "SET"
+			}
+			return name + "{" + strings.Join(items, ",") + "}"
+		}
+	case raw.IsCompound && raw.Class == asn1.ClassContextSpecific:
+		if items, ok := describeItems(raw.Bytes); ok {
+			return fmt.Sprintf("[%d]{%s}", raw.Tag, strings.Join(items, ","))
+		}
+	case !raw.IsCompound && raw.Class == asn1.ClassUniversal:
+		if s, ok := describePrimitive(raw); ok {
+			return s
+		}
+	}
+	return "DER:" + hex.EncodeToString(raw.FullBytes)
+}
+
+func describeItems(der []byte) ([]string, bool) {
+	items := []string{}
+	for len(der) > 0 {
+		var raw asn1.RawValue
+		rest, err := asn1.Unmarshal(der, &raw)
+		if err != nil {
+			return nil, false
+		}
+		items = append(items, describeRawValue(raw))
+		der = rest
+	}
+	return items, true
+}
+
+func describePrimitive(raw asn1.RawValue) (string, bool) {
+	switch raw.Tag {
+	case asn1.TagBoolean:
+		var b bool
+		if _, err := asn1.Unmarshal(raw.FullBytes, &b); err == nil {
+			return fmt.Sprintf("BOOL:%t", b), true
+		}
+	case asn1.TagInteger:
+		n := new(big.Int)
+		if _, err := asn1.Unmarshal(raw.FullBytes, &n); err == nil {
+			return "INT:" + n.String(), true
+		}
+	case asn1.TagOctetString:
+		return "OCTET:" + hex.EncodeToString(raw.Bytes), true
+	case asn1.TagNull:
+		if len(raw.Bytes) == 0 {
+			return "NULL", true
+		}
+	case asn1.TagOID:
+		var oid asn1.ObjectIdentifier
+		if _, err := asn1.Unmarshal(raw.FullBytes, &oid); err == nil {
+			return "OID:" + oid.String(), true
+		}
+	case asn1.TagUTF8String:
+		return "UTF8:" + escapeASN1Text(string(raw.Bytes)), true
+	case asn1.TagPrintableString:
+		return "PRINTABLE:" + escapeASN1Text(string(raw.Bytes)), true
+	case asn1.TagIA5String:
+		return "IA5:" + escapeASN1Text(string(raw.Bytes)), true
+	}
+	return "", false
+}
+
+func escapeASN1Text(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, ",", `\,`, "}", `\}`).Replace(s)
+	if strings.HasPrefix(s, " ") {
+		s = `\` + s
+	}
+	if strings.HasSuffix(s, " ") {
+		s = s[:len(s)-1] + `\ `
+	}
+	return s
+}
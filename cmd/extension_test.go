@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestParseExtensionValue(t *testing.T) {
+	tests := []struct {
+		value    string
+		der      string
+		describe string
+	}{
+		{value: "UTF8:hello", der: "0c0568656c6c6f", describe: "UTF8:hello"},
+		{value: "utf8string:hello", der: "0c0568656c6c6f", describe: "UTF8:hello"},
+		{value: `UTF8:a\,b\}c\\d`, der: "0c07612c627d635c64", describe: `UTF8:a\,b\}c\\d`},
+		{value: "IA5:x@example.com", der: "160d78406578616d706c652e636f6d", describe: "IA5:x@example.com"},
+		{value: "PRINTABLE:ok", der: "13026f6b", describe: "PRINTABLE:ok"},
+		{value: "INT:-42", der: "0201d6", describe: "INT:-42"},
+		{value: "INT:0x10", der: "020110", describe: "INT:16"},
+		{value: "BOOL:true", der: "0101ff", describe: "BOOL:true"},
+		{value: "OID:1.2.3", der: "06022a03", describe: "OID:1.2.3"},
+		{value: "OCTET:be:ef", der: "0402beef", describe: "OCTET:beef"},
+		{value: "NULL", der: "0500", describe: "NULL"},
+		{value: "DER:0c:01:61", der: "0c0161", describe: "UTF8:a"},
+		{value: "ASN1:INT:1", der: "020101", describe: "INT:1"},
+		{value: "SEQUENCE{}", der: "3000", describe: "SEQUENCE{}"},
+		{value: "SET{INT:1}", der: "3103020101", describe: "SET{INT:1}"},
+		{value: "SEQUENCE{ OID:1.2.3 , BOOL:false }", der: "300706022a03010100", describe: "SEQUENCE{OID:1.2.3,BOOL:false}"},
+		{value: "[0]{IA5:z}", der: "a00316017a", describe: "[0]{IA5:z}"},
+		{value: "SEQ{[1]{INT:1,INT:2},SEQUENCE{UTF8:a\\,b}}", der: "", describe: ""},
+		{value: "DER:8001ff", der: "8001ff", describe: "DER:8001ff"},
+		{value: "UTF8: a b ", der: "0c03612062", describe: "UTF8:a b"},
+		{value: `UTF8:\ a\ `, der: "0c03206120", describe: `UTF8:\ a\ `},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			der, err := parseExtensionValue(tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.describe == "" {
+				// DERを確認せず、記述が元に戻ることだけを確認します
+				s, err := describeASN1(der)
+				if err != nil {
+					t.Fatal(err)
+				}
+				again, err := parseExtensionValue(s)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if hex.EncodeToString(again) != hex.EncodeToString(der) {
+					t.Errorf("round trip %s: got %x, want %x", s, again, der)
+				}
+				return
+			}
+			if got := hex.EncodeToString(der); got != tt.der {
+				t.Errorf("der: got %s, want %s", got, tt.der)
+			}
+			got, err := describeASN1(der)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.describe {
+				t.Errorf("describe: got %s, want %s", got, tt.describe)
+			}
+			again, err := parseExtensionValue(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hex.EncodeToString(again) != tt.der {
+				t.Errorf("round trip: got %x, want %s", again, tt.der)
+			}
+		})
+	}
+}
+
+func TestParseExtensionValueError(t *testing.T) {
+	tests := []struct {
+		value string
+		err   string
+	}{
+		{value: "cafe", err: "use DER:hex"},
+		{value: "0c0568656c6c6f", err: "use DER:hex"},
+		{value: "DER:cafe", err: "invalid der"},
+		{value: "DER:0c0161ff", err: "trailing data"},
+		{value: "FOO:x", err: "unsupported type FOO"},
+		{value: "INT:abc", err: "invalid integer"},
+		{value: "BOOL:yes", err: "invalid boolean"},
+		{value: "OCTET:xyz", err: "invalid octet string"},
+		{value: "SEQUENCE{INT:1", err: "missing }"},
+		{value: "SEQUENCE", err: "missing {"},
+		{value: "[x]{}", err: "invalid tag"},
+		{value: "[0", err: "missing ]"},
+		{value: "INT:1}", err: "unexpected"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			_, err := parseExtensionValue(tt.value)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got %v, want error containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestDescribeASN1Fallback(t *testing.T) {
+	// 記述できない値はDERの16進数で表示します
+	der, _ := hex.DecodeString("030200ff")
+	got, err := describeASN1(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "DER:030200ff" {
+		t.Errorf("got %s", got)
+	}
+	if _, err := describeASN1([]byte{0x0c, 0x01, 0x61, 0x00}); err == nil {
+		t.Error("trailing data must be an error")
+	}
+}
+
+func TestParseExtensionFlag(t *testing.T) {
+	ext, err := parseExtensionFlag("1.2.3.4=critical,UTF8:a,b")
+	if err == nil {
+		t.Fatalf("unescaped comma must be an error: %x", ext.Value)
+	}
+	ext, err = parseExtensionFlag(`1.2.3.4=critical,UTF8:a\,b`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ext.Id.String() != "1.2.3.4" || !ext.Critical || hex.EncodeToString(ext.Value) != "0c03612c62" {
+		t.Errorf("got %v %v %x", ext.Id, ext.Critical, ext.Value)
+	}
+	ext, err = parseExtensionFlag("1.2.3.4=INT:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ext.Critical {
+		t.Error("extension must not be critical")
+	}
+
+	for _, s := range []string{"1.2.3.4", "x=INT:1", "2.5.29.17=DER:3000", "2.5.29.19=critical,SEQUENCE{BOOL:true}"} {
+		if _, err := parseExtensionFlag(s); err == nil {
+			t.Errorf("%s: expected error", s)
+		}
+	}
+}
+
+func TestExtensionsFromConfig(t *testing.T) {
+	exts, err := extensionsFromConfig([]extensionConfig{
+		{OID: "1.2.3.4", Value: "INT:1"},
+		{OID: "1.2.3.4", Critical: true, Value: "INT:2"},
+		{OID: "1.2.3.5", Value: "NULL"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exts) != 2 || !exts[0].Critical || hex.EncodeToString(exts[0].Value) != "020102" {
+		t.Errorf("got %v", exts)
+	}
+	if _, err := extensionsFromConfig([]extensionConfig{{OID: "2.5.29.30", Value: "DER:3000"}}); err == nil {
+		t.Error("reserved extension must be an error")
+	}
+}
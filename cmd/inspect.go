@@ -0,0 +1,495 @@
+package cmd
+
+import (
+	"crypto"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/n-creativesystem/self-signed-certificate/pkg/ca"
+	"github.com/spf13/cobra"
+)
+
+func inspectCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "inspect <file>",
+		Short: "証明書、CSR、CRL、秘密鍵の内容表示",
+		Long:  "PEMまたはDER形式の証明書、証明書要求(CSR)、CRL、秘密鍵を読み込み、内容を表示します",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			asJSON, err := cmd.Flags().GetBool("json")
+			if err != nil {
+				errorExit(err)
+			}
+			data, err := os.ReadFile(args[0])
+			if err != nil {
+				errorExit(err)
+			}
+			results, err := inspect(data)
+			if err != nil {
+				errorExit(err)
+			}
+			if asJSON {
+				err = writeInspectJSON(os.Stdout, results)
+			} else {
+				err = writeInspectText(os.Stdout, results)
+			}
+			if err != nil {
+				errorExit(err)
+			}
+		},
+	}
+	cmd.Flags().Bool("json", false, "output in json")
+	return &cmd
+}
+
+type inspectResult struct {
+	Type               string              `json:"type"`
+	Subject            string              `json:"subject,omitempty"`
+	Issuer             string              `json:"issuer,omitempty"`
+	Serial             string              `json:"serial,omitempty"`
+	NotBefore          *time.Time          `json:"notBefore,omitempty"`
+	NotAfter           *time.Time          `json:"notAfter,omitempty"`
+	ThisUpdate         *time.Time          `json:"thisUpdate,omitempty"`
+	NextUpdate         *time.Time          `json:"nextUpdate,omitempty"`
+	IsCA               *bool               `json:"isCA,omitempty"`
+	MaxPathLen         *int                `json:"maxPathLen,omitempty"`
+	NameConstraints    []string            `json:"nameConstraints,omitempty"`
+	DNSNames           []string            `json:"dnsNames,omitempty"`
+	IPAddresses        []string            `json:"ipAddresses,omitempty"`
+	EmailAddresses     []string            `json:"emailAddresses,omitempty"`
+	URIs               []string            `json:"uris,omitempty"`
+	KeyUsage           []string            `json:"keyUsage,omitempty"`
+	ExtKeyUsage        []string            `json:"extKeyUsage,omitempty"`
+	KeyType            string              `json:"keyType,omitempty"`
+	SignatureAlgorithm string              `json:"signatureAlgorithm,omitempty"`
+	SubjectKeyID       string              `json:"subjectKeyId,omitempty"`
+	AuthorityKeyID     string              `json:"authorityKeyId,omitempty"`
+	Extensions         []inspectExtension  `json:"extensions,omitempty"`
+	Revoked            []inspectRevocation `json:"revoked,omitempty"`
+	Fingerprints       map[string]string   `json:"fingerprints,omitempty"`
+}
+
+type inspectExtension struct {
+	OID      string `json:"oid"`
+	Name     string `json:"name,omitempty"`
+	Critical bool   `json:"critical"`
+	Value    string `json:"value"`
+	Decoded  string `json:"decoded,omitempty"`
+}
+
+type inspectRevocation struct {
+	Serial         string    `json:"serial"`
+	RevocationTime time.Time `json:"revocationTime"`
+	Reason         string    `json:"reason,omitempty"`
+}
+
+func inspect(data []byte) ([]inspectResult, error) {
+	var results []inspectResult
+	blocks := decodePEM(data)
+	if len(blocks) == 0 {
+		result, err := inspectDER(data)
+		if err != nil {
+			return nil, err
+		}
+		return []inspectResult{result}, nil
+	}
+	for _, p := range blocks {
+		result, err := inspectPEM(p)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p.Type, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func inspectPEM(p *pem.Block) (inspectResult, error) {
+	switch p.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(p.Bytes)
+		if err != nil {
+			return inspectResult{}, err
+		}
+		return inspectCertificate(cert), nil
+	case "CERTIFICATE REQUEST", "NEW CERTIFICATE REQUEST":
+		csr, err := x509.ParseCertificateRequest(p.Bytes)
+		if err != nil {
+			return inspectResult{}, err
+		}
+		return inspectCSR(csr), nil
+	case "X509 CRL":
+		crl, err := x509.ParseRevocationList(p.Bytes)
+		if err != nil {
+			return inspectResult{}, err
+		}
+		return inspectCRL(p.Bytes, crl), nil
+	case "PUBLIC KEY":
+		pub, err := x509.ParsePKIXPublicKey(p.Bytes)
+		if err != nil {
+			return inspectResult{}, err
+		}
+		return inspectPublicKey("publicKey", pub), nil
+	case "ENCRYPTED PRIVATE KEY":
+		return inspectResult{Type: "encryptedPrivateKey"}, nil
+	default:
+		key, err := ca.ParsePrivateKey(p)
+		if err != nil {
+			return inspectResult{}, err
+		}
+		return inspectPublicKey("privateKey", key.Public()), nil
+	}
+}
+
+func inspectDER(der []byte) (inspectResult, error) {
+	if cert, err := x509.ParseCertificate(der); err == nil {
+		return inspectCertificate(cert), nil
+	}
+	if csr, err := x509.ParseCertificateRequest(der); err == nil {
+		return inspectCSR(csr), nil
+	}
+	if crl, err := x509.ParseRevocationList(der); err == nil {
+		return inspectCRL(der, crl), nil
+	}
+	for _, blockType := range []string{"PRIVATE KEY", "RSA PRIVATE KEY", "EC PRIVATE KEY"} {
+		if key, err := ca.ParsePrivateKey(&pem.Block{Type: blockType, Bytes: der}); err == nil {
+			return inspectPublicKey("privateKey", key.Public()), nil
+		}
+	}
+	if pub, err := x509.ParsePKIXPublicKey(der); err == nil {
+		return inspectPublicKey("publicKey", pub), nil
+	}
+	return inspectResult{}, errors.New("unknown file format")
+}
+
+func inspectCertificate(cert *x509.Certificate) inspectResult {
+	result := inspectResult{
+		Type:               "certificate",
+		Subject:            cert.Subject.String(),
+		Issuer:             cert.Issuer.String(),
+		Serial:             formatSerial(cert.SerialNumber),
+		NotBefore:          &cert.NotBefore,
+		NotAfter:           &cert.NotAfter,
+		DNSNames:           cert.DNSNames,
+		EmailAddresses:     cert.EmailAddresses,
+		KeyUsage:           keyUsageNames(cert.KeyUsage),
+		KeyType:            describePublicKey(cert.PublicKey),
+		SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+		SubjectKeyID:       hexString(cert.SubjectKeyId),
+		AuthorityKeyID:     hexString(cert.AuthorityKeyId),
+		Extensions:         inspectExtensions(cert.Extensions),
+		Fingerprints:       fingerprints(cert.Raw),
+	}
+	if cert.BasicConstraintsValid {
+		result.IsCA = &cert.IsCA
+		if cert.IsCA && (cert.MaxPathLen > 0 || cert.MaxPathLenZero) {
+			result.MaxPathLen = &cert.MaxPathLen
+		}
+	}
+	result.NameConstraints = nameConstraints(cert)
+	for _, ip := range cert.IPAddresses {
+		result.IPAddresses = append(result.IPAddresses, ip.String())
+	}
+	for _, u := range cert.URIs {
+		result.URIs = append(result.URIs, u.String())
+	}
+	for _, usage := range cert.ExtKeyUsage {
+		result.ExtKeyUsage = append(result.ExtKeyUsage, extKeyUsageName(usage))
+	}
+	for _, oid := range cert.UnknownExtKeyUsage {
+		result.ExtKeyUsage = append(result.ExtKeyUsage, oid.String())
+	}
+	return result
+}
+
+func nameConstraints(cert *x509.Certificate) []string {
+	var constraints []string
+	add := func(kind string, names []string) {
+		for _, name := range names {
+			constraints = append(constraints, kind+":"+name)
+		}
+	}
+	addIPs := func(kind string, ranges []*net.IPNet) {
+		for _, ipNet := range ranges {
+			constraints = append(constraints, kind+":"+ipNet.String())
+		}
+	}
+	add("permitted DNS", cert.PermittedDNSDomains)
+	add("excluded DNS", cert.ExcludedDNSDomains)
+	addIPs("permitted IP", cert.PermittedIPRanges)
+	addIPs("excluded IP", cert.ExcludedIPRanges)
+	add("permitted email", cert.PermittedEmailAddresses)
+	add("excluded email", cert.ExcludedEmailAddresses)
+	add("permitted URI", cert.PermittedURIDomains)
+	add("excluded URI", cert.ExcludedURIDomains)
+	return constraints
+}
+
+func inspectCSR(csr *x509.CertificateRequest) inspectResult {
+	result := inspectResult{
+		Type:               "certificateRequest",
+		Subject:            csr.Subject.String(),
+		DNSNames:           csr.DNSNames,
+		EmailAddresses:     csr.EmailAddresses,
+		KeyType:            describePublicKey(csr.PublicKey),
+		SignatureAlgorithm: csr.SignatureAlgorithm.String(),
+		Extensions:         inspectExtensions(csr.Extensions),
+		Fingerprints:       fingerprints(csr.Raw),
+	}
+	for _, ip := range csr.IPAddresses {
+		result.IPAddresses = append(result.IPAddresses, ip.String())
+	}
+	for _, u := range csr.URIs {
+		result.URIs = append(result.URIs, u.String())
+	}
+	return result
+}
+
+func inspectCRL(der []byte, crl *x509.RevocationList) inspectResult {
+	result := inspectResult{
+		Type:         "crl",
+		Issuer:       crl.Issuer.String(),
+		ThisUpdate:   &crl.ThisUpdate,
+		Extensions:   inspectExtensions(crl.Extensions),
+		Fingerprints: fingerprints(der),
+	}
+	if !crl.NextUpdate.IsZero() {
+		result.NextUpdate = &crl.NextUpdate
+	}
+	for _, rc := range crl.RevokedCertificateEntries {
+		revocation := inspectRevocation{
+			Serial:         formatSerial(rc.SerialNumber),
+			RevocationTime: rc.RevocationTime,
+		}
+		for _, ext := range rc.Extensions {
+			var reason asn1.Enumerated
+			if ext.Id.Equal(oidExtensionReasonCode) {
+				if _, err := asn1.Unmarshal(ext.Value, &reason); err == nil && reason >= 0 && int(reason) < len(revocationReasons) {
+					revocation.Reason = revocationReasons[reason]
+				}
+			}
+		}
+		result.Revoked = append(result.Revoked, revocation)
+	}
+	return result
+}
+
+func inspectPublicKey(resultType string, pub crypto.PublicKey) inspectResult {
+	result := inspectResult{
+		Type:    resultType,
+		KeyType: describePublicKey(pub),
+	}
+	if der, err := x509.MarshalPKIXPublicKey(pub); err == nil {
+		result.Fingerprints = map[string]string{"spki-sha256": formatFingerprint(sha256Sum(der))}
+	}
+	return result
+}
+
+var extensionNames = map[string]string{
+	"2.5.29.14":            "subjectKeyIdentifier",
+	"2.5.29.15":            "keyUsage",
+	"2.5.29.17":            "subjectAltName",
+	"2.5.29.19":            "basicConstraints",
+	"2.5.29.20":            "cRLNumber",
+	"2.5.29.30":            "nameConstraints",
+	"2.5.29.31":            "cRLDistributionPoints",
+	"2.5.29.32":            "certificatePolicies",
+	"2.5.29.35":            "authorityKeyIdentifier",
+	"2.5.29.37":            "extKeyUsage",
+	"1.3.6.1.5.5.7.1.1":    "authorityInfoAccess",
+	"1.3.6.1.5.5.7.48.1.5": "ocspNoCheck",
+}
+
+func inspectExtensions(exts []pkix.Extension) []inspectExtension {
+	var results []inspectExtension
+	for _, ext := range exts {
+		result := inspectExtension{
+			OID:      ext.Id.String(),
+			Name:     extensionNames[ext.Id.String()],
+			Critical: ext.Critical,
+			Value:    hex.EncodeToString(ext.Value),
+		}
+		if result.Name == "" {
+			// 独自の拡張は --extension と同じ形式で表示します
+			result.Decoded, _ = describeASN1(ext.Value)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+var keyUsages = []struct {
+	usage x509.KeyUsage
+	name  string
+}{
+	{x509.KeyUsageDigitalSignature, "digitalSignature"},
+	{x509.KeyUsageContentCommitment, "contentCommitment"},
+	{x509.KeyUsageKeyEncipherment, "keyEncipherment"},
+	{x509.KeyUsageDataEncipherment, "dataEncipherment"},
+	{x509.KeyUsageKeyAgreement, "keyAgreement"},
+	{x509.KeyUsageCertSign, "keyCertSign"},
+	{x509.KeyUsageCRLSign, "cRLSign"},
+	{x509.KeyUsageEncipherOnly, "encipherOnly"},
+	{x509.KeyUsageDecipherOnly, "decipherOnly"},
+}
+
+func keyUsageNames(usage x509.KeyUsage) []string {
+	var names []string
+	for _, ku := range keyUsages {
+		if usage&ku.usage != 0 {
+			names = append(names, ku.name)
+		}
+	}
+	return names
+}
+
+var extKeyUsages = []struct {
+	usage x509.ExtKeyUsage
+	name  string
+}{
+	{x509.ExtKeyUsageAny, "any"},
+	{x509.ExtKeyUsageServerAuth, "serverAuth"},
+	{x509.ExtKeyUsageClientAuth, "clientAuth"},
+	{x509.ExtKeyUsageCodeSigning, "codeSigning"},
+	{x509.ExtKeyUsageEmailProtection, "emailProtection"},
+	{x509.ExtKeyUsageIPSECEndSystem, "ipsecEndSystem"},
+	{x509.ExtKeyUsageIPSECTunnel, "ipsecTunnel"},
+	{x509.ExtKeyUsageIPSECUser, "ipsecUser"},
+	{x509.ExtKeyUsageTimeStamping, "timeStamping"},
+	{x509.ExtKeyUsageOCSPSigning, "ocspSigning"},
+}
+
+func extKeyUsageName(usage x509.ExtKeyUsage) string {
+	for _, eku := range extKeyUsages {
+		if eku.usage == usage {
+			return eku.name
+		}
+	}
+	return fmt.Sprintf("unknown(%d)", usage)
+}
+
+func hexString(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	return formatFingerprint(b)
+}
+
+func fingerprints(raw []byte) map[string]string {
+	sha1Sum := sha1.Sum(raw)
+	return map[string]string{
+		"sha256": formatFingerprint(sha256Sum(raw)),
+		"sha1":   formatFingerprint(sha1Sum[:]),
+	}
+}
+
+func sha256Sum(b []byte) []byte {
+	sum := sha256.Sum256(b)
+	return sum[:]
+}
+
+func formatFingerprint(b []byte) string {
+	parts := make([]string, len(b))
+	for i, c := range b {
+		parts[i] = fmt.Sprintf("%02X", c)
+	}
+	return strings.Join(parts, ":")
+}
+
+func writeInspectJSON(w io.Writer, results []inspectResult) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if len(results) == 1 {
+		return enc.Encode(results[0])
+	}
+	return enc.Encode(results)
+}
+
+func writeInspectText(w io.Writer, results []inspectResult) error {
+	for i, r := range results {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		printField(w, "Type", r.Type)
+		printField(w, "Subject", r.Subject)
+		printField(w, "Issuer", r.Issuer)
+		printField(w, "Serial", r.Serial)
+		printTime(w, "Not Before", r.NotBefore)
+		printTime(w, "Not After", r.NotAfter)
+		printTime(w, "This Update", r.ThisUpdate)
+		printTime(w, "Next Update", r.NextUpdate)
+		if r.IsCA != nil {
+			printField(w, "CA", fmt.Sprint(*r.IsCA))
+		}
+		if r.MaxPathLen != nil {
+			printField(w, "Max Path Length", fmt.Sprint(*r.MaxPathLen))
+		}
+		printField(w, "Name Constraints", strings.Join(r.NameConstraints, ", "))
+		printField(w, "DNS Names", strings.Join(r.DNSNames, ", "))
+		printField(w, "IP Addresses", strings.Join(r.IPAddresses, ", "))
+		printField(w, "Email Addresses", strings.Join(r.EmailAddresses, ", "))
+		printField(w, "URIs", strings.Join(r.URIs, ", "))
+		printField(w, "Key Usage", strings.Join(r.KeyUsage, ", "))
+		printField(w, "Ext Key Usage", strings.Join(r.ExtKeyUsage, ", "))
+		printField(w, "Key Type", r.KeyType)
+		printField(w, "Signature Algorithm", r.SignatureAlgorithm)
+		printField(w, "Subject Key ID", r.SubjectKeyID)
+		printField(w, "Authority Key ID", r.AuthorityKeyID)
+		if len(r.Extensions) > 0 {
+			fmt.Fprintln(w, "Extensions:")
+			for _, ext := range r.Extensions {
+				name := ext.OID
+				if ext.Name != "" {
+					name = fmt.Sprintf("%s (%s)", ext.Name, ext.OID)
+				}
+				critical := ""
+				if ext.Critical {
+					critical = " critical"
+				}
+				value := ext.Value
+				if ext.Decoded != "" {
+					value = ext.Decoded
+				}
+				fmt.Fprintf(w, "  %s%s: %s\n", name, critical, value)
+			}
+		}
+		if len(r.Revoked) > 0 {
+			fmt.Fprintln(w, "Revoked:")
+			for _, rc := range r.Revoked {
+				fmt.Fprintf(w, "  %s  %s  %s\n", rc.Serial, rc.RevocationTime.Format(time.RFC3339), rc.Reason)
+			}
+		}
+		for _, name := range []string{"sha256", "sha1", "spki-sha256"} {
+			if fp, ok := r.Fingerprints[name]; ok {
+				printField(w, "Fingerprint "+name, fp)
+			}
+		}
+	}
+	return nil
+}
+
+func printField(w io.Writer, name, value string) {
+	if value == "" {
+		return
+	}
+	fmt.Fprintf(w, "%-25s%s\n", name+":", value)
+}
+
+func printTime(w io.Writer, name string, t *time.Time) {
+	if t == nil {
+		return
+	}
+	printField(w, name, t.Format(time.RFC3339))
+}
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/n-creativesystem/self-signed-certificate/pkg/ca"
+	"github.com/pavlo-v-chernykh/keystore-go/v4"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+const (
+	storeTypeJKS    = "jks"
+	storeTypePKCS12 = "pkcs12"
+)
+
+// storeFileName は --out を指定しない場合のファイル名です。
+func storeFileName(name, storeType string) string {
+	if storeType == storeTypePKCS12 {
+		return name + ".p12"
+	}
+	return name + ".jks"
+}
+
+type keyStoreArgs struct {
+	cert          []byte
+	key           []byte
+	caCert        []byte
+	alias         string
+	storeType     string
+	storePassword string
+	keyPassword   string
+	out           io.Writer
+}
+
+func runKeyStore(args keyStoreArgs) error {
+	certificate, err := ca.ParseCertificate(args.cert)
+	if err != nil {
+		return err
+	}
+	privateKey, err := parseKeyPEM("private key", args.key)
+	if err != nil {
+		return err
+	}
+	caCerts, err := ca.ParseCertificates(args.caCert)
+	if err != nil {
+		return err
+	}
+	switch args.storeType {
+	case storeTypeJKS:
+		derKey, err := x509.MarshalPKCS8PrivateKey(privateKey)
+		if err != nil {
+			return err
+		}
+		chain := []keystore.Certificate{{Type: "X509", Content: certificate.Raw}}
+		for _, caCert := range caCerts {
+			chain = append(chain, keystore.Certificate{Type: "X509", Content: caCert.Raw})
+		}
+		ks := keystore.New(keystore.WithCaseExactAliases())
+		keyPassword := args.keyPassword
+		if keyPassword == "" {
+			keyPassword = args.storePassword
+		}
+		err = ks.SetPrivateKeyEntry(args.alias, keystore.PrivateKeyEntry{
+			CreationTime:     time.Now(),
+			PrivateKey:       derKey,
+			CertificateChain: chain,
+		}, []byte(keyPassword))
+		if err != nil {
+			return err
+		}
+		return ks.Store(args.out, []byte(args.storePassword))
+	case storeTypePKCS12:
+		pfx, err := pkcs12.Encode(rand.Reader, privateKey, certificate, caCerts, args.storePassword)
+		if err != nil {
+			return err
+		}
+		_, err = args.out.Write(pfx)
+		return err
+	default:
+		return fmt.Errorf("unsupported store type %s", args.storeType)
+	}
+}
+
+type trustStoreArgs struct {
+	caCert        []byte
+	alias         string
+	storeType     string
+	storePassword string
+	out           io.Writer
+}
+
+func runTrustStore(args trustStoreArgs) error {
+	caCerts, err := ca.ParseCertificates(args.caCert)
+	if err != nil {
+		return err
+	}
+	if len(caCerts) == 0 {
+		return fmt.Errorf("ca certificate not found")
+	}
+	aliases := make([]string, len(caCerts))
+	for i := range caCerts {
+		aliases[i] = args.alias
+		if i > 0 {
+			aliases[i] = fmt.Sprintf("%s-%d", args.alias, i+1)
+		}
+	}
+	switch args.storeType {
+	case storeTypeJKS:
+		ks := keystore.New(keystore.WithCaseExactAliases())
+		for i, caCert := range caCerts {
+			err := ks.SetTrustedCertificateEntry(aliases[i], keystore.TrustedCertificateEntry{
+				CreationTime: time.Now(),
+				Certificate:  keystore.Certificate{Type: "X509", Content: caCert.Raw},
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return ks.Store(args.out, []byte(args.storePassword))
+	case storeTypePKCS12:
+		entries := make([]pkcs12.TrustStoreEntry, 0, len(caCerts))
+		for i, caCert := range caCerts {
+			entries = append(entries, pkcs12.TrustStoreEntry{Cert: caCert, FriendlyName: aliases[i]})
+		}
+		pfx, err := pkcs12.EncodeTrustStoreEntries(rand.Reader, entries, args.storePassword)
+		if err != nil {
+			return err
+		}
+		_, err = args.out.Write(pfx)
+		return err
+	default:
+		return fmt.Errorf("unsupported store type %s", args.storeType)
+	}
+}
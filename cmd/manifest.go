@@ -0,0 +1,363 @@
+package cmd
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/n-creativesystem/self-signed-certificate/pkg/ca"
+	"github.com/spf13/viper"
+)
+
+// manifest は ssc apply で作成するCAと証明書の定義です。
+type manifest struct {
+	// Dir は出力するファイルの基準ディレクトリです(manifestファイルからの相対パス)。
+	Dir string
+	// RenewBefore は有効期限の何日前から証明書を更新するかです。
+	RenewBefore  int `mapstructure:"renewBefore"`
+	Profiles     map[string]manifestEntry
+	CAs          []manifestEntry `mapstructure:"cas"`
+	Certificates []manifestEntry
+}
+
+// manifestEntry はCAまたは証明書1つの定義です。profiles にも同じ項目を指定できます。
+type manifestEntry struct {
+	Name    string
+	Profile string
+	// Issuer は署名するCAの name です。CAで省略した場合はルートCAになります。
+	Issuer                  string
+	Type                    string
+	CommonName              string `mapstructure:"commonName"`
+	Country                 []string
+	Organization            []string
+	OrganizationUnit        []string `mapstructure:"organizationUnit"`
+	DNSNames                []string `mapstructure:"dnsNames"`
+	IPAddresses             []string `mapstructure:"ipAddresses"`
+	EmailAddresses          []string `mapstructure:"emailAddresses"`
+	URLs                    []string `mapstructure:"urls"`
+	KeyType                 string   `mapstructure:"keyType"`
+	Bits                    int
+	Days                    int
+	MaxPathLen              int      `mapstructure:"maxPathLen"`
+	PermittedDNSDomains     []string `mapstructure:"permittedDNSDomains"`
+	ExcludedDNSDomains      []string `mapstructure:"excludedDNSDomains"`
+	PermittedIPRanges       []string `mapstructure:"permittedIPRanges"`
+	ExcludedIPRanges        []string `mapstructure:"excludedIPRanges"`
+	PermittedEmailAddresses []string `mapstructure:"permittedEmailAddresses"`
+	ExcludedEmailAddresses  []string `mapstructure:"excludedEmailAddresses"`
+	PermittedURIDomains     []string `mapstructure:"permittedURIDomains"`
+	ExcludedURIDomains      []string `mapstructure:"excludedURIDomains"`
+	NameConstraintsCritical *bool    `mapstructure:"nameConstraintsCritical"`
+	Cert                    string
+	Key                     string
+	Chain                   string
+}
+
+const (
+	certificateTypeServer = "server"
+	certificateTypeClient = "client"
+	certificateTypePeer   = "peer"
+)
+
+var supportedKeyTypes = []string{ca.KeyTypeRSA, ca.KeyTypeECDSAP256, ca.KeyTypeECDSAP384, ca.KeyTypeECDSAP521, ca.KeyTypeEd25519}
+
+func loadManifest(filename string) (*manifest, error) {
+	v := viper.New()
+	v.SetConfigFile(filename)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	var m manifest
+	if err := v.Unmarshal(&m); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	if !filepath.IsAbs(m.Dir) {
+		m.Dir = filepath.Join(filepath.Dir(filename), m.Dir)
+	}
+	if m.RenewBefore == 0 {
+		m.RenewBefore = 30
+	}
+	return &m, nil
+}
+
+// resolve は profile と既定値を適用し、CAを上位のCAから順に並べた applyStep を返します。
+func (m *manifest) resolve() ([]*applyStep, error) {
+	cas := map[string]*applyStep{}
+	var pending []*applyStep
+	for _, entry := range m.CAs {
+		step, err := m.newStep(applyKindCA, entry)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := cas[step.entry.Name]; ok {
+			return nil, fmt.Errorf("ca %s is defined more than once", step.entry.Name)
+		}
+		cas[step.entry.Name] = step
+		pending = append(pending, step)
+	}
+	var steps []*applyStep
+	resolved := map[string]bool{}
+	for len(pending) > 0 {
+		var rest []*applyStep
+		for _, step := range pending {
+			switch {
+			case step.entry.Issuer == "":
+			case cas[step.entry.Issuer] == nil:
+				return nil, fmt.Errorf("ca %s: issuer %s is not defined", step.entry.Name, step.entry.Issuer)
+			case !resolved[step.entry.Issuer]:
+				rest = append(rest, step)
+				continue
+			default:
+				step.issuer = cas[step.entry.Issuer]
+			}
+			resolved[step.entry.Name] = true
+			steps = append(steps, step)
+		}
+		if len(rest) == len(pending) {
+			return nil, fmt.Errorf("ca %s: circular issuer", rest[0].entry.Name)
+		}
+		pending = rest
+	}
+	names := map[string]bool{}
+	for _, entry := range m.Certificates {
+		step, err := m.newStep(applyKindCertificate, entry)
+		if err != nil {
+			return nil, err
+		}
+		if names[step.entry.Name] {
+			return nil, fmt.Errorf("cert %s is defined more than once", step.entry.Name)
+		}
+		names[step.entry.Name] = true
+		if step.entry.Issuer == "" {
+			return nil, fmt.Errorf("cert %s: issuer is required", step.entry.Name)
+		}
+		if step.issuer = cas[step.entry.Issuer]; step.issuer == nil {
+			return nil, fmt.Errorf("cert %s: issuer %s is not defined", step.entry.Name, step.entry.Issuer)
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+func (m *manifest) newStep(kind string, entry manifestEntry) (*applyStep, error) {
+	if entry.Name == "" {
+		return nil, fmt.Errorf("%s: name is required", kind)
+	}
+	if entry.Profile != "" {
+		// viper は設定のキーを小文字で保持します
+		profile, ok := m.Profiles[strings.ToLower(entry.Profile)]
+		if !ok {
+			return nil, fmt.Errorf("%s %s: profile %s is not defined", kind, entry.Name, entry.Profile)
+		}
+		entry = entry.merge(profile)
+	}
+	entry.setDefaults(kind, m.Dir)
+	step := &applyStep{kind: kind, entry: entry}
+	if _, err := step.request(); err != nil {
+		return nil, fmt.Errorf("%s %s: %w", kind, entry.Name, err)
+	}
+	return step, nil
+}
+
+// merge は entry で指定していない項目に profile の値を設定します。
+func (entry manifestEntry) merge(profile manifestEntry) manifestEntry {
+	mergeString := func(v *string, p string) {
+		if *v == "" {
+			*v = p
+		}
+	}
+	mergeStrings := func(v *[]string, p []string) {
+		if *v == nil {
+			*v = p
+		}
+	}
+	mergeString(&entry.Issuer, profile.Issuer)
+	mergeString(&entry.Type, profile.Type)
+	mergeString(&entry.CommonName, profile.CommonName)
+	mergeStrings(&entry.Country, profile.Country)
+	mergeStrings(&entry.Organization, profile.Organization)
+	mergeStrings(&entry.OrganizationUnit, profile.OrganizationUnit)
+	mergeStrings(&entry.DNSNames, profile.DNSNames)
+	mergeStrings(&entry.IPAddresses, profile.IPAddresses)
+	mergeStrings(&entry.EmailAddresses, profile.EmailAddresses)
+	mergeStrings(&entry.URLs, profile.URLs)
+	mergeString(&entry.KeyType, profile.KeyType)
+	if entry.Bits == 0 {
+		entry.Bits = profile.Bits
+	}
+	if entry.Days == 0 {
+		entry.Days = profile.Days
+	}
+	if entry.MaxPathLen == 0 {
+		entry.MaxPathLen = profile.MaxPathLen
+	}
+	mergeStrings(&entry.PermittedDNSDomains, profile.PermittedDNSDomains)
+	mergeStrings(&entry.ExcludedDNSDomains, profile.ExcludedDNSDomains)
+	mergeStrings(&entry.PermittedIPRanges, profile.PermittedIPRanges)
+	mergeStrings(&entry.ExcludedIPRanges, profile.ExcludedIPRanges)
+	mergeStrings(&entry.PermittedEmailAddresses, profile.PermittedEmailAddresses)
+	mergeStrings(&entry.ExcludedEmailAddresses, profile.ExcludedEmailAddresses)
+	mergeStrings(&entry.PermittedURIDomains, profile.PermittedURIDomains)
+	mergeStrings(&entry.ExcludedURIDomains, profile.ExcludedURIDomains)
+	if entry.NameConstraintsCritical == nil {
+		entry.NameConstraintsCritical = profile.NameConstraintsCritical
+	}
+	return entry
+}
+
+func (entry *manifestEntry) setDefaults(kind, dir string) {
+	if entry.KeyType == "" {
+		entry.KeyType = ca.KeyTypeRSA
+	}
+	if entry.KeyType == ca.KeyTypeRSA && entry.Bits == 0 {
+		entry.Bits = 2048
+	}
+	if entry.Days == 0 {
+		entry.Days = 365
+	}
+	if entry.Country == nil {
+		entry.Country = []string{"JP"}
+	}
+	if entry.CommonName == "" {
+		entry.CommonName = entry.Name
+		if kind == applyKindCertificate && len(entry.DNSNames) > 0 {
+			entry.CommonName = entry.DNSNames[0]
+		}
+	}
+	if kind == applyKindCertificate && entry.Type == "" {
+		entry.Type = certificateTypeServer
+	}
+	if entry.NameConstraintsCritical == nil {
+		critical := true
+		entry.NameConstraintsCritical = &critical
+	}
+	if entry.Cert == "" {
+		entry.Cert = entry.Name + ".crt"
+	}
+	if entry.Key == "" {
+		entry.Key = entry.Name + ".key"
+	}
+	if kind == applyKindCA && entry.Issuer != "" && entry.Chain == "" {
+		entry.Chain = entry.Name + "-chain.crt"
+	}
+	for _, filename := range []*string{&entry.Cert, &entry.Key, &entry.Chain} {
+		if *filename != "" && !filepath.IsAbs(*filename) {
+			*filename = filepath.Join(dir, *filename)
+		}
+	}
+}
+
+func (entry *manifestEntry) subject() pkix.Name {
+	return pkix.Name{
+		CommonName:         entry.CommonName,
+		Organization:       entry.Organization,
+		OrganizationalUnit: entry.OrganizationUnit,
+		Country:            entry.Country,
+	}
+}
+
+func (entry *manifestEntry) extKeyUsage() ([]x509.ExtKeyUsage, error) {
+	switch entry.Type {
+	case certificateTypeServer:
+		return []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, nil
+	case certificateTypeClient:
+		return []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, nil
+	case certificateTypePeer:
+		return []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}, nil
+	default:
+		return nil, fmt.Errorf("unsupported certificate type %s (server, client, peer)", entry.Type)
+	}
+}
+
+func (entry *manifestEntry) nameConstraints() (ca.NameConstraints, error) {
+	nc := ca.NameConstraints{
+		Critical:                *entry.NameConstraintsCritical,
+		PermittedDNSDomains:     entry.PermittedDNSDomains,
+		ExcludedDNSDomains:      entry.ExcludedDNSDomains,
+		PermittedEmailAddresses: entry.PermittedEmailAddresses,
+		ExcludedEmailAddresses:  entry.ExcludedEmailAddresses,
+		PermittedURIDomains:     entry.PermittedURIDomains,
+		ExcludedURIDomains:      entry.ExcludedURIDomains,
+	}
+	var err error
+	if nc.PermittedIPRanges, err = parseIPRanges(entry.PermittedIPRanges); err != nil {
+		return nc, err
+	}
+	if nc.ExcludedIPRanges, err = parseIPRanges(entry.ExcludedIPRanges); err != nil {
+		return nc, err
+	}
+	return nc, nil
+}
+
+// request は定義から IssueRequest を作成します。serial number と公開鍵は設定しません。
+func (step *applyStep) request() (*ca.IssueRequest, error) {
+	entry := &step.entry
+	if !containsString(supportedKeyTypes, entry.KeyType) {
+		return nil, fmt.Errorf("unsupported key type %s", entry.KeyType)
+	}
+	req := &ca.IssueRequest{
+		Subject:  entry.subject(),
+		KeyType:  entry.KeyType,
+		Bits:     entry.Bits,
+		Validity: time.Hour * 24 * time.Duration(entry.Days),
+	}
+	if step.kind == applyKindCA {
+		if len(entry.DNSNames)+len(entry.IPAddresses)+len(entry.EmailAddresses)+len(entry.URLs) > 0 {
+			return nil, errors.New("subject alternative names can not be specified for a ca")
+		}
+		if entry.Issuer == "" && entry.MaxPathLen != 0 {
+			return nil, errors.New("maxPathLen can only be specified for an intermediate ca")
+		}
+		var err error
+		req.MaxPathLen = entry.MaxPathLen
+		req.NameConstraints, err = entry.nameConstraints()
+		return req, err
+	}
+	var err error
+	if req.ExtKeyUsage, err = entry.extKeyUsage(); err != nil {
+		return nil, err
+	}
+	req.DNSNames = entry.DNSNames
+	req.EmailAddresses = entry.EmailAddresses
+	for _, s := range entry.IPAddresses {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid ip address %s", s)
+		}
+		req.IPAddresses = append(req.IPAddresses, ip)
+	}
+	for _, raw := range entry.URLs {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, err
+		}
+		req.URIs = append(req.URIs, u)
+	}
+	return req, nil
+}
+
+func ipNetStrings(ipNets []*net.IPNet) []string {
+	var s []string
+	for _, ipNet := range ipNets {
+		s = append(s, ipNet.String())
+	}
+	return s
+}
+
+// normalizeIPs はIPアドレスを証明書から読み込んだ場合と同じ表記にします。
+func normalizeIPs(ips []string) []string {
+	var s []string
+	for _, ip := range ips {
+		if parsed := net.ParseIP(ip); parsed != nil {
+			ip = parsed.String()
+		}
+		s = append(s, ip)
+	}
+	return s
+}
@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ocsp"
+)
+
+func ocspCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "ocsp",
+		Short: "OCSPレスポンダ",
+		Long:  "CAのデータベースを元に証明書の失効状態を応答するOCSPレスポンダ",
+	}
+	cmd.AddCommand(newOCSPCommand())
+	cmd.AddCommand(serveOCSPCommand())
+	cmd.AddCommand(queryOCSPCommand())
+	return &cmd
+}
+
+var oidExtensionOCSPNoCheck = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5}
+
+type ocspResponder struct {
+	caCert        *x509.Certificate
+	responderCert *x509.Certificate
+	signer        crypto.Signer
+	database      string
+	validity      time.Duration
+}
+
+func (responder *ocspResponder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var der []byte
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		var raw string
+		raw, err = url.PathUnescape(strings.TrimPrefix(r.URL.Path, "/"))
+		if err == nil {
+			der, err = base64.StdEncoding.DecodeString(raw)
+		}
+	case http.MethodPost:
+		der, err = io.ReadAll(io.LimitReader(r.Body, 1<<16))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		writeOCSPResponse(w, ocsp.MalformedRequestErrorResponse)
+		return
+	}
+	req, err := ocsp.ParseRequest(der)
+	if err != nil {
+		writeOCSPResponse(w, ocsp.MalformedRequestErrorResponse)
+		return
+	}
+	resp, err := responder.respond(req)
+	if err != nil {
+		log.Printf("ocsp: serial %s: %v", formatSerial(req.SerialNumber), err)
+		writeOCSPResponse(w, ocsp.InternalErrorErrorResponse)
+		return
+	}
+	writeOCSPResponse(w, resp)
+}
+
+func (responder *ocspResponder) respond(req *ocsp.Request) ([]byte, error) {
+	if !responder.issuedBy(req) {
+		return ocsp.UnauthorizedErrorResponse, nil
+	}
+	db, err := loadDatabase(responder.database)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tpl := ocsp.Response{
+		Status:       ocsp.Unknown,
+		SerialNumber: req.SerialNumber,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(responder.validity),
+		Certificate:  responder.responderCert,
+	}
+	if entry := db.find(req.SerialNumber); entry != nil {
+		switch entry.status {
+		case statusRevoked:
+			tpl.Status = ocsp.Revoked
+			tpl.RevokedAt = entry.revoked
+			tpl.RevocationReason = entry.reason
+		default:
+			tpl.Status = ocsp.Good
+		}
+	}
+	log.Printf("ocsp: serial %s: %s", formatSerial(req.SerialNumber), ocspStatusName(tpl.Status))
+	responderCert := responder.responderCert
+	if responderCert == nil {
+		responderCert = responder.caCert
+	}
+	return ocsp.CreateResponse(responder.caCert, responderCert, tpl, responder.signer)
+}
+
+func (responder *ocspResponder) issuedBy(req *ocsp.Request) bool {
+	if !req.HashAlgorithm.Available() {
+		return false
+	}
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(responder.caCert.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return false
+	}
+	h := req.HashAlgorithm.New()
+	h.Write(spki.PublicKey.RightAlign())
+	keyHash := h.Sum(nil)
+	h.Reset()
+	h.Write(responder.caCert.RawSubject)
+	nameHash := h.Sum(nil)
+	return bytes.Equal(keyHash, req.IssuerKeyHash) && bytes.Equal(nameHash, req.IssuerNameHash)
+}
+
+func writeOCSPResponse(w http.ResponseWriter, resp []byte) {
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	w.Write(resp)
+}
+
+func ocspStatusName(status int) string {
+	switch status {
+	case ocsp.Good:
+		return "good"
+	case ocsp.Revoked:
+		return "revoked"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+
+	"github.com/n-creativesystem/self-signed-certificate/pkg/ca"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func newOCSPCommand() *cobra.Command {
+	initialize := initialize("ocsp_config")
+	cmd := cobra.Command{
+		Use:   "new",
+		Short: "OCSP署名用証明書作成(cert,key)",
+		Long:  "CAから委任されたOCSPレスポンス署名用の証明書(ExtKeyUsage: OCSPSigning)を作成します",
+		Run: func(cmd *cobra.Command, args []string) {
+			var err error
+			config, err := cmd.Flags().GetString("config")
+			if err != nil {
+				errorExit(err)
+			}
+			initialize(cmd, config)
+			var srvArg serverArgs = parseServerArgs()
+			srvArg.extKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning}
+			srvArg.keyEncryption = parseKeyEncryption()
+			srvArg.extraExtensions = setExtension(srvArg.extraExtensions, pkix.Extension{Id: oidExtensionOCSPNoCheck, Value: []byte{0x05, 0x00}})
+			certFilename := viper.GetString("cert")
+			srvArg.certFilename = certFilename
+			keyFilename := viper.GetString("key")
+			srvArg.cert = &bytes.Buffer{}
+			srvArg.key = &bytes.Buffer{}
+			if err := runServerCertificate(srvArg); err != nil {
+				errorExit(err)
+			}
+			fileCreate(certFilename, srvArg.cert)
+			fileCreate(keyFilename, srvArg.key)
+			if err := srvArg.db.save(); err != nil {
+				errorExit(err)
+			}
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.String("config", "", "ocsp configuration")
+	flags.Int("serialNumber", 0, "serial number (0: allocate from the certificate database)")
+	flags.String("serialType", serialTypeSequential, "serial number allocation (sequential, random)")
+	flags.String("database", "", "certificate database file name (default: <ca cert name>.index.txt)")
+	flags.Int("bits", 2048, "rsa bits")
+	flags.String("keyType", ca.KeyTypeRSA, "key type (rsa, ecdsa-p256, ecdsa-p384, ecdsa-p521, ed25519)")
+	flags.StringSlice("country", []string{"JP"}, "country")
+	flags.StringSlice("organization", nil, "organization")
+	flags.StringSlice("organizationUnit", nil, "organization unit")
+	flags.String("commonName", "OCSP Responder", "common name")
+	flags.Int("days", 30, "days")
+	flags.String("caCert", "ca.crt", "ca cert file name")
+	flags.String("caKey", "ca.key", "ca private key file name")
+	flags.String("caKeyURI", "", "ca private key pkcs11 uri (e.g. pkcs11:token=ca;object=ca-key?module-path=/usr/lib/softhsm/libsofthsm2.so)")
+	flags.String("cert", "ocsp.crt", "ocsp responder cert file name")
+	flags.String("key", "ocsp.key", "ocsp responder private key file name")
+	addKeyEncryptionFlags(flags)
+	addProfileFlag(flags)
+	addExtensionFlag(flags)
+	return &cmd
+}
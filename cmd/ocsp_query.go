@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/n-creativesystem/self-signed-certificate/pkg/ca"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ocsp"
+)
+
+func queryOCSPCommand() *cobra.Command {
+	initialize := initialize("ocsp_config")
+	cmd := cobra.Command{
+		Use:   "query <cert file>",
+		Short: "OCSPによる失効状態の確認",
+		Long:  "OCSPレスポンダに問い合わせて証明書の失効状態を表示します。good 以外の場合は終了コード1で終了します",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := cmd.Flags().GetString("config")
+			if err != nil {
+				errorExit(err)
+			}
+			initialize(cmd, config)
+			var queryArg ocspQueryArgs
+			queryArg.caCert, err = os.ReadFile(viper.GetString("caCert"))
+			if err != nil {
+				errorExit(err)
+			}
+			queryArg.cert, err = os.ReadFile(args[0])
+			if err != nil {
+				errorExit(err)
+			}
+			queryArg.url = viper.GetString("url")
+			if err := runOCSPQuery(os.Stdout, queryArg); err != nil {
+				errorExit(err)
+			}
+		},
+	}
+	flags := cmd.Flags()
+	flags.String("config", "", "ocsp configuration")
+	flags.String("caCert", "ca.crt", "issuer ca cert file name")
+	flags.String("url", "http://localhost:8080", "ocsp responder url")
+	return &cmd
+}
+
+type ocspQueryArgs struct {
+	caCert []byte
+	cert   []byte
+	url    string
+}
+
+func runOCSPQuery(w io.Writer, args ocspQueryArgs) error {
+	issuer, err := ca.ParseCertificate(args.caCert)
+	if err != nil {
+		return err
+	}
+	cert, err := ca.ParseCertificate(args.cert)
+	if err != nil {
+		return err
+	}
+	req, err := ocsp.CreateRequest(cert, issuer, &ocsp.RequestOptions{Hash: crypto.SHA256})
+	if err != nil {
+		return err
+	}
+	client := http.Client{Timeout: 10 * time.Second}
+	httpResp, err := client.Post(args.url, "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+	body, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return err
+	}
+	resp, err := ocsp.ParseResponseForCert(body, cert, issuer)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Serial:      %s\n", formatSerial(resp.SerialNumber))
+	fmt.Fprintf(w, "Status:      %s\n", ocspStatusName(resp.Status))
+	fmt.Fprintf(w, "This Update: %s\n", resp.ThisUpdate.Format(time.RFC3339))
+	fmt.Fprintf(w, "Next Update: %s\n", resp.NextUpdate.Format(time.RFC3339))
+	if resp.Status == ocsp.Revoked {
+		fmt.Fprintf(w, "Revoked At:  %s\n", resp.RevokedAt.Format(time.RFC3339))
+		reason := strconv.Itoa(resp.RevocationReason)
+		if resp.RevocationReason >= 0 && resp.RevocationReason < len(revocationReasons) && revocationReasons[resp.RevocationReason] != "" {
+			reason = revocationReasons[resp.RevocationReason]
+		}
+		fmt.Fprintf(w, "Reason:      %s\n", reason)
+	}
+	if resp.Status != ocsp.Good {
+		return fmt.Errorf("certificate status is %s", ocspStatusName(resp.Status))
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/n-creativesystem/self-signed-certificate/pkg/ca"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func serveOCSPCommand() *cobra.Command {
+	initialize := initialize("ocsp_config")
+	cmd := cobra.Command{
+		Use:   "serve",
+		Short: "OCSPレスポンダ起動",
+		Long:  "CAのデータベースの発行、失効情報を元に応答するOCSPレスポンダをHTTPで起動します。--responderCert を指定しない場合はCAの秘密鍵で署名します",
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := cmd.Flags().GetString("config")
+			if err != nil {
+				errorExit(err)
+			}
+			initialize(cmd, config)
+			caCertFilename := viper.GetString("caCert")
+			var responder ocspResponder
+			if responderCertFilename := viper.GetString("responderCert"); responderCertFilename == "" {
+				caCert, caKey, err := readCERTandKEY(caCertFilename, viper.GetString("caKey"), viper.GetString("caKeyURI"))
+				if err != nil {
+					errorExit(err)
+				}
+				responder.caCert, err = ca.ParseCertificate(caCert)
+				if err != nil {
+					errorExit(err)
+				}
+				responder.signer = caKey
+			} else {
+				caCert, err := os.ReadFile(caCertFilename)
+				if err != nil {
+					errorExit(err)
+				}
+				responder.caCert, err = ca.ParseCertificate(caCert)
+				if err != nil {
+					errorExit(err)
+				}
+				cert, key, err := readCERTandKEY(responderCertFilename, viper.GetString("responderKey"), "")
+				if err != nil {
+					errorExit(err)
+				}
+				responder.responderCert, err = ca.ParseCertificate(cert)
+				if err != nil {
+					errorExit(err)
+				}
+				if err := responder.responderCert.CheckSignatureFrom(responder.caCert); err != nil {
+					errorExit(err)
+				}
+				responder.signer = key
+			}
+			responder.database = databasePath(caCertFilename, viper.GetString("database"))
+			responder.validity = viper.GetDuration("validity")
+			listen := viper.GetString("listen")
+			log.Printf("ocsp: listening on %s", listen)
+			if err := http.ListenAndServe(listen, &responder); err != nil {
+				errorExit(err)
+			}
+		},
+	}
+	flags := cmd.Flags()
+	flags.String("config", "", "ocsp configuration")
+	flags.String("caCert", "ca.crt", "ca cert file name")
+	flags.String("caKey", "ca.key", "ca private key file name (used when --responderCert is not set)")
+	flags.String("caKeyURI", "", "ca private key pkcs11 uri (e.g. pkcs11:token=ca;object=ca-key?module-path=/usr/lib/softhsm/libsofthsm2.so)")
+	flags.String("responderCert", "", "delegated ocsp responder cert file name")
+	flags.String("responderKey", "ocsp.key", "delegated ocsp responder private key file name")
+	flags.String("database", "", "certificate database file name (default: <ca cert name>.index.txt)")
+	flags.Duration("validity", time.Hour, "ocsp response validity (next update)")
+	flags.String("listen", ":8080", "listen address")
+	return &cmd
+}
@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/n-creativesystem/self-signed-certificate/pkg/ca"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"golang.org/x/term"
+)
+
+// keyEncryption は作成した秘密鍵をファイルに書き出す際の暗号化の設定です。
+type keyEncryption struct {
+	encrypt        bool
+	kdf            string
+	passphraseFile string
+	// passphrase は読み込み済みのパスフレーズです。nil の場合は marshal のたびに取得します。
+	passphrase []byte
+}
+
+func addKeyEncryptionFlags(flags *pflag.FlagSet) {
+	flags.Bool("encryptKey", false, "encrypt the private key (pkcs8, aes-256-cbc)")
+	flags.String("kdf", ca.KDFScrypt, "key derivation function of the encrypted private key (scrypt, pbkdf2)")
+	flags.String("newPassphraseFile", "", "file containing the passphrase for the new private key (default: $SELF_CERT_NEW_PASSPHRASE or prompt)")
+}
+
+func parseKeyEncryption() keyEncryption {
+	return keyEncryption{
+		encrypt:        viper.GetBool("encryptKey"),
+		kdf:            viper.GetString("kdf"),
+		passphraseFile: viper.GetString("newPassphraseFile"),
+	}
+}
+
+func (e keyEncryption) marshal(key crypto.Signer) ([]byte, error) {
+	if !e.encrypt {
+		block, err := ca.MarshalPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(block), nil
+	}
+	passphrase := e.passphrase
+	if passphrase == nil {
+		var err error
+		if passphrase, err = e.readPassphrase(); err != nil {
+			return nil, err
+		}
+	}
+	block, err := ca.EncryptPrivateKey(key, passphrase, e.kdf)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+func (e keyEncryption) readPassphrase() ([]byte, error) {
+	return readPassphrase(e.passphraseFile, envPrefix+"_NEW_PASSPHRASE", "Enter passphrase for the new private key: ", true)
+}
+
+// parseKeyPEM はPEM形式の秘密鍵を読み込みます。暗号化されている場合はパスフレーズを取得して復号します。
+func parseKeyPEM(name string, data []byte) (crypto.Signer, error) {
+	key, err := ca.ParsePrivateKeyPEM(data)
+	if !errors.Is(err, ca.ErrEncryptedPrivateKey) {
+		return key, err
+	}
+	passphrase, err := readPassphrase(viper.GetString("passphraseFile"), envPrefix+"_PASSPHRASE", fmt.Sprintf("Enter passphrase for %s: ", name), false)
+	if err != nil {
+		return nil, err
+	}
+	return ca.ParseEncryptedPrivateKeyPEM(data, passphrase)
+}
+
+// readPassphrase はファイル、環境変数、端末からの入力の順にパスフレーズを取得します。
+func readPassphrase(filename, env, prompt string, confirm bool) ([]byte, error) {
+	if filename != "" {
+		buf, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		if i := bytes.IndexAny(buf, "\r\n"); i >= 0 {
+			buf = buf[:i]
+		}
+		if len(buf) == 0 {
+			return nil, fmt.Errorf("%s: empty passphrase", filename)
+		}
+		return buf, nil
+	}
+	if passphrase := os.Getenv(env); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, fmt.Errorf("passphrase is required (set %s or specify the passphrase file)", env)
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	if len(passphrase) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+	if confirm {
+		fmt.Fprint(os.Stderr, "Verifying - "+prompt)
+		again, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(passphrase, again) {
+			return nil, errors.New("passphrases do not match")
+		}
+	}
+	return passphrase, nil
+}
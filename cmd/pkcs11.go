@@ -0,0 +1,94 @@
+//go:build pkcs11
+// +build pkcs11
+
+package cmd
+
+import (
+	"crypto"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+
+	"github.com/ThalesIgnite/crypto11"
+	"github.com/n-creativesystem/self-signed-certificate/pkg/ca"
+)
+
+func openPKCS11(uri *pkcs11URI) (*crypto11.Context, error) {
+	config := &crypto11.Config{
+		Path:        uri.modulePath,
+		TokenLabel:  uri.token,
+		TokenSerial: uri.serial,
+		SlotNumber:  uri.slot,
+	}
+	if config.TokenLabel == "" && config.TokenSerial == "" && config.SlotNumber == nil {
+		return nil, fmt.Errorf("pkcs11 uri requires token, serial or slot-id")
+	}
+	pin, err := uri.pin()
+	if err != nil {
+		return nil, err
+	}
+	config.Pin = pin
+	return crypto11.Configure(config)
+}
+
+func pkcs11KeyLabel(uri *pkcs11URI) []byte {
+	if uri.object == "" {
+		return nil
+	}
+	return []byte(uri.object)
+}
+
+// loadPKCS11Key はPKCS#11トークンの秘密鍵を読み込みます。
+// トークンとのセッションは署名に使用するため、プロセスの終了まで閉じません。
+func loadPKCS11Key(uri *pkcs11URI) (crypto.Signer, error) {
+	ctx, err := openPKCS11(uri)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ctx.FindKeyPair(uri.id, pkcs11KeyLabel(uri))
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, fmt.Errorf("pkcs11 key not found (object=%s, id=%x)", uri.object, uri.id)
+	}
+	return key, nil
+}
+
+// generatePKCS11Key はPKCS#11トークン内に秘密鍵を生成します。秘密鍵はトークンの外には出ません。
+func generatePKCS11Key(uri *pkcs11URI, keyType string, bits int) (crypto.Signer, error) {
+	ctx, err := openPKCS11(uri)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := ctx.FindKeyPair(uri.id, pkcs11KeyLabel(uri))
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("pkcs11 key already exists (object=%s, id=%x)", uri.object, uri.id)
+	}
+	id := uri.id
+	if len(id) == 0 {
+		id = make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return nil, err
+		}
+	}
+	label := pkcs11KeyLabel(uri)
+	if label == nil {
+		label = id
+	}
+	switch keyType {
+	case ca.KeyTypeRSA, "":
+		return ctx.GenerateRSAKeyPairWithLabel(id, label, bits)
+	case ca.KeyTypeECDSAP256:
+		return ctx.GenerateECDSAKeyPairWithLabel(id, label, elliptic.P256())
+	case ca.KeyTypeECDSAP384:
+		return ctx.GenerateECDSAKeyPairWithLabel(id, label, elliptic.P384())
+	case ca.KeyTypeECDSAP521:
+		return ctx.GenerateECDSAKeyPairWithLabel(id, label, elliptic.P521())
+	default:
+		return nil, fmt.Errorf("unsupported pkcs11 key type %s", keyType)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !pkcs11
+// +build !pkcs11
+
+package cmd
+
+import (
+	"crypto"
+	"errors"
+)
+
+var errPKCS11NotSupported = errors.New("pkcs11 is not supported (build with -tags pkcs11)")
+
+func loadPKCS11Key(uri *pkcs11URI) (crypto.Signer, error) {
+	return nil, errPKCS11NotSupported
+}
+
+func generatePKCS11Key(uri *pkcs11URI, keyType string, bits int) (crypto.Signer, error) {
+	return nil, errPKCS11NotSupported
+}
@@ -0,0 +1,91 @@
+//go:build pkcs11
+// +build pkcs11
+
+package cmd
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"os"
+	"testing"
+
+	"github.com/n-creativesystem/self-signed-certificate/pkg/ca"
+)
+
+// TestSoftHSM はSoftHSMのトークンに鍵を生成し、CA証明書の作成と証明書の発行を行います。
+// SOFTHSM2_CONF を設定していない場合はスキップします。トークンは事前に作成してください。
+//
+//	softhsm2-util --init-token --free --label ca --so-pin 0000 --pin 1234
+//	SOFTHSM2_CONF=... SELF_CERT_PKCS11_MODULE=/usr/lib/softhsm/libsofthsm2.so SELF_CERT_PKCS11_PIN=1234 go test -tags pkcs11 -run SoftHSM ./cmd
+func TestSoftHSM(t *testing.T) {
+	if os.Getenv("SOFTHSM2_CONF") == "" {
+		t.Skip("SOFTHSM2_CONF is not set")
+	}
+	if os.Getenv(envPrefix+"_PKCS11_MODULE") == "" {
+		t.Setenv(envPrefix+"_PKCS11_MODULE", "/usr/lib/softhsm/libsofthsm2.so")
+	}
+	token := os.Getenv(envPrefix + "_TEST_PKCS11_TOKEN")
+	if token == "" {
+		token = "ca"
+	}
+	// 実行ごとに別の鍵を生成します
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, keyType := range []string{ca.KeyTypeRSA, ca.KeyTypeECDSAP256} {
+		t.Run(keyType, func(t *testing.T) {
+			keyURI := "pkcs11:token=" + token + ";object=ssc-test-" + keyType + "-" + hex.EncodeToString(suffix)
+			uri, err := parsePKCS11URI(keyURI)
+			if err != nil {
+				t.Fatal(err)
+			}
+			signer, err := generatePKCS11Key(uri, keyType, 2048)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := generatePKCS11Key(uri, keyType, 2048); err == nil {
+				t.Error("existing key must be an error")
+			}
+			root, err := ca.NewWithSigner(&ca.IssueRequest{Subject: pkix.Name{CommonName: "SoftHSM CA"}}, signer)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// 読み込んだ鍵で発行した証明書をCA証明書で検証できること
+			loaded, err := readCAKey("", keyURI)
+			if err != nil {
+				t.Fatal(err)
+			}
+			authority, err := ca.Load(root.CertificatePEM(), loaded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			leaf, err := authority.Issue(&ca.IssueRequest{
+				Subject:     pkix.Name{CommonName: "app.internal"},
+				KeyType:     ca.KeyTypeECDSAP256,
+				DNSNames:    []string{"app.internal"},
+				ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			roots := x509.NewCertPool()
+			roots.AddCert(root.Certificate)
+			if _, err := leaf.Certificate.Verify(x509.VerifyOptions{Roots: roots, DNSName: "app.internal"}); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+
+	uri, err := parsePKCS11URI("pkcs11:token=" + token + ";object=ssc-test-missing-" + hex.EncodeToString(suffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadPKCS11Key(uri); err == nil {
+		t.Error("missing key must be an error")
+	}
+}
@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// pkcs11URI はPKCS#11 URI(RFC 7512)のうち、トークンと鍵の選択に使用する属性です。
+type pkcs11URI struct {
+	token      string
+	serial     string
+	slot       *int
+	object     string
+	id         []byte
+	modulePath string
+	pinValue   string
+	pinSource  string
+}
+
+func parsePKCS11URI(s string) (*pkcs11URI, error) {
+	if !strings.HasPrefix(s, "pkcs11:") {
+		return nil, fmt.Errorf("invalid pkcs11 uri %s", s)
+	}
+	path := strings.TrimPrefix(s, "pkcs11:")
+	var query string
+	if i := strings.Index(path, "?"); i >= 0 {
+		path, query = path[:i], path[i+1:]
+	}
+	uri := &pkcs11URI{}
+	for _, attr := range splitPKCS11Attributes(path, ";") {
+		name, value, err := parsePKCS11Attribute(attr)
+		if err != nil {
+			return nil, err
+		}
+		switch name {
+		case "token":
+			uri.token = value
+		case "serial":
+			uri.serial = value
+		case "slot-id":
+			slot, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pkcs11 slot-id %s", value)
+			}
+			uri.slot = &slot
+		case "object":
+			uri.object = value
+		case "id":
+			uri.id = []byte(value)
+		case "type":
+			if value != "private" {
+				return nil, fmt.Errorf("pkcs11 uri type must be private: %s", value)
+			}
+		}
+	}
+	for _, attr := range splitPKCS11Attributes(query, "&") {
+		name, value, err := parsePKCS11Attribute(attr)
+		if err != nil {
+			return nil, err
+		}
+		switch name {
+		case "module-path":
+			uri.modulePath = value
+		case "pin-value":
+			uri.pinValue = value
+		case "pin-source":
+			uri.pinSource = strings.TrimPrefix(value, "file:")
+		}
+	}
+	if uri.modulePath == "" {
+		uri.modulePath = os.Getenv(envPrefix + "_PKCS11_MODULE")
+	}
+	if uri.modulePath == "" {
+		return nil, fmt.Errorf("pkcs11 module is required (module-path or %s_PKCS11_MODULE)", envPrefix)
+	}
+	if uri.object == "" && len(uri.id) == 0 {
+		return nil, errors.New("pkcs11 uri requires object or id")
+	}
+	return uri, nil
+}
+
+func splitPKCS11Attributes(s, sep string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, sep)
+}
+
+func parsePKCS11Attribute(attr string) (string, string, error) {
+	kv := strings.SplitN(attr, "=", 2)
+	if len(kv) != 2 {
+		return "", "", fmt.Errorf("invalid pkcs11 uri attribute %s", attr)
+	}
+	value, err := url.PathUnescape(kv[1])
+	if err != nil {
+		return "", "", fmt.Errorf("invalid pkcs11 uri attribute %s: %w", attr, err)
+	}
+	return kv[0], value, nil
+}
+
+// pin は pin-value、pin-source のファイル、環境変数、端末からの入力の順にPINを取得します。
+func (uri *pkcs11URI) pin() (string, error) {
+	if uri.pinValue != "" {
+		return uri.pinValue, nil
+	}
+	name := uri.token
+	if name == "" {
+		name = "token"
+	}
+	pin, err := readPassphrase(uri.pinSource, envPrefix+"_PKCS11_PIN", fmt.Sprintf("Enter PIN for %s: ", name), false)
+	if err != nil {
+		return "", err
+	}
+	return string(pin), nil
+}
+
+// readCAKey は keyURI を指定した場合はPKCS#11トークンから、指定しない場合は keyFile からCAの秘密鍵を読み込みます。
+func readCAKey(keyFile, keyURI string) (crypto.Signer, error) {
+	if keyURI == "" {
+		return readKeyFile(keyFile)
+	}
+	uri, err := parsePKCS11URI(keyURI)
+	if err != nil {
+		return nil, err
+	}
+	return loadPKCS11Key(uri)
+}
@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParsePKCS11URI(t *testing.T) {
+	t.Setenv(envPrefix+"_PKCS11_MODULE", "")
+	slot := func(n int) *int { return &n }
+	tests := []struct {
+		uri  string
+		want pkcs11URI
+	}{
+		{
+			uri:  "pkcs11:token=ca;object=root-ca?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-source=file:/run/secrets/pin",
+			want: pkcs11URI{token: "ca", object: "root-ca", modulePath: "/usr/lib/softhsm/libsofthsm2.so", pinSource: "/run/secrets/pin"},
+		},
+		{
+			uri:  "pkcs11:token=My%20Token;id=%01%02%ab;type=private?module-path=/lib/p11.so&pin-value=1234",
+			want: pkcs11URI{token: "My Token", id: []byte{0x01, 0x02, 0xab}, modulePath: "/lib/p11.so", pinValue: "1234"},
+		},
+		{
+			uri:  "pkcs11:slot-id=3;object=a%3Bb%3Dc;serial=0123abcd?module-path=%2Fopt%2Fhsm%2Flib.so&pin-source=/etc/pin",
+			want: pkcs11URI{slot: slot(3), serial: "0123abcd", object: "a;b=c", modulePath: "/opt/hsm/lib.so", pinSource: "/etc/pin"},
+		},
+		{
+			// 対応していない属性は無視します
+			uri:  "pkcs11:manufacturer=SoftHSM;token=ca;object=k?module-name=softhsm2&module-path=/lib/p11.so",
+			want: pkcs11URI{token: "ca", object: "k", modulePath: "/lib/p11.so"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			got, err := parsePKCS11URI(tt.uri)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.token != tt.want.token || got.serial != tt.want.serial || got.object != tt.want.object ||
+				!bytes.Equal(got.id, tt.want.id) || got.modulePath != tt.want.modulePath ||
+				got.pinValue != tt.want.pinValue || got.pinSource != tt.want.pinSource {
+				t.Errorf("got %+v, want %+v", *got, tt.want)
+			}
+			if (got.slot == nil) != (tt.want.slot == nil) || (got.slot != nil && *got.slot != *tt.want.slot) {
+				t.Errorf("slot: got %v, want %v", got.slot, tt.want.slot)
+			}
+		})
+	}
+}
+
+func TestParsePKCS11URIModuleEnv(t *testing.T) {
+	t.Setenv(envPrefix+"_PKCS11_MODULE", "/env/p11.so")
+	uri, err := parsePKCS11URI("pkcs11:token=ca;object=root-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uri.modulePath != "/env/p11.so" {
+		t.Errorf("module path: %s", uri.modulePath)
+	}
+	// module-path は環境変数より優先します
+	uri, err = parsePKCS11URI("pkcs11:token=ca;object=root-ca?module-path=/uri/p11.so")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uri.modulePath != "/uri/p11.so" {
+		t.Errorf("module path: %s", uri.modulePath)
+	}
+}
+
+func TestParsePKCS11URIError(t *testing.T) {
+	t.Setenv(envPrefix+"_PKCS11_MODULE", "")
+	tests := []struct {
+		uri string
+		err string
+	}{
+		{uri: "token=ca;object=k?module-path=/lib/p11.so", err: "invalid pkcs11 uri"},
+		{uri: "pkcs11:token=ca;object=k", err: "pkcs11 module is required"},
+		{uri: "pkcs11:token=ca?module-path=/lib/p11.so", err: "requires object or id"},
+		{uri: "pkcs11:slot-id=x;object=k?module-path=/lib/p11.so", err: "invalid pkcs11 slot-id"},
+		{uri: "pkcs11:token=ca;object=k;type=public?module-path=/lib/p11.so", err: "type must be private"},
+		{uri: "pkcs11:token;object=k?module-path=/lib/p11.so", err: "invalid pkcs11 uri attribute token"},
+		{uri: "pkcs11:token=ca;id=%zz?module-path=/lib/p11.so", err: "invalid pkcs11 uri attribute id=%zz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			_, err := parsePKCS11URI(tt.uri)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got %v, want error containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestPKCS11URIPin(t *testing.T) {
+	t.Setenv(envPrefix+"_PKCS11_PIN", "env-pin")
+	pinFile := filepath.Join(t.TempDir(), "pin")
+	if err := os.WriteFile(pinFile, []byte("file-pin\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		uri  pkcs11URI
+		want string
+	}{
+		{uri: pkcs11URI{pinValue: "uri-pin", pinSource: pinFile}, want: "uri-pin"},
+		{uri: pkcs11URI{pinSource: pinFile}, want: "file-pin"},
+		{uri: pkcs11URI{}, want: "env-pin"},
+	}
+	for _, tt := range tests {
+		got, err := tt.uri.pin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("got %s, want %s", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+
+	"github.com/n-creativesystem/self-signed-certificate/pkg/ca"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+const (
+	formatPEM    = "pem"
+	formatPKCS12 = "pkcs12"
+)
+
+func checkOutputFormat(format string) error {
+	switch format {
+	case formatPEM, formatPKCS12:
+		return nil
+	default:
+		return fmt.Errorf("unsupported output format %s", format)
+	}
+}
+
+func writePKCS12(w io.Writer, cert, key io.Reader, caCert []byte, password string) error {
+	certPEM, err := io.ReadAll(cert)
+	if err != nil {
+		return err
+	}
+	keyPEM, err := io.ReadAll(key)
+	if err != nil {
+		return err
+	}
+	certificate, err := ca.ParseCertificate(certPEM)
+	if err != nil {
+		return err
+	}
+	privateKey, err := parseKeyPEM("private key", keyPEM)
+	if err != nil {
+		return err
+	}
+	caCerts, err := ca.ParseCertificates(caCert)
+	if err != nil {
+		return err
+	}
+	pfx, err := pkcs12.Encode(rand.Reader, privateKey, certificate, caCerts, password)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(pfx)
+	return err
+}
@@ -0,0 +1,210 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/n-creativesystem/self-signed-certificate/pkg/ca"
+	"github.com/spf13/viper"
+)
+
+const (
+	sanModeMerge = "merge"
+	sanModeCSR   = "csr"
+	sanModeFlags = "flags"
+)
+
+// signingPolicy はCSRに署名する際の制限です。設定ファイルの policy で指定します。
+// 指定しない項目は制限しません。
+type signingPolicy struct {
+	allowedDomains  []string
+	allowedIPRanges []*net.IPNet
+	maxDays         int
+	allowedKeyTypes []string
+	minRSABits      int
+}
+
+func parseSigningPolicy() (*signingPolicy, error) {
+	if !viper.IsSet("policy") {
+		return nil, nil
+	}
+	policy := &signingPolicy{
+		maxDays:         viper.GetInt("policy.maxDays"),
+		allowedKeyTypes: viper.GetStringSlice("policy.allowedKeyTypes"),
+		minRSABits:      viper.GetInt("policy.minRSABits"),
+	}
+	for _, domain := range viper.GetStringSlice("policy.allowedDomains") {
+		policy.allowedDomains = append(policy.allowedDomains, strings.ToLower(strings.TrimPrefix(domain, ".")))
+	}
+	for _, cidr := range viper.GetStringSlice("policy.allowedIPRanges") {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("policy: %w", err)
+		}
+		policy.allowedIPRanges = append(policy.allowedIPRanges, ipNet)
+	}
+	return policy, nil
+}
+
+// policyError はポリシーに違反した場合のエラーです。
+type policyError struct {
+	message string
+}
+
+func (e *policyError) Error() string {
+	return "policy: " + e.message
+}
+
+func newPolicyError(format string, a ...interface{}) error {
+	return &policyError{message: fmt.Sprintf(format, a...)}
+}
+
+// check は req がポリシーに合うか確認します。PublicKey が nil の場合は KeyType と Bits で生成する鍵を確認します。
+func (policy *signingPolicy) check(req *ca.IssueRequest) error {
+	if len(policy.allowedDomains) > 0 {
+		if cn := req.Subject.CommonName; cn != "" {
+			if ip := net.ParseIP(cn); ip != nil {
+				if len(policy.allowedIPRanges) > 0 && !policy.allowedIP(ip) {
+					return newPolicyError("common name %s is not allowed", cn)
+				}
+			} else if !policy.allowedDomain(cn) {
+				return newPolicyError("common name %s is not allowed", cn)
+			}
+		}
+		for _, name := range req.DNSNames {
+			if !policy.allowedDomain(name) {
+				return newPolicyError("dns name %s is not allowed", name)
+			}
+		}
+		for _, email := range req.EmailAddresses {
+			if i := strings.LastIndex(email, "@"); i < 0 || !policy.allowedDomain(email[i+1:]) {
+				return newPolicyError("email address %s is not allowed", email)
+			}
+		}
+		for _, u := range req.URIs {
+			if !policy.allowedDomain(u.Hostname()) {
+				return newPolicyError("uri %s is not allowed", u)
+			}
+		}
+	}
+	if len(policy.allowedIPRanges) > 0 {
+		for _, ip := range req.IPAddresses {
+			if !policy.allowedIP(ip) {
+				return newPolicyError("ip address %s is not allowed", ip)
+			}
+		}
+	}
+	if policy.maxDays > 0 && req.Validity > time.Hour*24*time.Duration(policy.maxDays) {
+		return newPolicyError("validity must be %d days or less", policy.maxDays)
+	}
+	keyType, bits := req.KeyType, req.Bits
+	if req.PublicKey != nil {
+		var err error
+		keyType, bits, err = ca.KeyTypeOf(req.PublicKey)
+		if err != nil {
+			return newPolicyError("%v", err)
+		}
+	}
+	if keyType == "" {
+		keyType = ca.KeyTypeRSA
+	}
+	if keyType == ca.KeyTypeRSA && bits == 0 {
+		bits = 2048
+	}
+	if len(policy.allowedKeyTypes) > 0 && !containsString(policy.allowedKeyTypes, keyType) {
+		return newPolicyError("key type %s is not allowed", keyType)
+	}
+	if keyType == ca.KeyTypeRSA && bits < policy.minRSABits {
+		return newPolicyError("rsa key must be %d bits or more", policy.minRSABits)
+	}
+	return nil
+}
+
+// allowedDomain は name が許可されたドメインと一致するか、そのサブドメインの場合に true を返します。
+func (policy *signingPolicy) allowedDomain(name string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name == "" {
+		return false
+	}
+	for _, domain := range policy.allowedDomains {
+		if name == domain || strings.HasSuffix(name, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func (policy *signingPolicy) allowedIP(ip net.IP) bool {
+	for _, ipNet := range policy.allowedIPRanges {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeSANs は CSR のSANとフラグで指定したSANを sanMode に従って設定します。
+func mergeSANs(req *ca.IssueRequest, args serverArgs) error {
+	switch args.sanMode {
+	case sanModeMerge, "":
+		for _, name := range args.dnsNames {
+			if !containsString(req.DNSNames, name) {
+				req.DNSNames = append(req.DNSNames, name)
+			}
+		}
+		for _, ip := range args.ipAddresses {
+			if !containsIP(req.IPAddresses, ip) {
+				req.IPAddresses = append(req.IPAddresses, ip)
+			}
+		}
+		for _, email := range args.emails {
+			if !containsString(req.EmailAddresses, email) {
+				req.EmailAddresses = append(req.EmailAddresses, email)
+			}
+		}
+		for _, u := range args.urls {
+			if !containsURL(req.URIs, u.String()) {
+				req.URIs = append(req.URIs, u)
+			}
+		}
+	case sanModeCSR:
+	case sanModeFlags:
+		req.DNSNames = args.dnsNames
+		req.IPAddresses = args.ipAddresses
+		req.EmailAddresses = args.emails
+		req.URIs = args.urls
+	default:
+		return fmt.Errorf("invalid san mode %s", args.sanMode)
+	}
+	return nil
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, v := range ips {
+		if v.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsURL(urls []*url.URL, s string) bool {
+	for _, u := range urls {
+		if u.String() == s {
+			return true
+		}
+	}
+	return false
+}
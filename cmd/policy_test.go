@@ -0,0 +1,280 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/n-creativesystem/self-signed-certificate/pkg/ca"
+)
+
+func newTestPolicy(t *testing.T, domains []string, cidrs []string) *signingPolicy {
+	t.Helper()
+	policy := &signingPolicy{allowedDomains: domains}
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		policy.allowedIPRanges = append(policy.allowedIPRanges, ipNet)
+	}
+	return policy
+}
+
+func mustParseURL(t *testing.T, s string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestSigningPolicyCheck(t *testing.T) {
+	names := newTestPolicy(t, []string{"example.test"}, []string{"10.0.0.0/8"})
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		policy *signingPolicy
+		req    *ca.IssueRequest
+		err    string
+	}{
+		{name: "domain", policy: names, req: &ca.IssueRequest{DNSNames: []string{"example.test", "App.Example.Test."}}},
+		{name: "domain not allowed", policy: names, req: &ca.IssueRequest{DNSNames: []string{"app.example.test", "example.test.evil"}}, err: "dns name example.test.evil"},
+		{name: "domain suffix without dot", policy: names, req: &ca.IssueRequest{DNSNames: []string{"badexample.test"}}, err: "dns name badexample.test"},
+		{name: "ip", policy: names, req: &ca.IssueRequest{IPAddresses: []net.IP{net.ParseIP("10.1.2.3")}}},
+		{name: "ip not allowed", policy: names, req: &ca.IssueRequest{IPAddresses: []net.IP{net.ParseIP("192.0.2.1")}}, err: "ip address 192.0.2.1"},
+		{name: "email", policy: names, req: &ca.IssueRequest{EmailAddresses: []string{"ops@mail.example.test"}}},
+		{name: "email not allowed", policy: names, req: &ca.IssueRequest{EmailAddresses: []string{"ceo@example.com"}}, err: "email address ceo@example.com"},
+		{name: "uri", policy: names, req: &ca.IssueRequest{URIs: []*url.URL{mustParseURL(t, "https://api.example.test/v1")}}},
+		{name: "uri not allowed", policy: names, req: &ca.IssueRequest{URIs: []*url.URL{mustParseURL(t, "spiffe://prod/ns/payments/sa/admin")}}, err: "uri spiffe://prod"},
+		{name: "common name", policy: names, req: &ca.IssueRequest{Subject: pkix.Name{CommonName: "www.example.test"}}},
+		{name: "common name not allowed", policy: names, req: &ca.IssueRequest{Subject: pkix.Name{CommonName: "www.example.com"}}, err: "common name www.example.com"},
+		{name: "common name ip", policy: names, req: &ca.IssueRequest{Subject: pkix.Name{CommonName: "10.0.0.1"}}},
+		{name: "common name ip not allowed", policy: names, req: &ca.IssueRequest{Subject: pkix.Name{CommonName: "192.0.2.1"}}, err: "common name 192.0.2.1"},
+		{name: "max days", policy: &signingPolicy{maxDays: 30}, req: &ca.IssueRequest{Validity: 30 * 24 * time.Hour}},
+		{name: "max days exceeded", policy: &signingPolicy{maxDays: 30}, req: &ca.IssueRequest{Validity: 31 * 24 * time.Hour}, err: "30 days or less"},
+		{name: "key type", policy: &signingPolicy{allowedKeyTypes: []string{ca.KeyTypeECDSAP256}}, req: &ca.IssueRequest{KeyType: ca.KeyTypeECDSAP256}},
+		{name: "key type default rsa", policy: &signingPolicy{allowedKeyTypes: []string{ca.KeyTypeECDSAP256}}, req: &ca.IssueRequest{}, err: "key type rsa"},
+		{name: "key type of public key", policy: &signingPolicy{allowedKeyTypes: []string{ca.KeyTypeRSA}}, req: &ca.IssueRequest{KeyType: ca.KeyTypeRSA, PublicKey: ecKey.Public()}, err: "key type " + ca.KeyTypeECDSAP256},
+		{name: "min rsa bits", policy: &signingPolicy{minRSABits: 3072}, req: &ca.IssueRequest{KeyType: ca.KeyTypeRSA, Bits: 4096}},
+		{name: "min rsa bits default", policy: &signingPolicy{minRSABits: 3072}, req: &ca.IssueRequest{}, err: "3072 bits or more"},
+		{name: "min rsa bits ecdsa", policy: &signingPolicy{minRSABits: 3072}, req: &ca.IssueRequest{PublicKey: ecKey.Public()}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.check(tt.req)
+			if tt.err == "" {
+				if err != nil {
+					t.Error(err)
+				}
+				return
+			}
+			var perr *policyError
+			if !errors.As(err, &perr) || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got %v, want policy error containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestMergeSANs(t *testing.T) {
+	csrSANs := func() *ca.IssueRequest {
+		return &ca.IssueRequest{
+			DNSNames:       []string{"csr.test"},
+			IPAddresses:    []net.IP{net.ParseIP("192.0.2.1")},
+			EmailAddresses: []string{"csr@csr.test"},
+			URIs:           []*url.URL{mustParseURL(t, "https://csr.test")},
+		}
+	}
+	args := serverArgs{
+		dnsNames:    []string{"csr.test", "flag.test"},
+		ipAddresses: []net.IP{net.ParseIP("192.0.2.2")},
+		emails:      []string{"flag@flag.test"},
+		urls:        []*url.URL{mustParseURL(t, "https://flag.test")},
+	}
+	tests := []struct {
+		sanMode string
+		want    []string
+	}{
+		{sanMode: "", want: []string{"csr.test", "flag.test", "192.0.2.1", "192.0.2.2", "csr@csr.test", "flag@flag.test", "https://csr.test", "https://flag.test"}},
+		{sanMode: sanModeMerge, want: []string{"csr.test", "flag.test", "192.0.2.1", "192.0.2.2", "csr@csr.test", "flag@flag.test", "https://csr.test", "https://flag.test"}},
+		{sanMode: sanModeCSR, want: []string{"csr.test", "192.0.2.1", "csr@csr.test", "https://csr.test"}},
+		{sanMode: sanModeFlags, want: []string{"csr.test", "flag.test", "192.0.2.2", "flag@flag.test", "https://flag.test"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sanMode, func(t *testing.T) {
+			req := csrSANs()
+			args.sanMode = tt.sanMode
+			if err := mergeSANs(req, args); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			got = append(got, req.DNSNames...)
+			got = append(got, ipStrings(req.IPAddresses)...)
+			got = append(got, req.EmailAddresses...)
+			for _, u := range req.URIs {
+				got = append(got, u.String())
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	args.sanMode = "invalid"
+	if err := mergeSANs(csrSANs(), args); err == nil {
+		t.Error("invalid san mode must be an error")
+	}
+}
+
+// TestSignCSRPolicy はCSRのSANがポリシーを迂回できないことを確認します。
+func TestSignCSRPolicy(t *testing.T) {
+	authority, err := ca.New(&ca.IssueRequest{Subject: pkix.Name{CommonName: "Policy Test CA"}, KeyType: ca.KeyTypeECDSAP256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := newTestPolicy(t, []string{"example.test"}, []string{"127.0.0.0/8"})
+
+	tests := []struct {
+		name string
+		csr  *x509.CertificateRequest
+		err  string
+	}{
+		{name: "allowed", csr: &x509.CertificateRequest{DNSNames: []string{"app.example.test"}, IPAddresses: []net.IP{net.ParseIP("127.0.0.1")}}},
+		{name: "email", csr: &x509.CertificateRequest{DNSNames: []string{"app.example.test"}, EmailAddresses: []string{"ceo@example.com"}}, err: "email address ceo@example.com"},
+		{name: "uri", csr: &x509.CertificateRequest{DNSNames: []string{"app.example.test"}, URIs: []*url.URL{mustParseURL(t, "spiffe://prod/ns/payments/sa/admin")}}, err: "uri spiffe://prod"},
+		{name: "common name", csr: &x509.CertificateRequest{Subject: pkix.Name{CommonName: "evil.test"}, DNSNames: []string{"app.example.test"}}, err: "common name evil.test"},
+	}
+	for _, tt := range tests {
+		der, err := x509.CreateCertificateRequest(rand.Reader, tt.csr, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// API と同じく CSR のSANをフラグとして渡す場合と、CSRのSANだけを使う場合
+		for _, sanMode := range []string{sanModeMerge, sanModeCSR} {
+			t.Run(tt.name+"/"+sanMode, func(t *testing.T) {
+				db, err := loadDatabase(filepath.Join(t.TempDir(), "ca.index.txt"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				args := serverArgs{
+					serialType:  serialTypeSequential,
+					days:        30,
+					dnsNames:    csr.DNSNames,
+					ipAddresses: csr.IPAddresses,
+					emails:      csr.EmailAddresses,
+					urls:        csr.URIs,
+					sanMode:     sanMode,
+					policy:      policy,
+					caCert:      authority.CertificatePEM(),
+					caKey:       authority.Signer,
+					db:          db,
+				}
+				_, err = signCSR(args, csr)
+				if tt.err == "" {
+					if err != nil {
+						t.Error(err)
+					}
+					return
+				}
+				var perr *policyError
+				if !errors.As(err, &perr) || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("got %v, want policy error containing %q", err, tt.err)
+				}
+				if len(db.entries) != 0 {
+					t.Error("rejected certificate must not be recorded")
+				}
+			})
+		}
+	}
+
+	// ACME でもポリシーに合わない識別子は発行しません
+	t.Run("acme", func(t *testing.T) {
+		s := newTestACMEServer(t)
+		s.policy = policy
+		req, order := newTestACMEOrder(t, s, acmeIdentifier{Type: acmeIdentifierDNS, Value: "app.evil.test"})
+		_, err := finalizeTestOrder(s, req, order, newTestCSR(t, key, &x509.CertificateRequest{DNSNames: []string{"app.evil.test"}}))
+		problem, ok := err.(*acmeProblem)
+		if !ok || problem.Type != "urn:ietf:params:acme:error:badCSR" || !strings.Contains(problem.Detail, "policy: dns name app.evil.test") {
+			t.Errorf("got %v", err)
+		}
+	})
+}
+
+func TestServerRenewPolicy(t *testing.T) {
+	authority, err := ca.New(&ca.IssueRequest{Subject: pkix.Name{CommonName: "Policy Test CA"}, KeyType: ca.KeyTypeECDSAP256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := authority.Issue(&ca.IssueRequest{
+		Subject:     pkix.Name{CommonName: "app.example.test"},
+		KeyType:     ca.KeyTypeECDSAP256,
+		Validity:    30 * 24 * time.Hour,
+		DNSNames:    []string{"app.example.test", "old.example.com"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		days   int
+		policy *signingPolicy
+		err    string
+	}{
+		{name: "no policy", days: 3650},
+		{name: "max days", days: 3650, policy: &signingPolicy{maxDays: 30}, err: "30 days or less"},
+		{name: "keep validity", policy: &signingPolicy{maxDays: 30}},
+		{name: "name no longer allowed", policy: newTestPolicy(t, []string{"example.test"}, nil), err: "dns name old.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := loadDatabase(filepath.Join(t.TempDir(), "ca.index.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var args renewArgs
+			args.oldCert = leaf.CertificatePEM()
+			args.serialType = serialTypeSequential
+			args.days = tt.days
+			args.policy = tt.policy
+			args.caCert = authority.CertificatePEM()
+			args.caKey = authority.Signer
+			args.db = db
+			args.cert = &bytes.Buffer{}
+			err = runServerRenew(args)
+			if tt.err == "" {
+				if err != nil {
+					t.Error(err)
+				}
+				return
+			}
+			var perr *policyError
+			if !errors.As(err, &perr) || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got %v, want policy error containing %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package cmd
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/n-creativesystem/self-signed-certificate/pkg/ca"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// certificateProfile は証明書の種類ごとの内容です。設定ファイルの profiles で定義し、--profile で選択します。
+type certificateProfile struct {
+	name               string
+	keyUsage           x509.KeyUsage
+	extKeyUsage        []x509.ExtKeyUsage
+	unknownExtKeyUsage []asn1.ObjectIdentifier
+	days               int
+	maxDays            int
+	keyType            string
+	bits               int
+	basicConstraints   bool
+	isCA               bool
+	maxPathLen         int
+	policies           []asn1.ObjectIdentifier
+	extensions         []pkix.Extension
+}
+
+// builtinProfiles は設定ファイルで定義しなくても使用できるprofileです。同じ名前のprofileを定義すると置き換わります。
+var builtinProfiles = map[string]certificateProfile{
+	"server": {
+		keyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		extKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	},
+	"client": {
+		keyUsage:    x509.KeyUsageDigitalSignature,
+		extKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	},
+	"peer": {
+		keyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		extKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	},
+	"codesign": {
+		keyUsage:    x509.KeyUsageDigitalSignature,
+		extKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	},
+	"ocsp": {
+		keyUsage:    x509.KeyUsageDigitalSignature,
+		extKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning},
+		extensions:  []pkix.Extension{{Id: oidExtensionOCSPNoCheck, Value: []byte{0x05, 0x00}}},
+	},
+}
+
+func addProfileFlag(flags *pflag.FlagSet) {
+	flags.String("profile", "", "certificate profile (server, client, peer, codesign, ocsp or profiles in the configuration)")
+}
+
+// parseProfile は --profile で指定したprofileを返します。指定しない場合は nil です。
+func parseProfile() (*certificateProfile, error) {
+	name := viper.GetString("profile")
+	if name == "" {
+		return nil, nil
+	}
+	key := "profiles." + name
+	if !viper.IsSet(key) {
+		builtin, ok := builtinProfiles[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("profile %s is not defined", name)
+		}
+		builtin.name = name
+		return &builtin, nil
+	}
+	profile, err := loadProfile(viper.Sub(key))
+	if err != nil {
+		return nil, fmt.Errorf("profile %s: %w", name, err)
+	}
+	profile.name = name
+	return profile, nil
+}
+
+func loadProfile(v *viper.Viper) (*certificateProfile, error) {
+	profile := &certificateProfile{
+		days:    v.GetInt("days"),
+		maxDays: v.GetInt("maxDays"),
+		keyType: v.GetString("keyType"),
+		bits:    v.GetInt("bits"),
+	}
+	for _, name := range v.GetStringSlice("keyUsage") {
+		usage, ok := parseKeyUsage(name)
+		if !ok {
+			return nil, fmt.Errorf("invalid key usage %s", name)
+		}
+		profile.keyUsage |= usage
+	}
+	for _, name := range v.GetStringSlice("extKeyUsage") {
+		if usage, ok := parseExtKeyUsage(name); ok {
+			profile.extKeyUsage = append(profile.extKeyUsage, usage)
+			continue
+		}
+		oid, err := parseOID(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ext key usage %s", name)
+		}
+		profile.unknownExtKeyUsage = append(profile.unknownExtKeyUsage, oid)
+	}
+	if v.IsSet("basicConstraints") {
+		profile.basicConstraints = true
+		profile.isCA = v.GetBool("basicConstraints.ca")
+		profile.maxPathLen = v.GetInt("basicConstraints.maxPathLen")
+	}
+	for _, s := range v.GetStringSlice("policies") {
+		oid, err := parseOID(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid policy %s", s)
+		}
+		profile.policies = append(profile.policies, oid)
+	}
+	var extensions []extensionConfig
+	if err := v.UnmarshalKey("extensions", &extensions); err != nil {
+		return nil, err
+	}
+	exts, err := extensionsFromConfig(extensions)
+	if err != nil {
+		return nil, err
+	}
+	profile.extensions = exts
+	return profile, nil
+}
+
+// setDefaults はフラグ、設定ファイル、環境変数で指定していない日数と鍵の種類にprofileの値を設定します。
+func (profile *certificateProfile) setDefaults(days *int, keyType *string, bits *int) {
+	if profile == nil {
+		return
+	}
+	if days != nil && profile.days > 0 && !viper.IsSet("days") {
+		*days = profile.days
+	}
+	if keyType != nil && profile.keyType != "" && !viper.IsSet("keyType") {
+		*keyType = profile.keyType
+	}
+	if bits != nil && profile.bits > 0 && !viper.IsSet("bits") {
+		*bits = profile.bits
+	}
+}
+
+// apply は IssueRequest にprofileのキー使用法、拡張キー使用法、基本制約、証明書ポリシー、拡張を設定します。
+func (profile *certificateProfile) apply(req *ca.IssueRequest) error {
+	if profile == nil {
+		return nil
+	}
+	if profile.maxDays > 0 && req.Validity > time.Hour*24*time.Duration(profile.maxDays) {
+		return fmt.Errorf("profile %s: days must be %d or less", profile.name, profile.maxDays)
+	}
+	if profile.keyUsage != 0 {
+		req.KeyUsage = profile.keyUsage
+	}
+	if profile.extKeyUsage != nil || profile.unknownExtKeyUsage != nil {
+		req.ExtKeyUsage = profile.extKeyUsage
+		req.UnknownExtKeyUsage = profile.unknownExtKeyUsage
+	}
+	if profile.basicConstraints {
+		req.BasicConstraintsValid = true
+		req.IsCA = profile.isCA
+		req.MaxPathLen = profile.maxPathLen
+	}
+	if profile.policies != nil {
+		req.PolicyIdentifiers = profile.policies
+	}
+	req.ExtraExtensions = mergeExtensions(req.ExtraExtensions, profile.extensions)
+	return nil
+}
+
+// mergeExtensions は exts に add の拡張を setExtension で追加します。
+func mergeExtensions(exts, add []pkix.Extension) []pkix.Extension {
+	for _, ext := range add {
+		exts = setExtension(exts, ext)
+	}
+	return exts
+}
+
+// setExtension は同じOIDの拡張を置き換えます。ない場合は追加します。
+func setExtension(exts []pkix.Extension, ext pkix.Extension) []pkix.Extension {
+	result := make([]pkix.Extension, 0, len(exts)+1)
+	for _, e := range exts {
+		if !e.Id.Equal(ext.Id) {
+			result = append(result, e)
+		}
+	}
+	return append(result, ext)
+}
+
+func parseKeyUsage(name string) (x509.KeyUsage, bool) {
+	if strings.EqualFold(name, "nonRepudiation") {
+		return x509.KeyUsageContentCommitment, true
+	}
+	for _, ku := range keyUsages {
+		if strings.EqualFold(ku.name, name) {
+			return ku.usage, true
+		}
+	}
+	return 0, false
+}
+
+func parseExtKeyUsage(name string) (x509.ExtKeyUsage, bool) {
+	for _, eku := range extKeyUsages {
+		if strings.EqualFold(eku.name, name) {
+			return eku.usage, true
+		}
+	}
+	return 0, false
+}
+
+func parseOID(s string) (asn1.ObjectIdentifier, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid oid %s", s)
+	}
+	oid := make(asn1.ObjectIdentifier, 0, len(parts))
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid oid %s", s)
+		}
+		oid = append(oid, n)
+	}
+	return oid, nil
+}
@@ -12,8 +12,19 @@ func rootCommand() *cobra.Command {
 		Short: "自己証明書生成",
 		Long:  "自己証明書生成",
 	}
+	cmd.PersistentFlags().String("passphraseFile", "", "file containing the passphrase for encrypted private keys (default: $SELF_CERT_PASSPHRASE or prompt)")
 	cmd.AddCommand(caCommand())
 	cmd.AddCommand(serverCertificateCommand())
+	cmd.AddCommand(clientCertificateCommand())
+	cmd.AddCommand(csrCommand())
+	cmd.AddCommand(exportCommand())
+	cmd.AddCommand(inspectCommand())
+	cmd.AddCommand(verifyCommand())
+	cmd.AddCommand(ocspCommand())
+	cmd.AddCommand(acmeCommand())
+	cmd.AddCommand(apiCommand())
+	cmd.AddCommand(trustCommand())
+	cmd.AddCommand(applyCommand())
 	return cmd
 }
 
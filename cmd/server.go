@@ -1,7 +1,10 @@
 package cmd
 
 import (
-	"crypto/rsa"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
 	"net"
 	"net/url"
 
@@ -17,12 +20,15 @@ func serverCertificateCommand() *cobra.Command {
 	}
 	cmd.AddCommand(newServerCertificateCommand())
 	cmd.AddCommand(serverCSRCommand())
+	cmd.AddCommand(renewServerCertificateCommand())
 	return &cmd
 }
 
 type serverArgs struct {
 	serialNumber     int
+	serialType       string
 	bits             int
+	keyType          string
 	country          []string
 	organization     []string
 	organizationUnit []string
@@ -32,18 +38,59 @@ type serverArgs struct {
 	ipAddresses      []net.IP
 	emails           []string
 	urls             []*url.URL
+	extKeyUsage      []x509.ExtKeyUsage
+	extraExtensions  []pkix.Extension
+	sanMode          string
+	keyEncryption    keyEncryption
+	policy           *signingPolicy
+	profile          *certificateProfile
 	caCert           []byte
-	caKey            *rsa.PrivateKey
+	caKey            crypto.Signer
 	csrFilename      string
+	certFilename     string
+	db               *database
 	cert             readWrite
 	key              readWrite
+	chain            readWrite
 }
 
 func parseServerArgs() serverArgs {
 	var err error
+	srvArg := parseRequestArgs()
+	srvArg.caCert, srvArg.caKey, err = readCERTandKEY(viper.GetString("caCert"), viper.GetString("caKey"), viper.GetString("caKeyURI"))
+	if err != nil {
+		errorExit(err)
+	}
+	srvArg.db, err = loadDatabase(databasePath(viper.GetString("caCert"), viper.GetString("database")))
+	if err != nil {
+		errorExit(err)
+	}
+	srvArg.csrFilename = viper.GetString("csr")
+	srvArg.sanMode = viper.GetString("sanMode")
+	srvArg.policy, err = parseSigningPolicy()
+	if err != nil {
+		errorExit(err)
+	}
+	srvArg.profile, err = parseProfile()
+	if err != nil {
+		errorExit(err)
+	}
+	srvArg.profile.setDefaults(&srvArg.days, &srvArg.keyType, &srvArg.bits)
+	srvArg.extraExtensions, err = parseExtensions()
+	if err != nil {
+		errorExit(err)
+	}
+
+	return srvArg
+
+}
+
+func parseRequestArgs() serverArgs {
 	var srvArg serverArgs
 	srvArg.serialNumber = viper.GetInt("serialNumber")
+	srvArg.serialType = viper.GetString("serialType")
 	srvArg.bits = viper.GetInt("bits")
+	srvArg.keyType = viper.GetString("keyType")
 	srvArg.days = viper.GetInt("days")
 	srvArg.country = viper.GetStringSlice("country")
 	srvArg.organization = viper.GetStringSlice("organization")
@@ -54,7 +101,11 @@ func parseServerArgs() serverArgs {
 	ipAddresses := viper.GetStringSlice("ipAddresses")
 	srvArg.ipAddresses = make([]net.IP, 0, len(ipAddresses))
 	for _, strIP := range ipAddresses {
-		srvArg.ipAddresses = append(srvArg.ipAddresses, net.ParseIP(strIP))
+		ip := net.ParseIP(strIP)
+		if ip == nil {
+			errorExit(fmt.Errorf("invalid ip address %s", strIP))
+		}
+		srvArg.ipAddresses = append(srvArg.ipAddresses, ip)
 	}
 	urls := viper.GetStringSlice("urls")
 	srvArg.urls = make([]*url.URL, 0, len(urls))
@@ -65,12 +116,5 @@ func parseServerArgs() serverArgs {
 		}
 		srvArg.urls = append(srvArg.urls, u)
 	}
-	srvArg.caCert, srvArg.caKey, err = readCERTandKEY(viper.GetString("caCert"), viper.GetString("caKey"))
-	if err != nil {
-		errorExit(err)
-	}
-	srvArg.csrFilename = viper.GetString("csr")
-
 	return srvArg
-
 }
@@ -2,14 +2,13 @@ package cmd
 
 import (
 	"bytes"
-	"crypto/rand"
 	"crypto/x509"
 	"encoding/pem"
 	"io"
-	"math/big"
 	"os"
 	"time"
 
+	"github.com/n-creativesystem/self-signed-certificate/pkg/ca"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -28,78 +27,108 @@ func serverCSRCommand() *cobra.Command {
 			}
 			initialize(cmd, config)
 			var srvArg serverArgs = parseServerArgs()
+			srvArg.extKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
 			certFilename := viper.GetString("cert")
+			srvArg.certFilename = certFilename
+			chainFilename := viper.GetString("chain")
 			srvArg.cert = &bytes.Buffer{}
+			if chainFilename != "" {
+				srvArg.chain = &bytes.Buffer{}
+			}
 			if err := runServerCSR(srvArg); err != nil {
 				errorExit(err)
 			}
 			fileCreate(certFilename, srvArg.cert)
+			if chainFilename != "" {
+				fileCreate(chainFilename, srvArg.chain)
+			}
+			if err := srvArg.db.save(); err != nil {
+				errorExit(err)
+			}
 		},
 	}
 
 	flags := cmd.Flags()
 	flags.String("config", "", "server configuration")
-	flags.Int("serialNumber", 1, "serial number")
+	flags.Int("serialNumber", 0, "serial number (0: allocate from the certificate database)")
+	flags.String("serialType", serialTypeSequential, "serial number allocation (sequential, random)")
+	flags.String("database", "", "certificate database file name (default: <ca cert name>.index.txt)")
 	flags.Int("bits", 2048, "rsa bits")
 	flags.Int("days", 365, "days")
 	flags.StringSlice("dnsNames", nil, "subject alternate name dns names")
 	flags.StringSlice("ipAddresses", nil, "subject alternate name ip addresses")
 	flags.StringSlice("emailAddresses", nil, "subject alternate name email addresses")
 	flags.StringSlice("urls", nil, "subject alternate name urls")
+	flags.String("sanMode", sanModeMerge, "subject alternate names of the certificate (merge: csr and flags, csr: csr only, flags: flags only)")
 	flags.String("caCert", "ca.crt", "ca cert file name")
 	flags.String("caKey", "ca.key", "ca private key file name")
+	flags.String("caKeyURI", "", "ca private key pkcs11 uri (e.g. pkcs11:token=ca;object=ca-key?module-path=/usr/lib/softhsm/libsofthsm2.so)")
 	flags.String("csr", "server.csr", "server certificate request file name")
 	flags.String("cert", "server.crt", "server cert file name")
+	flags.String("chain", "", "server cert chain file name (server cert and ca certs)")
 	flags.String("key", "server.key", "server private key file name")
+	addProfileFlag(flags)
+	addExtensionFlag(flags)
 	return &cmd
 }
 
 func runServerCSR(args serverArgs) error {
-	p, _ := pem.Decode(args.caCert)
-	caCert := append([]byte{}, p.Bytes...)
-	caTpl, err := x509.ParseCertificate(caCert)
+	csr, err := readCSRFile(args.csrFilename)
 	if err != nil {
 		return err
 	}
-	csr, err := readCSRFile(args.csrFilename)
+	derCertificate, err := signCSR(args, csr)
 	if err != nil {
 		return err
 	}
-	if err := csr.CheckSignature(); err != nil {
+	err = pem.Encode(args.cert, &pem.Block{Type: "CERTIFICATE", Bytes: derCertificate})
+	if err != nil {
 		return err
 	}
-	sslTpl := x509.Certificate{
-		SerialNumber: big.NewInt(int64(args.serialNumber)),
-		NotBefore:    time.Now(),
-		NotAfter:     time.Now().Add(time.Hour * 24 * time.Duration(args.days)),
-
-		KeyUsage:           x509.KeyUsageDigitalSignature,
-		Version:            csr.Version,
-		ExtKeyUsage:        []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
-		Signature:          csr.Signature,
-		SignatureAlgorithm: csr.SignatureAlgorithm,
-		PublicKeyAlgorithm: csr.PublicKeyAlgorithm,
-		PublicKey:          csr.PublicKey,
-		Subject:            csr.Subject,
-		Extensions:         csr.Extensions,
-		ExtraExtensions:    csr.ExtraExtensions,
-
-		DNSNames:    args.dnsNames,
-		IPAddresses: args.ipAddresses,
-
-		EmailAddresses: args.emails,
-		URIs:           args.urls,
+	if args.chain != nil {
+		if err := writeChain(args.chain, derCertificate, args.caCert); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	derCertificate, err := x509.CreateCertificate(rand.Reader, &sslTpl, caTpl, csr.PublicKey, args.caKey)
+func signCSR(args serverArgs, csr *x509.CertificateRequest) ([]byte, error) {
+	authority, err := ca.Load(args.caCert, args.caKey)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	err = pem.Encode(args.cert, &pem.Block{Type: "CERTIFICATE", Bytes: derCertificate})
+	req, err := ca.RequestFromCSR(csr)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	args.db.reserve(authority.Certificate)
+	req.SerialNumber, err = args.db.allocateSerial(args.serialNumber, args.serialType)
+	if err != nil {
+		return nil, err
+	}
+	req.Validity = time.Hour * 24 * time.Duration(args.days)
+	req.ExtKeyUsage = args.extKeyUsage
+	if err := mergeSANs(req, args); err != nil {
+		return nil, err
+	}
+	if err := args.profile.apply(req); err != nil {
+		return nil, err
+	}
+	req.ExtraExtensions = mergeExtensions(req.ExtraExtensions, args.extraExtensions)
+	if args.policy != nil {
+		if err := args.policy.check(req); err != nil {
+			return nil, err
+		}
+	}
+	issued, err := authority.Issue(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := args.db.add(issued.Certificate, args.certFilename); err != nil {
+		return nil, err
+	}
+	return issued.Certificate.Raw, nil
 }
 
 func readCSRFile(filename string) (*x509.CertificateRequest, error) {
@@ -117,13 +146,7 @@ func readCSR(reader io.Reader) (*x509.CertificateRequest, error) {
 		return nil, err
 	}
 	raw := bytes
-	var p *pem.Block
-	rest := bytes
-	for {
-		p, rest = pem.Decode(rest)
-		if p == nil {
-			break
-		}
+	for _, p := range decodePEM(bytes) {
 		if p.Type == "CERTIFICATE REQUEST" {
 			raw = p.Bytes
 		}
@@ -2,14 +2,13 @@ package cmd
 
 import (
 	"bytes"
-	"crypto/rand"
-	"crypto/rsa"
+	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
-	"encoding/pem"
-	"math/big"
+	"net"
 	"time"
 
+	"github.com/n-creativesystem/self-signed-certificate/pkg/ca"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -28,23 +27,57 @@ func newServerCertificateCommand() *cobra.Command {
 			}
 			initialize(cmd, config)
 			var srvArg serverArgs = parseServerArgs()
+			srvArg.extKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+			format := viper.GetString("format")
+			if err := checkOutputFormat(format); err != nil {
+				errorExit(err)
+			}
 			certFilename := viper.GetString("cert")
+			p12Filename := viper.GetString("p12")
+			srvArg.certFilename = certFilename
+			if format == formatPKCS12 {
+				srvArg.certFilename = p12Filename
+			} else {
+				srvArg.keyEncryption = parseKeyEncryption()
+			}
+			chainFilename := viper.GetString("chain")
 			keyFilename := viper.GetString("key")
 			srvArg.cert = &bytes.Buffer{}
+			if chainFilename != "" {
+				srvArg.chain = &bytes.Buffer{}
+			}
 			srvArg.key = &bytes.Buffer{}
 
 			if err := runServerCertificate(srvArg); err != nil {
 				errorExit(err)
 			}
-			fileCreate(certFilename, srvArg.cert)
-			fileCreate(keyFilename, srvArg.key)
+			if chainFilename != "" {
+				fileCreate(chainFilename, srvArg.chain)
+			}
+			switch format {
+			case formatPKCS12:
+				p12 := &bytes.Buffer{}
+				if err := writePKCS12(p12, srvArg.cert, srvArg.key, srvArg.caCert, viper.GetString("password")); err != nil {
+					errorExit(err)
+				}
+				fileCreate(p12Filename, p12)
+			default:
+				fileCreate(certFilename, srvArg.cert)
+				fileCreate(keyFilename, srvArg.key)
+			}
+			if err := srvArg.db.save(); err != nil {
+				errorExit(err)
+			}
 		},
 	}
 
 	flags := cmd.Flags()
 	flags.String("config", "", "server configuration")
-	flags.Int("serialNumber", 1, "serial number")
+	flags.Int("serialNumber", 0, "serial number (0: allocate from the certificate database)")
+	flags.String("serialType", serialTypeSequential, "serial number allocation (sequential, random)")
+	flags.String("database", "", "certificate database file name (default: <ca cert name>.index.txt)")
 	flags.Int("bits", 2048, "rsa bits")
+	flags.String("keyType", ca.KeyTypeRSA, "key type (rsa, ecdsa-p256, ecdsa-p384, ecdsa-p521, ed25519)")
 	flags.StringSlice("country", []string{"JP"}, "country")
 	flags.StringSlice("organization", nil, "organization")
 	flags.StringSlice("organizationUnit", nil, "organization unit")
@@ -56,58 +89,98 @@ func newServerCertificateCommand() *cobra.Command {
 	flags.StringSlice("urls", nil, "subject alternate name urls")
 	flags.String("caCert", "ca.crt", "ca cert file name")
 	flags.String("caKey", "ca.key", "ca private key file name")
+	flags.String("caKeyURI", "", "ca private key pkcs11 uri (e.g. pkcs11:token=ca;object=ca-key?module-path=/usr/lib/softhsm/libsofthsm2.so)")
 	flags.String("cert", "server.crt", "server cert file name")
+	flags.String("chain", "", "server cert chain file name (server cert and ca certs)")
 	flags.String("key", "server.key", "server private key file name")
+	flags.String("format", formatPEM, "output format (pem, pkcs12)")
+	flags.String("p12", "server.p12", "pkcs12 file name (--format pkcs12)")
+	flags.String("password", "", "pkcs12 password (--format pkcs12)")
+	addKeyEncryptionFlags(flags)
+	addProfileFlag(flags)
+	addExtensionFlag(flags)
 	return &cmd
 }
 
 func runServerCertificate(args serverArgs) error {
-	p, _ := pem.Decode(args.caCert)
-	caCert := append([]byte{}, p.Bytes...)
-	caTpl, err := x509.ParseCertificate(caCert)
+	authority, err := ca.Load(args.caCert, args.caKey)
 	if err != nil {
 		return err
 	}
-
-	privateKey, err := rsa.GenerateKey(rand.Reader, args.bits)
+	args.db.reserve(authority.Certificate)
+	serialNumber, err := args.db.allocateSerial(args.serialNumber, args.serialType)
 	if err != nil {
 		return err
 	}
-	publicKey := privateKey.Public()
-
-	subject := pkix.Name{
-		CommonName:         args.commonName,
-		Organization:       args.organization,
-		OrganizationalUnit: args.organizationUnit,
-		Country:            args.country,
-	}
-
-	sslTpl := x509.Certificate{
-		SerialNumber:   big.NewInt(int64(args.serialNumber)),
-		Subject:        subject,
-		NotBefore:      time.Now(),
-		NotAfter:       time.Now().Add(time.Hour * 24 * time.Duration(args.days)),
-		KeyUsage:       x509.KeyUsageDigitalSignature,
-		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	req := &ca.IssueRequest{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			CommonName:         args.commonName,
+			Organization:       args.organization,
+			OrganizationalUnit: args.organizationUnit,
+			Country:            args.country,
+		},
+		KeyType:        args.keyType,
+		Bits:           args.bits,
+		Validity:       time.Hour * 24 * time.Duration(args.days),
+		ExtKeyUsage:    args.extKeyUsage,
 		DNSNames:       args.dnsNames,
 		IPAddresses:    args.ipAddresses,
 		EmailAddresses: args.emails,
 		URIs:           args.urls,
 	}
-
-	derCertificate, err := x509.CreateCertificate(rand.Reader, &sslTpl, caTpl, publicKey, args.caKey)
-	if err != nil {
+	if err := args.profile.apply(req); err != nil {
 		return err
 	}
-	err = pem.Encode(args.cert, &pem.Block{Type: "CERTIFICATE", Bytes: derCertificate})
+	req.ExtraExtensions = mergeExtensions(req.ExtraExtensions, args.extraExtensions)
+	if args.policy != nil {
+		if err := args.policy.check(req); err != nil {
+			return err
+		}
+	}
+	issued, err := authority.Issue(req)
 	if err != nil {
 		return err
 	}
-
-	derPrivateKey := x509.MarshalPKCS1PrivateKey(privateKey)
-	err = pem.Encode(args.key, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: derPrivateKey})
+	if err := args.db.add(issued.Certificate, args.certFilename); err != nil {
+		return err
+	}
+	if _, err := args.cert.Write(issued.CertificatePEM()); err != nil {
+		return err
+	}
+	if args.chain != nil {
+		if _, err := args.chain.Write(issued.ChainPEM()); err != nil {
+			return err
+		}
+	}
+	keyPEM, err := args.keyEncryption.marshal(issued.PrivateKey)
 	if err != nil {
 		return err
 	}
-	return nil
+	_, err = args.key.Write(keyPEM)
+	return err
+}
+
+func servingCertificate(args serverArgs, hostnames []string) (tls.Certificate, error) {
+	args.keyType = ca.KeyTypeECDSAP256
+	for _, hostname := range hostnames {
+		if ip := net.ParseIP(hostname); ip != nil {
+			args.ipAddresses = append(args.ipAddresses, ip)
+		} else {
+			args.dnsNames = append(args.dnsNames, hostname)
+		}
+	}
+	if len(hostnames) > 0 {
+		args.commonName = hostnames[0]
+	}
+	args.extKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	chain := &bytes.Buffer{}
+	key := &bytes.Buffer{}
+	args.cert = &bytes.Buffer{}
+	args.chain = chain
+	args.key = key
+	if err := runServerCertificate(args); err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.X509KeyPair(chain.Bytes(), key.Bytes())
 }
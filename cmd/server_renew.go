@@ -0,0 +1,227 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/n-creativesystem/self-signed-certificate/pkg/ca"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func renewServerCertificateCommand() *cobra.Command {
+	initialize := initialize("server_config")
+	cmd := cobra.Command{
+		Use:   "renew [cert file]",
+		Short: "サーバー証明書の更新",
+		Long:  "既存の証明書のsubject、SAN、拡張をコピーし、同じCAから新しいserial numberと有効期間で証明書を再発行します。--rekey を指定すると秘密鍵も作り直します",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := cmd.Flags().GetString("config")
+			if err != nil {
+				errorExit(err)
+			}
+			initialize(cmd, config)
+			var renewArg renewArgs
+			renewArg.serverArgs = parseServerArgs()
+			certFilename := viper.GetString("cert")
+			oldFilename := certFilename
+			if len(args) > 0 {
+				oldFilename = args[0]
+				if !cmd.Flags().Changed("cert") {
+					certFilename = oldFilename
+				}
+			}
+			renewArg.oldCert, err = os.ReadFile(oldFilename)
+			if err != nil {
+				errorExit(err)
+			}
+			renewArg.rekey = viper.GetBool("rekey")
+			renewArg.keyEncryption = parseKeyEncryption()
+			renewArg.certFilename = certFilename
+			chainFilename := viper.GetString("chain")
+			keyFilename := viper.GetString("key")
+			renewArg.cert = &bytes.Buffer{}
+			if chainFilename != "" {
+				renewArg.chain = &bytes.Buffer{}
+			}
+			renewArg.key = &bytes.Buffer{}
+			if err := runServerRenew(renewArg); err != nil {
+				errorExit(err)
+			}
+			fileCreate(certFilename, renewArg.cert)
+			if chainFilename != "" {
+				fileCreate(chainFilename, renewArg.chain)
+			}
+			if renewArg.rekey {
+				fileCreate(keyFilename, renewArg.key)
+			}
+			if err := renewArg.db.save(); err != nil {
+				errorExit(err)
+			}
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.String("config", "", "server configuration")
+	flags.Int("serialNumber", 0, "serial number (0: allocate from the certificate database)")
+	flags.String("serialType", serialTypeSequential, "serial number allocation (sequential, random)")
+	flags.String("database", "", "certificate database file name (default: <ca cert name>.index.txt)")
+	flags.Int("days", 0, "days (0: same validity period as the existing cert)")
+	flags.Bool("rekey", false, "generate a new private key")
+	flags.String("keyType", "", "key type of the new private key (default: same as the existing key)")
+	flags.Int("bits", 0, "rsa bits of the new private key (default: same as the existing key)")
+	flags.String("caCert", "ca.crt", "ca cert file name")
+	flags.String("caKey", "ca.key", "ca private key file name")
+	flags.String("caKeyURI", "", "ca private key pkcs11 uri (e.g. pkcs11:token=ca;object=ca-key?module-path=/usr/lib/softhsm/libsofthsm2.so)")
+	flags.String("cert", "server.crt", "server cert file name (default: overwrite [cert file])")
+	flags.String("chain", "", "server cert chain file name (server cert and ca certs)")
+	flags.String("key", "server.key", "server private key file name (--rekey)")
+	addKeyEncryptionFlags(flags)
+	addProfileFlag(flags)
+	addExtensionFlag(flags)
+	return &cmd
+}
+
+type renewArgs struct {
+	serverArgs
+	oldCert []byte
+	rekey   bool
+}
+
+var regeneratedExtensions = []asn1.ObjectIdentifier{
+	{2, 5, 29, 14}, // subjectKeyIdentifier
+	{2, 5, 29, 15}, // keyUsage
+	{2, 5, 29, 17}, // subjectAltName
+	{2, 5, 29, 19}, // basicConstraints
+	{2, 5, 29, 32}, // certificatePolicies
+	{2, 5, 29, 35}, // authorityKeyIdentifier
+	{2, 5, 29, 37}, // extKeyUsage
+}
+
+var standardNameAttributes = []asn1.ObjectIdentifier{
+	{2, 5, 4, 3},  // commonName
+	{2, 5, 4, 5},  // serialNumber
+	{2, 5, 4, 6},  // countryName
+	{2, 5, 4, 7},  // localityName
+	{2, 5, 4, 8},  // stateOrProvinceName
+	{2, 5, 4, 9},  // streetAddress
+	{2, 5, 4, 10}, // organizationName
+	{2, 5, 4, 11}, // organizationalUnitName
+	{2, 5, 4, 17}, // postalCode
+}
+
+func runServerRenew(args renewArgs) error {
+	authority, err := ca.Load(args.caCert, args.caKey)
+	if err != nil {
+		return err
+	}
+	old, err := ca.ParseCertificate(args.oldCert)
+	if err != nil {
+		return err
+	}
+	if old.IsCA {
+		return errors.New("certificate is a CA certificate (use ca update)")
+	}
+	if err := old.CheckSignatureFrom(authority.Certificate); err != nil {
+		return fmt.Errorf("certificate is not issued by the CA: %w", err)
+	}
+
+	req := &ca.IssueRequest{
+		Subject:               old.Subject,
+		KeyUsage:              old.KeyUsage,
+		ExtKeyUsage:           old.ExtKeyUsage,
+		PolicyIdentifiers:     old.PolicyIdentifiers,
+		UnknownExtKeyUsage:    old.UnknownExtKeyUsage,
+		BasicConstraintsValid: old.BasicConstraintsValid,
+		DNSNames:              old.DNSNames,
+		IPAddresses:           old.IPAddresses,
+		EmailAddresses:        old.EmailAddresses,
+		URIs:                  old.URIs,
+		Validity:              old.NotAfter.Sub(old.NotBefore),
+	}
+	for _, name := range old.Subject.Names {
+		if !containsOID(standardNameAttributes, name.Type) {
+			req.Subject.ExtraNames = append(req.Subject.ExtraNames, name)
+		}
+	}
+	if args.days > 0 {
+		req.Validity = time.Hour * 24 * time.Duration(args.days)
+	}
+	for _, ext := range old.Extensions {
+		if !containsOID(regeneratedExtensions, ext.Id) {
+			req.ExtraExtensions = append(req.ExtraExtensions, pkix.Extension{Id: ext.Id, Critical: ext.Critical, Value: ext.Value})
+		}
+	}
+	if args.rekey {
+		keyType, bits, err := ca.KeyTypeOf(old.PublicKey)
+		if err != nil {
+			return err
+		}
+		if args.keyType != "" {
+			keyType = args.keyType
+		}
+		if args.bits > 0 {
+			bits = args.bits
+		}
+		req.KeyType = keyType
+		req.Bits = bits
+	} else {
+		req.PublicKey = old.PublicKey
+	}
+
+	if err := args.profile.apply(req); err != nil {
+		return err
+	}
+	req.ExtraExtensions = mergeExtensions(req.ExtraExtensions, args.extraExtensions)
+	if args.policy != nil {
+		if err := args.policy.check(req); err != nil {
+			return err
+		}
+	}
+
+	args.db.reserve(authority.Certificate)
+	req.SerialNumber, err = args.db.allocateSerial(args.serialNumber, args.serialType)
+	if err != nil {
+		return err
+	}
+	issued, err := authority.Issue(req)
+	if err != nil {
+		return err
+	}
+	if err := args.db.add(issued.Certificate, args.certFilename); err != nil {
+		return err
+	}
+	if _, err := args.cert.Write(issued.CertificatePEM()); err != nil {
+		return err
+	}
+	if args.chain != nil {
+		if _, err := args.chain.Write(issued.ChainPEM()); err != nil {
+			return err
+		}
+	}
+	if args.rekey {
+		keyPEM, err := args.keyEncryption.marshal(issued.PrivateKey)
+		if err != nil {
+			return err
+		}
+		if _, err := args.key.Write(keyPEM); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func containsOID(oids []asn1.ObjectIdentifier, oid asn1.ObjectIdentifier) bool {
+	for _, o := range oids {
+		if o.Equal(oid) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,227 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/n-creativesystem/self-signed-certificate/pkg/ca"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+func trustCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "trust",
+		Short: "システムのトラストストアへのCA証明書の登録",
+		Long:  "LinuxのシステムのトラストストアにCA証明書を登録、削除します",
+	}
+	cmd.AddCommand(installTrustCommand())
+	cmd.AddCommand(uninstallTrustCommand())
+	cmd.AddCommand(statusTrustCommand())
+	return &cmd
+}
+
+// trustStore はディストリビューションごとのトラストストアの配置です。
+type trustStore struct {
+	distro    string
+	anchorDir string
+	ext       string
+	bundle    string
+	update    []string
+}
+
+var trustStores = map[string]trustStore{
+	"debian": {
+		distro:    "debian",
+		anchorDir: "/usr/local/share/ca-certificates",
+		ext:       ".crt",
+		bundle:    "/etc/ssl/certs/ca-certificates.crt",
+		update:    []string{"update-ca-certificates"},
+	},
+	"rhel": {
+		distro:    "rhel",
+		anchorDir: "/etc/pki/ca-trust/source/anchors",
+		ext:       ".pem",
+		bundle:    "/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem",
+		update:    []string{"update-ca-trust", "extract"},
+	},
+	"alpine": {
+		distro:    "alpine",
+		anchorDir: "/usr/local/share/ca-certificates",
+		ext:       ".crt",
+		bundle:    "/etc/ssl/certs/ca-certificates.crt",
+		update:    []string{"update-ca-certificates"},
+	},
+	"arch": {
+		distro:    "arch",
+		anchorDir: "/etc/ca-certificates/trust-source/anchors",
+		ext:       ".crt",
+		bundle:    "/etc/ssl/certs/ca-certificates.crt",
+		update:    []string{"trust", "extract-compat"},
+	},
+}
+
+var distroAliases = map[string]string{
+	"debian":    "debian",
+	"ubuntu":    "debian",
+	"rhel":      "rhel",
+	"fedora":    "rhel",
+	"centos":    "rhel",
+	"rocky":     "rhel",
+	"almalinux": "rhel",
+	"amzn":      "rhel",
+	"alpine":    "alpine",
+	"arch":      "arch",
+	"manjaro":   "arch",
+}
+
+// detectTrustStore は root 以下の /etc/os-release の ID、ID_LIKE からトラストストアの配置を判定します。
+// distro を指定した場合は判定せずにその配置を使用します。
+func detectTrustStore(root, distro string) (trustStore, error) {
+	if distro != "" {
+		store, ok := trustStores[distro]
+		if !ok {
+			return trustStore{}, fmt.Errorf("unsupported distro %s (debian, rhel, alpine, arch)", distro)
+		}
+		return store, nil
+	}
+	release, err := os.ReadFile(filepath.Join(root, "etc", "os-release"))
+	if err != nil {
+		return trustStore{}, fmt.Errorf("can not detect the distro: %w", err)
+	}
+	var ids []string
+	scanner := bufio.NewScanner(bytes.NewReader(release))
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "ID":
+			ids = append([]string{strings.Trim(kv[1], `"'`)}, ids...)
+		case "ID_LIKE":
+			ids = append(ids, strings.Fields(strings.Trim(kv[1], `"'`))...)
+		}
+	}
+	for _, id := range ids {
+		if name, ok := distroAliases[id]; ok {
+			return trustStores[name], nil
+		}
+	}
+	return trustStore{}, fmt.Errorf("unsupported distro %s (use --distro)", strings.Join(ids, ", "))
+}
+
+func addTrustFlags(flags *pflag.FlagSet) {
+	flags.String("config", "", "trust configuration")
+	flags.String("caCert", "ca.crt", "ca cert file name")
+	flags.String("root", "/", "root directory of the file system")
+	flags.String("distro", "", "distro layout (debian, rhel, alpine, arch. default: detect from /etc/os-release)")
+	flags.String("name", "", "file name in the trust store (default: ssc-<common name>-<public key hash>)")
+}
+
+type trustArgs struct {
+	caCert *x509.Certificate
+	root   string
+	store  trustStore
+	name   string
+	update bool
+}
+
+func parseTrustArgs() trustArgs {
+	var args trustArgs
+	buf, err := os.ReadFile(viper.GetString("caCert"))
+	if err != nil {
+		errorExit(err)
+	}
+	args.caCert, err = ca.ParseCertificate(buf)
+	if err != nil {
+		errorExit(err)
+	}
+	args.root = viper.GetString("root")
+	args.store, err = detectTrustStore(args.root, viper.GetString("distro"))
+	if err != nil {
+		errorExit(err)
+	}
+	args.name = viper.GetString("name")
+	args.update = viper.GetBool("update")
+	return args
+}
+
+// anchorPath はCA証明書を配置するファイル名です。
+// ファイル名は公開鍵から決めるため、ca update で更新したCA証明書は同じファイルを置き換えます。
+func (args trustArgs) anchorPath() string {
+	name := args.name
+	if name == "" {
+		sum := sha256.Sum256(args.caCert.RawSubjectPublicKeyInfo)
+		name = "ssc-" + hex.EncodeToString(sum[:8])
+		if cn := sanitizeFileName(args.caCert.Subject.CommonName); cn != "" {
+			name = "ssc-" + cn + "-" + hex.EncodeToString(sum[:8])
+		}
+	}
+	return filepath.Join(args.root, args.store.anchorDir, name+args.store.ext)
+}
+
+func sanitizeFileName(s string) string {
+	return strings.Trim(strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + ('a' - 'A')
+		default:
+			return '-'
+		}
+	}, s), "-.")
+}
+
+// updateTrustStore はトラストストアのbundleを再作成します。
+// root が / 以外の場合はコマンドを実行せずに表示のみ行います。
+func updateTrustStore(w io.Writer, args trustArgs) error {
+	if !args.update {
+		return nil
+	}
+	command := strings.Join(args.store.update, " ")
+	if filepath.Clean(args.root) != "/" {
+		fmt.Fprintf(w, "skip %s (root %s)\n", command, args.root)
+		return nil
+	}
+	cmd := exec.Command(args.store.update[0], args.store.update[1:]...)
+	cmd.Stdout = w
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w", command, err)
+	}
+	return nil
+}
+
+func bundleContains(filename string, cert *x509.Certificate) (bool, error) {
+	buf, err := os.ReadFile(filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	for _, block := range decodePEM(buf) {
+		if block.Type == "CERTIFICATE" && bytes.Equal(block.Bytes, cert.Raw) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func encodeCertificate(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
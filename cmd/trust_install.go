@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+func installTrustCommand() *cobra.Command {
+	initialize := initialize("trust_config")
+	cmd := cobra.Command{
+		Use:   "install",
+		Short: "CA証明書をシステムのトラストストアに登録",
+		Long:  `CA証明書をディストリビューションのトラストストアのディレクトリに配置し、bundleを再作成します`,
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := cmd.Flags().GetString("config")
+			if err != nil {
+				errorExit(err)
+			}
+			initialize(cmd, config)
+			if err := runTrustInstall(os.Stdout, parseTrustArgs()); err != nil {
+				errorExit(err)
+			}
+		},
+	}
+	flags := cmd.Flags()
+	addTrustFlags(flags)
+	flags.Bool("update", true, "regenerate the trust bundle")
+	return &cmd
+}
+
+func runTrustInstall(w io.Writer, args trustArgs) error {
+	if !args.caCert.IsCA {
+		return errors.New("certificate is not a CA")
+	}
+	filename := args.anchorPath()
+	buf := encodeCertificate(args.caCert)
+	if current, err := os.ReadFile(filename); err == nil && bytes.Equal(current, buf) {
+		fmt.Fprintf(w, "%s is already installed\n", filename)
+	} else {
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filename, buf, 0644); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "installed %s\n", filename)
+	}
+	return updateTrustStore(w, args)
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+func statusTrustCommand() *cobra.Command {
+	initialize := initialize("trust_config")
+	cmd := cobra.Command{
+		Use:   "status",
+		Short: "CA証明書のトラストストアへの登録状態",
+		Long:  `CA証明書がトラストストアのディレクトリとbundleに登録されているかを表示します。登録されていない場合は終了コード1で終了します`,
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := cmd.Flags().GetString("config")
+			if err != nil {
+				errorExit(err)
+			}
+			initialize(cmd, config)
+			if err := runTrustStatus(os.Stdout, parseTrustArgs()); err != nil {
+				errorExit(err)
+			}
+		},
+	}
+	addTrustFlags(cmd.Flags())
+	return &cmd
+}
+
+func runTrustStatus(w io.Writer, args trustArgs) error {
+	filename := args.anchorPath()
+	installed := false
+	current, err := os.ReadFile(filename)
+	switch {
+	case err == nil:
+		installed = bytes.Equal(current, encodeCertificate(args.caCert))
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+	bundle := filepath.Join(args.root, args.store.bundle)
+	inBundle, err := bundleContains(bundle, args.caCert)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Distro:\t%s\n", args.store.distro)
+	fmt.Fprintf(tw, "Subject:\t%s\n", args.caCert.Subject.String())
+	fmt.Fprintf(tw, "File:\t%s\n", filename)
+	fmt.Fprintf(tw, "Installed:\t%t\n", installed)
+	fmt.Fprintf(tw, "Bundle:\t%s\n", bundle)
+	fmt.Fprintf(tw, "In Bundle:\t%t\n", inBundle)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if !installed {
+		return errors.New("ca certificate is not installed")
+	}
+	if !inBundle {
+		return fmt.Errorf("ca certificate is not in the trust bundle (run %s)", strings.Join(args.store.update, " "))
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/x509/pkix"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/n-creativesystem/self-signed-certificate/pkg/ca"
+)
+
+// 各ディストリビューションの /etc/os-release の抜粋です
+var osReleases = map[string]string{
+	"debian": `PRETTY_NAME="Debian GNU/Linux 12 (bookworm)"
+NAME="Debian GNU/Linux"
+VERSION_ID="12"
+ID=debian
+`,
+	"ubuntu": `NAME="Ubuntu"
+VERSION="22.04.4 LTS (Jammy Jellyfish)"
+ID=ubuntu
+ID_LIKE=debian
+`,
+	"rhel": `NAME="Red Hat Enterprise Linux"
+VERSION="9.3 (Plow)"
+ID="rhel"
+ID_LIKE="fedora"
+`,
+	"rocky": `NAME="Rocky Linux"
+ID="rocky"
+ID_LIKE="rhel centos fedora"
+`,
+	"alpine": `NAME="Alpine Linux"
+ID=alpine
+VERSION_ID=3.19.1
+`,
+	"arch": `NAME="Arch Linux"
+PRETTY_NAME="Arch Linux"
+ID=arch
+BUILD_ID=rolling
+`,
+	"unknown": `NAME="Gentoo"
+ID=gentoo
+`,
+}
+
+func newTrustRoot(t *testing.T, release string) string {
+	t.Helper()
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "etc", "os-release"), []byte(osReleases[release]), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestDetectTrustStore(t *testing.T) {
+	tests := []struct {
+		release string
+		distro  string
+		want    string
+	}{
+		{release: "debian", want: "debian"},
+		{release: "ubuntu", want: "debian"},
+		{release: "rhel", want: "rhel"},
+		{release: "rocky", want: "rhel"},
+		{release: "alpine", want: "alpine"},
+		{release: "arch", want: "arch"},
+		{release: "unknown", distro: "arch", want: "arch"},
+		{release: "debian", distro: "rhel", want: "rhel"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.release+"/"+tt.distro, func(t *testing.T) {
+			store, err := detectTrustStore(newTrustRoot(t, tt.release), tt.distro)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if store.distro != tt.want {
+				t.Errorf("got %s, want %s", store.distro, tt.want)
+			}
+		})
+	}
+
+	if _, err := detectTrustStore(newTrustRoot(t, "unknown"), ""); err == nil || !strings.Contains(err.Error(), "unsupported distro gentoo") {
+		t.Errorf("unknown distro: %v", err)
+	}
+	if _, err := detectTrustStore(newTrustRoot(t, "debian"), "gentoo"); err == nil {
+		t.Error("unsupported --distro must be an error")
+	}
+	if _, err := detectTrustStore(t.TempDir(), ""); err == nil || !strings.Contains(err.Error(), "can not detect") {
+		t.Errorf("missing os-release: %v", err)
+	}
+}
+
+func newTrustCA(t *testing.T) *ca.CA {
+	t.Helper()
+	root, err := ca.New(&ca.IssueRequest{Subject: pkix.Name{CommonName: "Test Root CA"}, KeyType: ca.KeyTypeECDSAP256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestTrustInstallUninstall(t *testing.T) {
+	caCert := newTrustCA(t).Certificate
+	tests := []struct {
+		release string
+		file    string
+	}{
+		{release: "debian", file: "usr/local/share/ca-certificates/ssc-test-root-ca-%s.crt"},
+		{release: "rhel", file: "etc/pki/ca-trust/source/anchors/ssc-test-root-ca-%s.pem"},
+		{release: "alpine", file: "usr/local/share/ca-certificates/ssc-test-root-ca-%s.crt"},
+		{release: "arch", file: "etc/ca-certificates/trust-source/anchors/ssc-test-root-ca-%s.crt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.release, func(t *testing.T) {
+			root := newTrustRoot(t, tt.release)
+			store, err := detectTrustStore(root, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			args := trustArgs{caCert: caCert, root: root, store: store, update: true}
+			filename := args.anchorPath()
+			if ok, _ := filepath.Match(filepath.Join(root, strings.Replace(tt.file, "%s", "*", 1)), filename); !ok {
+				t.Fatalf("anchor path %s does not match %s", filename, tt.file)
+			}
+
+			var out bytes.Buffer
+			if err := runTrustInstall(&out, args); err != nil {
+				t.Fatal(err)
+			}
+			// root が / 以外の場合は bundle を更新するコマンドを実行しません
+			if !strings.Contains(out.String(), "installed "+filename) || !strings.Contains(out.String(), "skip "+strings.Join(store.update, " ")) {
+				t.Errorf("install output: %s", out.String())
+			}
+			buf, err := os.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf, encodeCertificate(caCert)) {
+				t.Error("installed certificate mismatch")
+			}
+
+			out.Reset()
+			if err := runTrustInstall(&out, args); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(out.String(), "is already installed") {
+				t.Errorf("second install output: %s", out.String())
+			}
+
+			out.Reset()
+			if err := runTrustStatus(&out, args); err == nil || !strings.Contains(err.Error(), "not in the trust bundle") {
+				t.Errorf("status without bundle: %v", err)
+			}
+			bundle := filepath.Join(root, store.bundle)
+			if err := os.MkdirAll(filepath.Dir(bundle), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(bundle, buf, 0644); err != nil {
+				t.Fatal(err)
+			}
+			out.Reset()
+			if err := runTrustStatus(&out, args); err != nil {
+				t.Errorf("status: %v\n%s", err, out.String())
+			}
+
+			out.Reset()
+			if err := runTrustUninstall(&out, args); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(out.String(), "removed "+filename) {
+				t.Errorf("uninstall output: %s", out.String())
+			}
+			if _, err := os.Stat(filename); !os.IsNotExist(err) {
+				t.Errorf("anchor file must be removed: %v", err)
+			}
+			out.Reset()
+			if err := runTrustUninstall(&out, args); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(out.String(), "is not installed") {
+				t.Errorf("second uninstall output: %s", out.String())
+			}
+			if err := runTrustStatus(&out, args); err == nil || !strings.Contains(err.Error(), "is not installed") {
+				t.Errorf("status after uninstall: %v", err)
+			}
+		})
+	}
+}
+
+func TestTrustInstallName(t *testing.T) {
+	root := newTrustRoot(t, "debian")
+	store, err := detectTrustStore(root, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	authority := newTrustCA(t)
+	args := trustArgs{caCert: authority.Certificate, root: root, store: store, name: "my-ca"}
+	var out bytes.Buffer
+	if err := runTrustInstall(&out, args); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "usr/local/share/ca-certificates/my-ca.crt")); err != nil {
+		t.Error(err)
+	}
+
+	// CAではない証明書は登録できません
+	leaf, err := authority.Issue(&ca.IssueRequest{Subject: pkix.Name{CommonName: "leaf"}, KeyType: ca.KeyTypeECDSAP256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	args.caCert = leaf.Certificate
+	if err := runTrustInstall(&out, args); err == nil {
+		t.Error("leaf certificate must be an error")
+	}
+}
@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func uninstallTrustCommand() *cobra.Command {
+	initialize := initialize("trust_config")
+	cmd := cobra.Command{
+		Use:   "uninstall",
+		Short: "CA証明書をシステムのトラストストアから削除",
+		Long:  `install で配置したCA証明書をトラストストアのディレクトリから削除し、bundleを再作成します`,
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := cmd.Flags().GetString("config")
+			if err != nil {
+				errorExit(err)
+			}
+			initialize(cmd, config)
+			if err := runTrustUninstall(os.Stdout, parseTrustArgs()); err != nil {
+				errorExit(err)
+			}
+		},
+	}
+	flags := cmd.Flags()
+	addTrustFlags(flags)
+	flags.Bool("update", true, "regenerate the trust bundle")
+	return &cmd
+}
+
+func runTrustUninstall(w io.Writer, args trustArgs) error {
+	filename := args.anchorPath()
+	if err := os.Remove(filename); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintf(w, "%s is not installed\n", filename)
+			return nil
+		}
+		return err
+	}
+	fmt.Fprintf(w, "removed %s\n", filename)
+	return updateTrustStore(w, args)
+}
@@ -1,10 +1,8 @@
 package cmd
 
 import (
-	"crypto/rsa"
-	"crypto/x509"
+	"crypto"
 	"encoding/pem"
-	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -115,6 +113,7 @@ func bindFlags(cmd *cobra.Command, v *viper.Viper) {
 			val := v.Get(f.Name)
 			cmd.Flags().Set(f.Name, fmt.Sprintf("%v", val))
 		}
+		v.BindPFlag(f.Name, f)
 	})
 }
 
@@ -176,41 +175,59 @@ func toSnakeCase(s string) string {
 	return dist.String()
 }
 
-func readCERTandKEY(certFile, keyFile string) ([]byte, *rsa.PrivateKey, error) {
+func decodePEM(data []byte) []*pem.Block {
+	var blocks []*pem.Block
+	rest := data
+	for {
+		var p *pem.Block
+		p, rest = pem.Decode(rest)
+		if p == nil {
+			return blocks
+		}
+		blocks = append(blocks, p)
+	}
+}
+
+func writeChain(w io.Writer, derCertificate []byte, caCert []byte) error {
+	if err := pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: derCertificate}); err != nil {
+		return err
+	}
+	rest := caCert
+	for {
+		var p *pem.Block
+		p, rest = pem.Decode(rest)
+		if p == nil {
+			return nil
+		}
+		if p.Type != "CERTIFICATE" {
+			continue
+		}
+		if err := pem.Encode(w, p); err != nil {
+			return err
+		}
+	}
+}
+
+func readCERTandKEY(certFile, keyFile, keyURI string) ([]byte, crypto.Signer, error) {
 	cert, err := os.ReadFile(certFile)
 	if err != nil {
 		return nil, nil, err
 	}
-	buf, err := os.ReadFile(keyFile)
+	key, err := readCAKey(keyFile, keyURI)
 	if err != nil {
 		return nil, nil, err
 	}
+	return cert, key, nil
+}
+
+func readKeyFile(keyFile string) (crypto.Signer, error) {
+	buf, err := os.ReadFile(keyFile)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	block, _ := pem.Decode(buf)
-	if block == nil {
-		return nil, nil, errors.New("invalid CA private key data")
-	}
-	var key *rsa.PrivateKey
-	switch block.Type {
-	case "RSA PRIVATE KEY":
-		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
-		if err != nil {
-			return nil, nil, err
-		}
-	case "PRIVATE KEY":
-		keyInterface, err := x509.ParsePKCS8PrivateKey(block.Bytes)
-		if err != nil {
-			return nil, nil, err
-		}
-		var ok bool
-		key, ok = keyInterface.(*rsa.PrivateKey)
-		if !ok {
-			return nil, nil, errors.New("not RSA private key is ca.key")
-		}
-	default:
-		return nil, nil, fmt.Errorf("invalid private key type %s is ca.key", block.Type)
+	key, err := parseKeyPEM(keyFile, buf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", keyFile, err)
 	}
-	return cert, key, nil
+	return key, nil
 }
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/n-creativesystem/self-signed-certificate/pkg/ca"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func verifyCommand() *cobra.Command {
+	initialize := initialize("verify_config")
+	cmd := cobra.Command{
+		Use:   "verify <cert file>",
+		Short: "証明書チェーンの検証",
+		Long:  "CA証明書を信頼点として証明書チェーンを構築、検証し、ホスト名、IPアドレス、鍵用途を確認します",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := cmd.Flags().GetString("config")
+			if err != nil {
+				errorExit(err)
+			}
+			initialize(cmd, config)
+			var verifyArg verifyArgs
+			verifyArg.caCert, err = os.ReadFile(viper.GetString("caCert"))
+			if err != nil {
+				errorExit(err)
+			}
+			verifyArg.cert, err = os.ReadFile(args[0])
+			if err != nil {
+				errorExit(err)
+			}
+			verifyArg.host = viper.GetString("host")
+			verifyArg.usage = viper.GetString("usage")
+			if err := runVerify(os.Stdout, verifyArg); err != nil {
+				errorExit(err)
+			}
+		},
+	}
+	flags := cmd.Flags()
+	flags.String("config", "", "verify configuration")
+	flags.String("caCert", "ca.crt", "ca cert (chain) file name")
+	flags.String("host", "", "host name or ip address to check against the subject alternate names")
+	flags.String("usage", "server", "expected usage (server, client, any)")
+	return &cmd
+}
+
+type verifyArgs struct {
+	caCert []byte
+	cert   []byte
+	host   string
+	usage  string
+}
+
+func runVerify(w io.Writer, args verifyArgs) error {
+	var keyUsages []x509.ExtKeyUsage
+	switch args.usage {
+	case "server":
+		keyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	case "client":
+		keyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	case "any":
+		keyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageAny}
+	default:
+		return fmt.Errorf("invalid usage %s", args.usage)
+	}
+	caCerts, err := ca.ParseCertificates(args.caCert)
+	if err != nil {
+		return err
+	}
+	certs, err := ca.ParseCertificates(args.cert)
+	if err != nil {
+		return err
+	}
+	if len(certs) == 0 {
+		return errors.New("certificate not found")
+	}
+	roots := x509.NewCertPool()
+	intermediates := x509.NewCertPool()
+	for _, caCert := range caCerts {
+		if bytes.Equal(caCert.RawSubject, caCert.RawIssuer) && caCert.CheckSignatureFrom(caCert) == nil {
+			roots.AddCert(caCert)
+		} else {
+			intermediates.AddCert(caCert)
+		}
+	}
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	chains, err := certs[0].Verify(x509.VerifyOptions{
+		DNSName:       args.host,
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     keyUsages,
+	})
+	if err != nil {
+		return describeVerifyError(err)
+	}
+	fmt.Fprintln(w, "OK")
+	for i, chain := range chains {
+		fmt.Fprintf(w, "chain %d:\n", i+1)
+		for depth, cert := range chain {
+			fmt.Fprintf(w, "  %d: %s (serial %s)\n", depth, cert.Subject.String(), formatSerial(cert.SerialNumber))
+		}
+	}
+	return nil
+}
+
+func describeVerifyError(err error) error {
+	var invalidErr x509.CertificateInvalidError
+	var hostnameErr x509.HostnameError
+	var unknownErr x509.UnknownAuthorityError
+	switch {
+	case errors.As(err, &invalidErr):
+		switch invalidErr.Reason {
+		case x509.Expired:
+			return fmt.Errorf("expired: %w", err)
+		case x509.IncompatibleUsage:
+			return fmt.Errorf("wrong extended key usage: %w", err)
+		case x509.NotAuthorizedToSign:
+			return fmt.Errorf("issuer is not a CA: %w", err)
+		case x509.CANotAuthorizedForThisName, x509.CANotAuthorizedForExtKeyUsage:
+			return fmt.Errorf("violates CA constraints: %w", err)
+		case x509.TooManyIntermediates:
+			return fmt.Errorf("path length exceeded: %w", err)
+		}
+	case errors.As(err, &hostnameErr):
+		return fmt.Errorf("name mismatch: %w", err)
+	case errors.As(err, &unknownErr):
+		return fmt.Errorf("unknown authority: %w", err)
+	}
+	return fmt.Errorf("verification failed: %w", err)
+}
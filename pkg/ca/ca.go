@@ -0,0 +1,510 @@
+// Package ca は自己署名CAの作成と、CAの秘密鍵による証明書の発行を行います。
+//
+// ssc コマンドと同じ処理をGoのプログラムから呼び出すためのパッケージで、
+// エラーは終了せずに error で返します。秘密鍵は crypto.Signer で受け取るため、
+// HSMなどの鍵も使用できます。
+package ca
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"time"
+)
+
+// DefaultValidity は IssueRequest で有効期間を指定しない場合の有効期間です。
+const DefaultValidity = 365 * 24 * time.Hour
+
+const defaultBits = 2048
+
+// Issuer は IssueRequest の内容で証明書を発行します。
+type Issuer interface {
+	Issue(req *IssueRequest) (*Certificate, error)
+}
+
+// IssueRequest は発行する証明書の内容です。
+type IssueRequest struct {
+	// SerialNumber が nil の場合は128bitの乱数を使用します。
+	SerialNumber *big.Int
+	Subject      pkix.Name
+	// PublicKey が nil の場合は KeyType、Bits で秘密鍵を生成します。
+	PublicKey crypto.PublicKey
+	KeyType   string
+	Bits      int
+	// NotBefore が指定されない場合は現在時刻、NotAfter が指定されない場合は NotBefore + Validity です。
+	NotBefore time.Time
+	NotAfter  time.Time
+	Validity  time.Duration
+	// KeyUsage が指定されない場合は DigitalSignature です。
+	KeyUsage    x509.KeyUsage
+	ExtKeyUsage []x509.ExtKeyUsage
+	// UnknownExtKeyUsage は x509.ExtKeyUsage にない拡張キー使用法のOIDです。
+	UnknownExtKeyUsage []asn1.ObjectIdentifier
+	// PolicyIdentifiers は証明書ポリシーのOIDです。
+	PolicyIdentifiers []asn1.ObjectIdentifier
+	// BasicConstraintsValid が true の場合、Issue で発行する証明書に基本制約(IsCA、MaxPathLen)を設定します。
+	BasicConstraintsValid bool
+	IsCA                  bool
+	DNSNames              []string
+	IPAddresses           []net.IP
+	EmailAddresses        []string
+	URIs                  []*url.URL
+	ExtraExtensions       []pkix.Extension
+	// MaxPathLen は NewIntermediate で作成する中間CA(または IsCA の場合に Issue で発行するCA)の下に置ける中間CAの数です。
+	MaxPathLen int
+	// NameConstraints は New、NewIntermediate で作成するCAの名前制約です。
+	NameConstraints NameConstraints
+}
+
+// RequestFromCSR は証明書要求(CSR)の署名を検証し、subject、公開鍵、SANを設定した IssueRequest を返します。
+func RequestFromCSR(csr *x509.CertificateRequest) (*IssueRequest, error) {
+	if err := csr.CheckSignature(); err != nil {
+		return nil, err
+	}
+	return &IssueRequest{
+		Subject:        csr.Subject,
+		PublicKey:      csr.PublicKey,
+		DNSNames:       csr.DNSNames,
+		IPAddresses:    csr.IPAddresses,
+		EmailAddresses: csr.EmailAddresses,
+		URIs:           csr.URIs,
+	}, nil
+}
+
+// Certificate は発行した証明書です。
+type Certificate struct {
+	Certificate *x509.Certificate
+	// PrivateKey は IssueRequest.PublicKey を指定しなかった場合に生成した秘密鍵です。
+	PrivateKey crypto.Signer
+	// Chain は発行したCAから順に並べた上位の証明書です。
+	Chain []*x509.Certificate
+}
+
+// CertificatePEM は証明書をPEM形式で返します。
+func (c *Certificate) CertificatePEM() []byte {
+	return encodeCertificates(c.Certificate)
+}
+
+// ChainPEM は証明書と上位のCA証明書をPEM形式で返します。
+func (c *Certificate) ChainPEM() []byte {
+	return encodeCertificates(append([]*x509.Certificate{c.Certificate}, c.Chain...)...)
+}
+
+// PrivateKeyPEM は生成した秘密鍵をPEM形式で返します。
+func (c *Certificate) PrivateKeyPEM() ([]byte, error) {
+	return encodePrivateKey(c.PrivateKey)
+}
+
+// TLSCertificate は生成した秘密鍵と証明書チェーンを tls.Certificate で返します。
+func (c *Certificate) TLSCertificate() (tls.Certificate, error) {
+	if c.PrivateKey == nil {
+		return tls.Certificate{}, errors.New("private key is not generated")
+	}
+	cert := tls.Certificate{
+		PrivateKey: c.PrivateKey,
+		Leaf:       c.Certificate,
+	}
+	for _, certificate := range append([]*x509.Certificate{c.Certificate}, c.Chain...) {
+		cert.Certificate = append(cert.Certificate, certificate.Raw)
+	}
+	return cert, nil
+}
+
+// CA は証明書に署名する認証局です。
+type CA struct {
+	Certificate *x509.Certificate
+	Signer      crypto.Signer
+	// Chain は Certificate の上位のCA証明書です(中間CAの場合)。
+	Chain []*x509.Certificate
+}
+
+// New は自己署名のルートCAを作成します。
+func New(req *IssueRequest) (*CA, error) {
+	if req.PublicKey != nil {
+		return nil, errors.New("public key can not be specified for a new CA")
+	}
+	tpl, key, err := newTemplate(req)
+	if err != nil {
+		return nil, err
+	}
+	req.NameConstraints.apply(tpl)
+	return newRoot(tpl, key)
+}
+
+// NewWithSigner は既存の秘密鍵(HSMの鍵など)で自己署名のルートCAを作成します。
+// req の PublicKey、KeyType、Bits は使用しません。
+func NewWithSigner(req *IssueRequest, signer crypto.Signer) (*CA, error) {
+	r := *req
+	r.PublicKey = signer.Public()
+	tpl, _, err := newTemplate(&r)
+	if err != nil {
+		return nil, err
+	}
+	req.NameConstraints.apply(tpl)
+	return newRoot(tpl, signer)
+}
+
+func newRoot(tpl *x509.Certificate, key crypto.Signer) (*CA, error) {
+	tpl.IsCA = true
+	tpl.BasicConstraintsValid = true
+	tpl.KeyUsage |= x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &CA{Certificate: cert, Signer: key}, nil
+}
+
+// Load はPEM形式のCA証明書(上位のCA証明書を含めてもよい)と秘密鍵からCAを読み込みます。
+func Load(certPEM []byte, signer crypto.Signer) (*CA, error) {
+	certs, err := ParseCertificates(certPEM)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("certificate not found")
+	}
+	public, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !public.Equal(certs[0].PublicKey) {
+		return nil, errors.New("private key does not match the ca certificate")
+	}
+	if err := checkCA(certs[0]); err != nil {
+		return nil, err
+	}
+	return &CA{Certificate: certs[0], Signer: signer, Chain: certs[1:]}, nil
+}
+
+// LoadFiles はCA証明書ファイルと秘密鍵ファイルからCAを読み込みます。
+func LoadFiles(certFile, keyFile string) (*CA, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", keyFile, err)
+	}
+	return Load(certPEM, key)
+}
+
+// CertificatePEM はCA証明書をPEM形式で返します。
+func (ca *CA) CertificatePEM() []byte {
+	return encodeCertificates(ca.Certificate)
+}
+
+// ChainPEM はCA証明書と上位のCA証明書をPEM形式で返します。
+func (ca *CA) ChainPEM() []byte {
+	return encodeCertificates(append([]*x509.Certificate{ca.Certificate}, ca.Chain...)...)
+}
+
+// PrivateKeyPEM はCAの秘密鍵をPEM形式で返します。
+func (ca *CA) PrivateKeyPEM() ([]byte, error) {
+	return encodePrivateKey(ca.Signer)
+}
+
+// Issue はCAの秘密鍵で署名した証明書を発行します。
+// SANがCA証明書または上位のCA証明書の名前制約に違反する場合はエラーを返します。
+func (ca *CA) Issue(req *IssueRequest) (*Certificate, error) {
+	if err := checkCA(ca.Certificate); err != nil {
+		return nil, err
+	}
+	tpl, key, err := newTemplate(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := ca.checkNameConstraints(tpl); err != nil {
+		return nil, err
+	}
+	if tpl.IsCA {
+		if err := checkParentCA(ca.Certificate, req.MaxPathLen); err != nil {
+			return nil, err
+		}
+	}
+	publicKey := req.PublicKey
+	if key != nil {
+		publicKey = key.Public()
+	}
+	cert, err := ca.sign(tpl, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Certificate{Certificate: cert, PrivateKey: key, Chain: ca.chain()}, nil
+}
+
+// NewIntermediate はCAの秘密鍵で署名した中間CAを作成します。
+// 有効期限はCAの有効期限までに切り詰めます。
+func (ca *CA) NewIntermediate(req *IssueRequest) (*CA, error) {
+	if req.PublicKey != nil {
+		return nil, errors.New("public key can not be specified for an intermediate CA")
+	}
+	if err := checkParentCA(ca.Certificate, req.MaxPathLen); err != nil {
+		return nil, err
+	}
+	tpl, key, err := newTemplate(req)
+	if err != nil {
+		return nil, err
+	}
+	return ca.newIntermediate(tpl, req, key)
+}
+
+// NewIntermediateWithSigner は既存の秘密鍵で中間CAを作成します。中間CAの証明書を更新する場合に使用します。
+// req の PublicKey、KeyType、Bits は使用しません。
+func (ca *CA) NewIntermediateWithSigner(req *IssueRequest, signer crypto.Signer) (*CA, error) {
+	if err := checkParentCA(ca.Certificate, req.MaxPathLen); err != nil {
+		return nil, err
+	}
+	r := *req
+	r.PublicKey = signer.Public()
+	tpl, _, err := newTemplate(&r)
+	if err != nil {
+		return nil, err
+	}
+	return ca.newIntermediate(tpl, req, signer)
+}
+
+func (ca *CA) newIntermediate(tpl *x509.Certificate, req *IssueRequest, key crypto.Signer) (*CA, error) {
+	if tpl.NotAfter.After(ca.Certificate.NotAfter) {
+		tpl.NotAfter = ca.Certificate.NotAfter
+	}
+	tpl.IsCA = true
+	tpl.BasicConstraintsValid = true
+	tpl.KeyUsage |= x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	tpl.MaxPathLen = req.MaxPathLen
+	tpl.MaxPathLenZero = req.MaxPathLen == 0
+	req.NameConstraints.apply(tpl)
+	cert, err := ca.sign(tpl, key.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &CA{Certificate: cert, Signer: key, Chain: ca.chain()}, nil
+}
+
+// CrossSign は cert と同じsubject、公開鍵、CAの制約を持ち、このCAの秘密鍵で署名したクロス証明書を発行します。
+// serialNumber が nil の場合は128bitの乱数を使用します。有効期限はCAの有効期限までに切り詰めます。
+func (ca *CA) CrossSign(cert *x509.Certificate, serialNumber *big.Int) (*x509.Certificate, error) {
+	if !cert.IsCA {
+		return nil, errors.New("certificate is not a CA")
+	}
+	if serialNumber == nil {
+		var err error
+		serialNumber, err = RandomSerialNumber()
+		if err != nil {
+			return nil, err
+		}
+	}
+	notAfter := cert.NotAfter
+	if notAfter.After(ca.Certificate.NotAfter) {
+		notAfter = ca.Certificate.NotAfter
+	}
+	tpl := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		RawSubject:            cert.RawSubject,
+		NotBefore:             time.Now(),
+		NotAfter:              notAfter,
+		KeyUsage:              cert.KeyUsage,
+		ExtKeyUsage:           cert.ExtKeyUsage,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		MaxPathLen:            cert.MaxPathLen,
+		MaxPathLenZero:        cert.MaxPathLenZero,
+		SubjectKeyId:          cert.SubjectKeyId,
+		// subjectが同じでも自己署名と区別できるように、署名したCAの鍵識別子を設定します。
+		AuthorityKeyId:              ca.Certificate.SubjectKeyId,
+		PermittedDNSDomainsCritical: cert.PermittedDNSDomainsCritical,
+		PermittedDNSDomains:         cert.PermittedDNSDomains,
+		ExcludedDNSDomains:          cert.ExcludedDNSDomains,
+		PermittedIPRanges:           cert.PermittedIPRanges,
+		ExcludedIPRanges:            cert.ExcludedIPRanges,
+		PermittedEmailAddresses:     cert.PermittedEmailAddresses,
+		ExcludedEmailAddresses:      cert.ExcludedEmailAddresses,
+		PermittedURIDomains:         cert.PermittedURIDomains,
+		ExcludedURIDomains:          cert.ExcludedURIDomains,
+	}
+	return ca.sign(tpl, cert.PublicKey)
+}
+
+func (ca *CA) sign(tpl *x509.Certificate, publicKey crypto.PublicKey) (*x509.Certificate, error) {
+	der, err := x509.CreateCertificate(rand.Reader, tpl, ca.Certificate, publicKey, ca.Signer)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+func (ca *CA) chain() []*x509.Certificate {
+	return append([]*x509.Certificate{ca.Certificate}, ca.Chain...)
+}
+
+func newTemplate(req *IssueRequest) (*x509.Certificate, crypto.Signer, error) {
+	serialNumber := req.SerialNumber
+	if serialNumber == nil {
+		var err error
+		serialNumber, err = RandomSerialNumber()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	notBefore := req.NotBefore
+	if notBefore.IsZero() {
+		notBefore = time.Now()
+	}
+	notAfter := req.NotAfter
+	if notAfter.IsZero() {
+		validity := req.Validity
+		if validity == 0 {
+			validity = DefaultValidity
+		}
+		notAfter = notBefore.Add(validity)
+	}
+	keyUsage := req.KeyUsage
+	if keyUsage == 0 {
+		keyUsage = x509.KeyUsageDigitalSignature
+	}
+	var key crypto.Signer
+	if req.PublicKey == nil {
+		bits := req.Bits
+		if bits == 0 {
+			bits = defaultBits
+		}
+		var err error
+		key, err = GenerateKey(req.KeyType, bits)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	tpl := &x509.Certificate{
+		SerialNumber:       serialNumber,
+		Subject:            req.Subject,
+		NotBefore:          notBefore,
+		NotAfter:           notAfter,
+		KeyUsage:           keyUsage,
+		ExtKeyUsage:        req.ExtKeyUsage,
+		UnknownExtKeyUsage: req.UnknownExtKeyUsage,
+		PolicyIdentifiers:  req.PolicyIdentifiers,
+		DNSNames:           req.DNSNames,
+		IPAddresses:        req.IPAddresses,
+		EmailAddresses:     req.EmailAddresses,
+		URIs:               req.URIs,
+		ExtraExtensions:    req.ExtraExtensions,
+	}
+	if req.BasicConstraintsValid {
+		tpl.BasicConstraintsValid = true
+		tpl.IsCA = req.IsCA
+		if req.IsCA {
+			tpl.MaxPathLen = req.MaxPathLen
+			tpl.MaxPathLenZero = req.MaxPathLen == 0
+		}
+	}
+	return tpl, key, nil
+}
+
+// checkCA は cert が証明書に署名できるCA証明書か確認します。
+func checkCA(cert *x509.Certificate) error {
+	if !cert.BasicConstraintsValid || !cert.IsCA || cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return errors.New("certificate is not a CA")
+	}
+	return nil
+}
+
+func checkParentCA(parent *x509.Certificate, maxPathLen int) error {
+	if err := checkCA(parent); err != nil {
+		return fmt.Errorf("parent %w", err)
+	}
+	if maxPathLen < 0 {
+		return errors.New("maxPathLen must be 0 or greater")
+	}
+	switch {
+	case parent.MaxPathLen == 0 && parent.MaxPathLenZero:
+		return errors.New("parent CA does not allow intermediate CAs (max path length 0)")
+	case parent.MaxPathLen > 0 && maxPathLen >= parent.MaxPathLen:
+		return fmt.Errorf("maxPathLen must be less than parent CA max path length %d", parent.MaxPathLen)
+	}
+	return nil
+}
+
+// RandomSerialNumber は128bitの乱数のserial numberを返します。
+func RandomSerialNumber() (*big.Int, error) {
+	max := new(big.Int).Lsh(big.NewInt(1), 128)
+	for {
+		serial, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return nil, err
+		}
+		if serial.Sign() > 0 {
+			return serial, nil
+		}
+	}
+}
+
+// ParseCertificates はPEMデータに含まれるすべての証明書を読み込みます。
+func ParseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	rest := data
+	for {
+		var p *pem.Block
+		p, rest = pem.Decode(rest)
+		if p == nil {
+			return certs, nil
+		}
+		if p.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(p.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+}
+
+// ParseCertificate はPEMデータの最初の証明書を読み込みます。
+func ParseCertificate(data []byte) (*x509.Certificate, error) {
+	rest := data
+	for {
+		var p *pem.Block
+		p, rest = pem.Decode(rest)
+		if p == nil {
+			return nil, errors.New("certificate not found")
+		}
+		if p.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(p.Bytes)
+		}
+	}
+}
+
+func encodeCertificates(certs ...*x509.Certificate) []byte {
+	var data []byte
+	for _, cert := range certs {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	return data
+}
+
+func encodePrivateKey(key crypto.Signer) ([]byte, error) {
+	if key == nil {
+		return nil, errors.New("private key is not generated")
+	}
+	block, err := MarshalPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(block), nil
+}
@@ -0,0 +1,421 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestCA(t *testing.T, req *IssueRequest) *CA {
+	t.Helper()
+	if req.KeyType == "" {
+		req.KeyType = KeyTypeECDSAP256
+	}
+	ca, err := New(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca
+}
+
+func verify(t *testing.T, cert *x509.Certificate, root *x509.Certificate, intermediates []*x509.Certificate, usage x509.ExtKeyUsage) error {
+	t.Helper()
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	pool := x509.NewCertPool()
+	for _, c := range intermediates {
+		pool.AddCert(c)
+	}
+	_, err := cert.Verify(x509.VerifyOptions{Roots: roots, Intermediates: pool, KeyUsages: []x509.ExtKeyUsage{usage}})
+	return err
+}
+
+func TestNew(t *testing.T) {
+	root := newTestCA(t, &IssueRequest{Subject: pkix.Name{CommonName: "Test Root"}})
+	cert := root.Certificate
+	if !cert.IsCA || !cert.BasicConstraintsValid {
+		t.Error("root must be a CA")
+	}
+	if cert.KeyUsage&x509.KeyUsageCertSign == 0 || cert.KeyUsage&x509.KeyUsageCRLSign == 0 {
+		t.Errorf("key usage: %v", cert.KeyUsage)
+	}
+	if err := cert.CheckSignatureFrom(cert); err != nil {
+		t.Errorf("root must be self-signed: %v", err)
+	}
+	if len(root.Chain) != 0 {
+		t.Errorf("root chain: %d", len(root.Chain))
+	}
+	if _, err := New(&IssueRequest{PublicKey: root.Signer.Public()}); err == nil {
+		t.Error("public key must be an error")
+	}
+}
+
+func TestIssue(t *testing.T) {
+	root := newTestCA(t, &IssueRequest{Subject: pkix.Name{CommonName: "Test Root"}})
+	notBefore := time.Now().Truncate(time.Second)
+	c, err := root.Issue(&IssueRequest{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		KeyType:      KeyTypeEd25519,
+		NotBefore:    notBefore,
+		Validity:     24 * time.Hour,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := c.Certificate
+	if cert.SerialNumber.Int64() != 42 {
+		t.Errorf("serial number: %v", cert.SerialNumber)
+	}
+	if !cert.NotAfter.Equal(notBefore.Add(24 * time.Hour)) {
+		t.Errorf("not after: %v", cert.NotAfter)
+	}
+	if cert.IsCA {
+		t.Error("leaf must not be a CA")
+	}
+	if c.PrivateKey == nil {
+		t.Fatal("private key must be generated")
+	}
+	if keyType, _, _ := KeyTypeOf(cert.PublicKey); keyType != KeyTypeEd25519 {
+		t.Errorf("key type: %s", keyType)
+	}
+	if len(c.Chain) != 1 || !c.Chain[0].Equal(root.Certificate) {
+		t.Errorf("chain: %v", c.Chain)
+	}
+	if err := verify(t, cert, root.Certificate, nil, x509.ExtKeyUsageServerAuth); err != nil {
+		t.Error(err)
+	}
+	if err := cert.VerifyHostname("127.0.0.1"); err != nil {
+		t.Error(err)
+	}
+	if _, err := c.TLSCertificate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestIssueDefaults(t *testing.T) {
+	root := newTestCA(t, &IssueRequest{Subject: pkix.Name{CommonName: "Test Root"}})
+	c, err := root.Issue(&IssueRequest{Subject: pkix.Name{CommonName: "default"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyType, bits, _ := KeyTypeOf(c.Certificate.PublicKey); keyType != KeyTypeRSA || bits != defaultBits {
+		t.Errorf("key: %s %d", keyType, bits)
+	}
+	if c.Certificate.KeyUsage != x509.KeyUsageDigitalSignature {
+		t.Errorf("key usage: %v", c.Certificate.KeyUsage)
+	}
+	if d := c.Certificate.NotAfter.Sub(c.Certificate.NotBefore); d != DefaultValidity {
+		t.Errorf("validity: %v", d)
+	}
+	if c.Certificate.SerialNumber.Sign() <= 0 {
+		t.Errorf("serial number: %v", c.Certificate.SerialNumber)
+	}
+}
+
+func TestIssueWithPublicKey(t *testing.T) {
+	root := newTestCA(t, &IssueRequest{Subject: pkix.Name{CommonName: "Test Root"}})
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := root.Issue(&IssueRequest{Subject: pkix.Name{CommonName: "csr"}, PublicKey: key.Public()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.PrivateKey != nil {
+		t.Error("private key must not be generated")
+	}
+	if !key.PublicKey.Equal(c.Certificate.PublicKey) {
+		t.Error("public key mismatch")
+	}
+	if _, err := c.PrivateKeyPEM(); err == nil {
+		t.Error("PrivateKeyPEM must be an error")
+	}
+	if _, err := c.TLSCertificate(); err == nil {
+		t.Error("TLSCertificate must be an error")
+	}
+}
+
+func TestNewIntermediate(t *testing.T) {
+	root := newTestCA(t, &IssueRequest{Subject: pkix.Name{CommonName: "Test Root"}, Validity: 48 * time.Hour})
+	inter, err := root.NewIntermediate(&IssueRequest{
+		Subject:  pkix.Name{CommonName: "Test Intermediate"},
+		KeyType:  KeyTypeECDSAP256,
+		Validity: 10 * DefaultValidity,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 有効期限はルートCAまでに切り詰めます
+	if !inter.Certificate.NotAfter.Equal(root.Certificate.NotAfter) {
+		t.Errorf("not after: %v, root %v", inter.Certificate.NotAfter, root.Certificate.NotAfter)
+	}
+	if len(inter.Chain) != 1 || !inter.Chain[0].Equal(root.Certificate) {
+		t.Errorf("chain: %v", inter.Chain)
+	}
+	leaf, err := inter.Issue(&IssueRequest{
+		Subject:     pkix.Name{CommonName: "client"},
+		KeyType:     KeyTypeECDSAP256,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leaf.Chain) != 2 {
+		t.Fatalf("chain: %d", len(leaf.Chain))
+	}
+	if err := verify(t, leaf.Certificate, root.Certificate, leaf.Chain[:1], x509.ExtKeyUsageClientAuth); err != nil {
+		t.Error(err)
+	}
+	if err := verify(t, leaf.Certificate, root.Certificate, nil, x509.ExtKeyUsageClientAuth); err == nil {
+		t.Error("verify without the intermediate must fail")
+	}
+	certs, err := ParseCertificates(leaf.ChainPEM())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 3 || !certs[0].Equal(leaf.Certificate) || !certs[2].Equal(root.Certificate) {
+		t.Errorf("chain pem: %d certificates", len(certs))
+	}
+}
+
+func TestMaxPathLen(t *testing.T) {
+	root := newTestCA(t, &IssueRequest{Subject: pkix.Name{CommonName: "Test Root"}})
+	inter1, err := root.NewIntermediate(&IssueRequest{Subject: pkix.Name{CommonName: "Intermediate 1"}, KeyType: KeyTypeECDSAP256, MaxPathLen: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inter1.Certificate.MaxPathLen != 1 || inter1.Certificate.MaxPathLenZero {
+		t.Errorf("max path len: %d %v", inter1.Certificate.MaxPathLen, inter1.Certificate.MaxPathLenZero)
+	}
+	if _, err := inter1.NewIntermediate(&IssueRequest{KeyType: KeyTypeECDSAP256, MaxPathLen: 1}); err == nil {
+		t.Error("max path len must be less than the parent")
+	}
+	inter2, err := inter1.NewIntermediate(&IssueRequest{Subject: pkix.Name{CommonName: "Intermediate 2"}, KeyType: KeyTypeECDSAP256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inter2.Certificate.MaxPathLen != 0 || !inter2.Certificate.MaxPathLenZero {
+		t.Errorf("max path len: %d %v", inter2.Certificate.MaxPathLen, inter2.Certificate.MaxPathLenZero)
+	}
+	if _, err := inter2.NewIntermediate(&IssueRequest{KeyType: KeyTypeECDSAP256}); err == nil {
+		t.Error("max path len 0 must not allow intermediate CAs")
+	}
+	// Issue でCAを発行する場合も同じ制限です
+	if _, err := inter2.Issue(&IssueRequest{KeyType: KeyTypeECDSAP256, BasicConstraintsValid: true, IsCA: true}); err == nil {
+		t.Error("max path len 0 must not allow CA certificates")
+	}
+	if _, err := root.NewIntermediate(&IssueRequest{KeyType: KeyTypeECDSAP256, MaxPathLen: -1}); err == nil {
+		t.Error("negative max path len must be an error")
+	}
+
+	leaf, err := inter2.Issue(&IssueRequest{Subject: pkix.Name{CommonName: "leaf"}, KeyType: KeyTypeECDSAP256, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(t, leaf.Certificate, root.Certificate, []*x509.Certificate{inter1.Certificate, inter2.Certificate}, x509.ExtKeyUsageServerAuth); err != nil {
+		t.Error(err)
+	}
+	// 葉の証明書はCAとして使用できません
+	leafCA := &CA{Certificate: leaf.Certificate, Signer: leaf.PrivateKey}
+	if _, err := leafCA.Issue(&IssueRequest{KeyType: KeyTypeECDSAP256, BasicConstraintsValid: true, IsCA: true}); err == nil {
+		t.Error("leaf must not issue CA certificates")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	root := newTestCA(t, &IssueRequest{Subject: pkix.Name{CommonName: "Test Root"}})
+	inter, err := root.NewIntermediate(&IssueRequest{Subject: pkix.Name{CommonName: "Test Intermediate"}, KeyType: KeyTypeRSA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := inter.PrivateKeyPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(inter.ChainPEM(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Certificate.Equal(inter.Certificate) || len(loaded.Chain) != 1 || !loaded.Chain[0].Equal(root.Certificate) {
+		t.Error("loaded ca mismatch")
+	}
+	if _, ok := loaded.Signer.(*rsa.PrivateKey); !ok {
+		t.Errorf("signer: %T", loaded.Signer)
+	}
+
+	if _, err := Load(inter.CertificatePEM(), root.Signer); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("key mismatch: %v", err)
+	}
+	if _, err := Load(keyPEM, key); err == nil {
+		t.Error("missing certificate must be an error")
+	}
+
+	// CAではない証明書では発行できません
+	leaf, err := inter.Issue(&IssueRequest{Subject: pkix.Name{CommonName: "leaf"}, KeyType: KeyTypeECDSAP256, DNSNames: []string{"leaf.test"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(leaf.CertificatePEM(), leaf.PrivateKey); err == nil || !strings.Contains(err.Error(), "not a CA") {
+		t.Errorf("leaf certificate: %v", err)
+	}
+	leafCA := &CA{Certificate: leaf.Certificate, Signer: leaf.PrivateKey}
+	if _, err := leafCA.Issue(&IssueRequest{KeyType: KeyTypeECDSAP256, DNSNames: []string{"other.test"}}); err == nil || !strings.Contains(err.Error(), "not a CA") {
+		t.Errorf("issue by leaf certificate: %v", err)
+	}
+}
+
+func TestCrossSign(t *testing.T) {
+	oldRoot := newTestCA(t, &IssueRequest{Subject: pkix.Name{CommonName: "Old Root"}})
+	newRoot := newTestCA(t, &IssueRequest{
+		Subject:         pkix.Name{CommonName: "New Root"},
+		Validity:        10 * DefaultValidity,
+		NameConstraints: NameConstraints{Critical: true, PermittedDNSDomains: []string{".test"}},
+	})
+	cross, err := oldRoot.CrossSign(newRoot.Certificate, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(cross.RawSubject) != string(newRoot.Certificate.RawSubject) {
+		t.Error("subject mismatch")
+	}
+	if string(cross.RawIssuer) != string(oldRoot.Certificate.RawSubject) {
+		t.Error("issuer mismatch")
+	}
+	if !cross.NotAfter.Equal(oldRoot.Certificate.NotAfter) {
+		t.Errorf("not after: %v", cross.NotAfter)
+	}
+	if !cross.IsCA || !cross.PermittedDNSDomainsCritical || len(cross.PermittedDNSDomains) != 1 {
+		t.Error("ca constraints must be copied")
+	}
+	if string(cross.AuthorityKeyId) != string(oldRoot.Certificate.SubjectKeyId) {
+		t.Error("authority key id mismatch")
+	}
+
+	// 新しいCAで発行した証明書を、古いCAだけを信頼する環境でクロス証明書を使って検証できます
+	leaf, err := newRoot.Issue(&IssueRequest{
+		Subject:     pkix.Name{CommonName: "www.example.test"},
+		KeyType:     KeyTypeECDSAP256,
+		DNSNames:    []string{"www.example.test"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(t, leaf.Certificate, oldRoot.Certificate, []*x509.Certificate{cross}, x509.ExtKeyUsageServerAuth); err != nil {
+		t.Error(err)
+	}
+	if err := verify(t, leaf.Certificate, oldRoot.Certificate, nil, x509.ExtKeyUsageServerAuth); err == nil {
+		t.Error("verify without the cross certificate must fail")
+	}
+
+	if _, err := oldRoot.CrossSign(leaf.Certificate, nil); err == nil {
+		t.Error("cross sign of a leaf must be an error")
+	}
+	cross, err = oldRoot.CrossSign(newRoot.Certificate, big.NewInt(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cross.SerialNumber.Int64() != 7 {
+		t.Errorf("serial number: %v", cross.SerialNumber)
+	}
+}
+
+func TestRequestFromCSR(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:        pkix.Name{CommonName: "csr.test"},
+		DNSNames:       []string{"csr.test"},
+		EmailAddresses: []string{"admin@csr.test"},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := RequestFromCSR(csr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Subject.CommonName != "csr.test" || len(req.DNSNames) != 1 || len(req.EmailAddresses) != 1 {
+		t.Errorf("request: %+v", req)
+	}
+	if !key.PublicKey.Equal(req.PublicKey) {
+		t.Error("public key mismatch")
+	}
+
+	// 署名を改ざんしたCSRはエラーです
+	csr.Signature[len(csr.Signature)-1] ^= 0xff
+	if _, err := RequestFromCSR(csr); err == nil {
+		t.Error("invalid signature must be an error")
+	}
+}
+
+func TestKeyTypeOf(t *testing.T) {
+	tests := []struct {
+		keyType string
+		bits    int
+	}{
+		{keyType: KeyTypeRSA, bits: 2048},
+		{keyType: KeyTypeECDSAP256},
+		{keyType: KeyTypeECDSAP384},
+		{keyType: KeyTypeECDSAP521},
+		{keyType: KeyTypeEd25519},
+	}
+	for _, tt := range tests {
+		t.Run(tt.keyType, func(t *testing.T) {
+			key, err := GenerateKey(tt.keyType, tt.bits)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keyType, bits, err := KeyTypeOf(key.Public())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if keyType != tt.keyType || bits != tt.bits {
+				t.Errorf("got %s %d", keyType, bits)
+			}
+			// PEMに変換して読み込めること
+			block, err := MarshalPrivateKey(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := ParsePrivateKey(block)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !parsed.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(key.Public()) {
+				t.Error("parsed key mismatch")
+			}
+		})
+	}
+	if _, _, err := KeyTypeOf("key"); err == nil {
+		t.Error("unsupported key must be an error")
+	}
+	if _, err := GenerateKey("dsa", 0); err == nil {
+		t.Error("unsupported key type must be an error")
+	}
+}
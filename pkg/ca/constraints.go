@@ -0,0 +1,158 @@
+package ca
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// NameConstraints はCA証明書の名前制約(RFC 5280 4.2.1.10)です。
+// Permitted を指定した種類の名前は、いずれかに一致する場合のみ発行できます。Excluded に一致する名前は発行できません。
+// DNS名、emailのドメイン、URIのホストは、一致するドメインとそのサブドメインに一致します。
+// 先頭が "." のドメインはサブドメインのみに一致します。
+type NameConstraints struct {
+	Critical                bool
+	PermittedDNSDomains     []string
+	ExcludedDNSDomains      []string
+	PermittedIPRanges       []*net.IPNet
+	ExcludedIPRanges        []*net.IPNet
+	PermittedEmailAddresses []string
+	ExcludedEmailAddresses  []string
+	PermittedURIDomains     []string
+	ExcludedURIDomains      []string
+}
+
+func (nc *NameConstraints) apply(tpl *x509.Certificate) {
+	tpl.PermittedDNSDomainsCritical = nc.Critical
+	tpl.PermittedDNSDomains = nc.PermittedDNSDomains
+	tpl.ExcludedDNSDomains = nc.ExcludedDNSDomains
+	tpl.PermittedIPRanges = nc.PermittedIPRanges
+	tpl.ExcludedIPRanges = nc.ExcludedIPRanges
+	tpl.PermittedEmailAddresses = nc.PermittedEmailAddresses
+	tpl.ExcludedEmailAddresses = nc.ExcludedEmailAddresses
+	tpl.PermittedURIDomains = nc.PermittedURIDomains
+	tpl.ExcludedURIDomains = nc.ExcludedURIDomains
+}
+
+// NameConstraintError は発行する証明書のSANがCA証明書の名前制約に違反する場合のエラーです。
+type NameConstraintError struct {
+	message string
+}
+
+func (e *NameConstraintError) Error() string {
+	return e.message
+}
+
+func nameConstraintError(format string, a ...interface{}) error {
+	return &NameConstraintError{message: fmt.Sprintf(format, a...)}
+}
+
+// checkNameConstraints は tpl のSANが、CA証明書と上位のCA証明書の名前制約に違反しないことを確認します。
+func (ca *CA) checkNameConstraints(tpl *x509.Certificate) error {
+	for _, caCert := range append([]*x509.Certificate{ca.Certificate}, ca.Chain...) {
+		for _, name := range tpl.DNSNames {
+			if err := checkDomain("dns name", name, caCert.PermittedDNSDomains, caCert.ExcludedDNSDomains); err != nil {
+				return err
+			}
+		}
+		for _, ip := range tpl.IPAddresses {
+			if err := checkIP(ip, caCert.PermittedIPRanges, caCert.ExcludedIPRanges); err != nil {
+				return err
+			}
+		}
+		for _, email := range tpl.EmailAddresses {
+			if err := checkEmail(email, caCert.PermittedEmailAddresses, caCert.ExcludedEmailAddresses); err != nil {
+				return err
+			}
+		}
+		for _, u := range tpl.URIs {
+			host := u.Hostname()
+			if host == "" || net.ParseIP(host) != nil {
+				if len(caCert.PermittedURIDomains) > 0 || len(caCert.ExcludedURIDomains) > 0 {
+					return nameConstraintError("uri %s can not be checked against the name constraints", u)
+				}
+				continue
+			}
+			if err := checkDomain("uri", host, caCert.PermittedURIDomains, caCert.ExcludedURIDomains); err != nil {
+				return fmt.Errorf("%w (%s)", err, u)
+			}
+		}
+	}
+	return nil
+}
+
+func checkDomain(kind, name string, permitted, excluded []string) error {
+	for _, constraint := range excluded {
+		if matchDomain(name, constraint) {
+			return nameConstraintError("%s %s is excluded by the ca name constraints", kind, name)
+		}
+	}
+	if len(permitted) == 0 {
+		return nil
+	}
+	for _, constraint := range permitted {
+		if matchDomain(name, constraint) {
+			return nil
+		}
+	}
+	return nameConstraintError("%s %s is not permitted by the ca name constraints", kind, name)
+}
+
+func checkIP(ip net.IP, permitted, excluded []*net.IPNet) error {
+	for _, ipNet := range excluded {
+		if ipNet.Contains(ip) {
+			return nameConstraintError("ip address %s is excluded by the ca name constraints", ip)
+		}
+	}
+	if len(permitted) == 0 {
+		return nil
+	}
+	for _, ipNet := range permitted {
+		if ipNet.Contains(ip) {
+			return nil
+		}
+	}
+	return nameConstraintError("ip address %s is not permitted by the ca name constraints", ip)
+}
+
+func checkEmail(email string, permitted, excluded []string) error {
+	for _, constraint := range excluded {
+		if matchEmail(email, constraint) {
+			return nameConstraintError("email address %s is excluded by the ca name constraints", email)
+		}
+	}
+	if len(permitted) == 0 {
+		return nil
+	}
+	for _, constraint := range permitted {
+		if matchEmail(email, constraint) {
+			return nil
+		}
+	}
+	return nameConstraintError("email address %s is not permitted by the ca name constraints", email)
+}
+
+// matchEmail は constraint に "@" を含む場合はメールアドレス全体、含まない場合はドメインで比較します。
+func matchEmail(email, constraint string) bool {
+	if strings.Contains(constraint, "@") {
+		return strings.EqualFold(email, constraint)
+	}
+	i := strings.LastIndex(email, "@")
+	if i < 0 {
+		return false
+	}
+	return matchDomain(email[i+1:], constraint)
+}
+
+func matchDomain(name, constraint string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	constraint = strings.ToLower(constraint)
+	if constraint == "" {
+		return true
+	}
+	if strings.HasPrefix(constraint, ".") {
+		return strings.HasSuffix(name, constraint)
+	}
+	return name == constraint || strings.HasSuffix(name, "."+constraint)
+}
@@ -0,0 +1,172 @@
+package ca
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestMatchDomain(t *testing.T) {
+	tests := []struct {
+		name       string
+		constraint string
+		want       bool
+	}{
+		{name: "example.com", constraint: "example.com", want: true},
+		{name: "www.example.com", constraint: "example.com", want: true},
+		{name: "a.b.example.com", constraint: "example.com", want: true},
+		{name: "WWW.Example.COM.", constraint: "example.com", want: true},
+		{name: "www.example.com", constraint: "EXAMPLE.com", want: true},
+		{name: "badexample.com", constraint: "example.com", want: false},
+		{name: "example.com.evil", constraint: "example.com", want: false},
+		// 先頭が "." の場合はサブドメインのみに一致します
+		{name: "example.com", constraint: ".example.com", want: false},
+		{name: "www.example.com", constraint: ".example.com", want: true},
+		{name: "badexample.com", constraint: ".example.com", want: false},
+		{name: "anything.test", constraint: "", want: true},
+	}
+	for _, tt := range tests {
+		if got := matchDomain(tt.name, tt.constraint); got != tt.want {
+			t.Errorf("matchDomain(%q, %q) = %v, want %v", tt.name, tt.constraint, got, tt.want)
+		}
+	}
+}
+
+func TestMatchEmail(t *testing.T) {
+	tests := []struct {
+		email      string
+		constraint string
+		want       bool
+	}{
+		{email: "admin@example.com", constraint: "admin@example.com", want: true},
+		{email: "Admin@Example.com", constraint: "admin@example.com", want: true},
+		{email: "root@example.com", constraint: "admin@example.com", want: false},
+		{email: "admin@example.com", constraint: "example.com", want: true},
+		{email: "admin@mail.example.com", constraint: "example.com", want: true},
+		{email: "admin@example.com", constraint: ".example.com", want: false},
+		{email: "admin@mail.example.com", constraint: ".example.com", want: true},
+		{email: "admin@evil.com", constraint: "example.com", want: false},
+		{email: "example.com", constraint: "example.com", want: false},
+	}
+	for _, tt := range tests {
+		if got := matchEmail(tt.email, tt.constraint); got != tt.want {
+			t.Errorf("matchEmail(%q, %q) = %v, want %v", tt.email, tt.constraint, got, tt.want)
+		}
+	}
+}
+
+func TestCheckIP(t *testing.T) {
+	_, private, _ := net.ParseCIDR("10.0.0.0/8")
+	_, excluded, _ := net.ParseCIDR("10.1.0.0/16")
+	_, v6, _ := net.ParseCIDR("fd00::/8")
+	tests := []struct {
+		ip        string
+		permitted []*net.IPNet
+		excluded  []*net.IPNet
+		err       string
+	}{
+		{ip: "192.0.2.1"},
+		{ip: "10.2.3.4", permitted: []*net.IPNet{private}},
+		{ip: "192.0.2.1", permitted: []*net.IPNet{private}, err: "not permitted"},
+		{ip: "10.1.2.3", permitted: []*net.IPNet{private}, excluded: []*net.IPNet{excluded}, err: "excluded"},
+		{ip: "10.1.2.3", excluded: []*net.IPNet{excluded}, err: "excluded"},
+		{ip: "fd00::1", permitted: []*net.IPNet{private, v6}},
+		{ip: "2001:db8::1", permitted: []*net.IPNet{private, v6}, err: "not permitted"},
+	}
+	for _, tt := range tests {
+		err := checkIP(net.ParseIP(tt.ip), tt.permitted, tt.excluded)
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tt.ip, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got %v, want error containing %q", tt.ip, err, tt.err)
+		}
+	}
+}
+
+func TestCheckNameConstraints(t *testing.T) {
+	_, lan, _ := net.ParseCIDR("192.168.0.0/16")
+	root := newTestCA(t, &IssueRequest{
+		Subject: pkix.Name{CommonName: "Test Root"},
+		NameConstraints: NameConstraints{
+			Critical:                true,
+			PermittedDNSDomains:     []string{"example.com", ".test"},
+			ExcludedDNSDomains:      []string{"secret.example.com"},
+			PermittedIPRanges:       []*net.IPNet{lan},
+			PermittedEmailAddresses: []string{"example.com"},
+			PermittedURIDomains:     []string{".example.com"},
+		},
+	})
+	// 中間CAの制約は上位のCAの制約に追加されます
+	inter, err := root.NewIntermediate(&IssueRequest{
+		Subject:         pkix.Name{CommonName: "Test Intermediate"},
+		KeyType:         KeyTypeECDSAP256,
+		NameConstraints: NameConstraints{ExcludedDNSDomains: []string{"dev.test"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustURL := func(s string) *url.URL {
+		u, err := url.Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+	tests := []struct {
+		name string
+		tpl  x509.Certificate
+		err  string
+	}{
+		{name: "permitted dns", tpl: x509.Certificate{DNSNames: []string{"example.com", "www.example.com", "app.test"}}},
+		{name: "leading dot", tpl: x509.Certificate{DNSNames: []string{"test"}}, err: "dns name test is not permitted"},
+		{name: "not permitted dns", tpl: x509.Certificate{DNSNames: []string{"evil.com"}}, err: "dns name evil.com is not permitted"},
+		{name: "excluded dns", tpl: x509.Certificate{DNSNames: []string{"a.secret.example.com"}}, err: "excluded"},
+		{name: "excluded by intermediate", tpl: x509.Certificate{DNSNames: []string{"www.dev.test"}}, err: "dns name www.dev.test is excluded"},
+		{name: "permitted ip", tpl: x509.Certificate{IPAddresses: []net.IP{net.ParseIP("192.168.1.1")}}},
+		{name: "not permitted ip", tpl: x509.Certificate{IPAddresses: []net.IP{net.ParseIP("127.0.0.1")}}, err: "ip address 127.0.0.1 is not permitted"},
+		{name: "permitted email", tpl: x509.Certificate{EmailAddresses: []string{"admin@mail.example.com"}}},
+		{name: "not permitted email", tpl: x509.Certificate{EmailAddresses: []string{"admin@evil.com"}}, err: "email address admin@evil.com is not permitted"},
+		{name: "permitted uri", tpl: x509.Certificate{URIs: []*url.URL{mustURL("https://api.example.com:8443/v1")}}},
+		{name: "uri leading dot", tpl: x509.Certificate{URIs: []*url.URL{mustURL("https://example.com/")}}, err: "uri example.com is not permitted"},
+		{name: "uri ip", tpl: x509.Certificate{URIs: []*url.URL{mustURL("https://192.168.1.1/")}}, err: "can not be checked"},
+		{name: "uri without host", tpl: x509.Certificate{URIs: []*url.URL{mustURL("urn:uuid:1234")}}, err: "can not be checked"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := inter.checkNameConstraints(&tt.tpl)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				// 検査を通る証明書は x509.Verify でも検証できます
+				c, err := inter.Issue(&IssueRequest{
+					KeyType:        KeyTypeECDSAP256,
+					DNSNames:       tt.tpl.DNSNames,
+					IPAddresses:    tt.tpl.IPAddresses,
+					EmailAddresses: tt.tpl.EmailAddresses,
+					URIs:           tt.tpl.URIs,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := verify(t, c.Certificate, root.Certificate, []*x509.Certificate{inter.Certificate}, x509.ExtKeyUsageAny); err != nil {
+					t.Error(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got %v, want error containing %q", err, tt.err)
+			}
+			if _, err := inter.Issue(&IssueRequest{KeyType: KeyTypeECDSAP256, DNSNames: tt.tpl.DNSNames, IPAddresses: tt.tpl.IPAddresses, EmailAddresses: tt.tpl.EmailAddresses, URIs: tt.tpl.URIs}); err == nil {
+				t.Error("Issue must reject the certificate")
+			}
+		})
+	}
+}
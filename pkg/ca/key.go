@@ -0,0 +1,124 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// 鍵の種類
+const (
+	KeyTypeRSA       = "rsa"
+	KeyTypeECDSAP256 = "ecdsa-p256"
+	KeyTypeECDSAP384 = "ecdsa-p384"
+	KeyTypeECDSAP521 = "ecdsa-p521"
+	KeyTypeEd25519   = "ed25519"
+)
+
+// GenerateKey は keyType の秘密鍵を生成します。bits は RSA の場合のみ使用します。
+func GenerateKey(keyType string, bits int) (crypto.Signer, error) {
+	switch keyType {
+	case KeyTypeRSA, "":
+		return rsa.GenerateKey(rand.Reader, bits)
+	case KeyTypeECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyTypeECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KeyTypeECDSAP521:
+		return ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case KeyTypeEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", keyType)
+	}
+}
+
+// MarshalPrivateKey は秘密鍵をPEMブロックに変換します。
+// RSA は PKCS#1、ECDSA は SEC1、Ed25519 は PKCS#8 形式です。
+func MarshalPrivateKey(key crypto.Signer) (*pem.Block, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}, nil
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		return &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}, nil
+	case ed25519.PrivateKey:
+		der, err := x509.MarshalPKCS8PrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		return &pem.Block{Type: "PRIVATE KEY", Bytes: der}, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key %T", key)
+	}
+}
+
+// ParsePrivateKey はPEMブロックから秘密鍵を読み込みます。
+// 暗号化された秘密鍵の場合は ErrEncryptedPrivateKey を返します(DecryptPrivateKey で復号してください)。
+func ParsePrivateKey(block *pem.Block) (crypto.Signer, error) {
+	switch block.Type {
+	case EncryptedPrivateKeyType:
+		return nil, ErrEncryptedPrivateKey
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		keyInterface, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := keyInterface.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("unsupported private key")
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("invalid private key type %s", block.Type)
+	}
+}
+
+// ParsePrivateKeyPEM はPEMデータの最初のブロックから秘密鍵を読み込みます。
+func ParsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid private key data")
+	}
+	return ParsePrivateKey(block)
+}
+
+// KeyTypeOf は公開鍵の鍵の種類と、RSA の場合は鍵長を返します。
+func KeyTypeOf(pub crypto.PublicKey) (string, int, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return KeyTypeRSA, k.N.BitLen(), nil
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return KeyTypeECDSAP256, 0, nil
+		case elliptic.P384():
+			return KeyTypeECDSAP384, 0, nil
+		case elliptic.P521():
+			return KeyTypeECDSAP521, 0, nil
+		}
+		return "", 0, fmt.Errorf("unsupported curve %s", k.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return KeyTypeEd25519, 0, nil
+	default:
+		return "", 0, fmt.Errorf("unsupported public key %T", pub)
+	}
+}
@@ -0,0 +1,308 @@
+package ca
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// 秘密鍵の暗号化で使用する鍵導出関数
+const (
+	KDFScrypt = "scrypt"
+	KDFPBKDF2 = "pbkdf2"
+)
+
+// EncryptedPrivateKeyType は暗号化したPKCS#8形式の秘密鍵のPEMブロックの種類です。
+const EncryptedPrivateKeyType = "ENCRYPTED PRIVATE KEY"
+
+// ErrEncryptedPrivateKey は暗号化された秘密鍵をパスフレーズなしで読み込もうとした場合のエラーです。
+var ErrEncryptedPrivateKey = errors.New("private key is encrypted")
+
+// ErrIncorrectPassphrase はパスフレーズが誤っているため秘密鍵を復号できない場合のエラーです。
+var ErrIncorrectPassphrase = errors.New("incorrect passphrase")
+
+const (
+	scryptN          = 1 << 14
+	scryptR          = 8
+	scryptP          = 1
+	pbkdf2Iterations = 600000
+	saltSize         = 16
+)
+
+// 復号時に受け付ける鍵導出関数のパラメータの上限です。
+// 細工したファイルで大量のメモリやCPU時間を消費しないよう制限します。
+const (
+	maxScryptN          = 1 << 20
+	maxScryptMemory     = 1 << 30 // 128 * N * r
+	maxScryptRP         = 1 << 6
+	maxPBKDF2Iterations = 10000000
+)
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidScrypt         = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11591, 4, 11}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+type scryptParams struct {
+	Salt                     []byte
+	CostParameter            int
+	BlockSize                int
+	ParallelizationParameter int
+	KeyLength                int `asn1:"optional"`
+}
+
+// EncryptPrivateKey は秘密鍵をPKCS#8形式に変換し、PBES2(AES-256-CBC)で暗号化したPEMブロックを返します。
+// kdf は鍵導出関数で、KDFScrypt(既定値)または KDFPBKDF2(HMAC-SHA256)です。
+func EncryptPrivateKey(key crypto.Signer, passphrase []byte, kdf string) (*pem.Block, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+
+	var keyDerivationFunc pkix.AlgorithmIdentifier
+	var encryptionKey []byte
+	switch kdf {
+	case KDFScrypt, "":
+		params, err := asn1.Marshal(scryptParams{
+			Salt:                     salt,
+			CostParameter:            scryptN,
+			BlockSize:                scryptR,
+			ParallelizationParameter: scryptP,
+			KeyLength:                32,
+		})
+		if err != nil {
+			return nil, err
+		}
+		keyDerivationFunc = pkix.AlgorithmIdentifier{Algorithm: oidScrypt, Parameters: asn1.RawValue{FullBytes: params}}
+		encryptionKey, err = scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, 32)
+		if err != nil {
+			return nil, err
+		}
+	case KDFPBKDF2:
+		params, err := asn1.Marshal(pbkdf2Params{
+			Salt:           salt,
+			IterationCount: pbkdf2Iterations,
+			PRF:            pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+		})
+		if err != nil {
+			return nil, err
+		}
+		keyDerivationFunc = pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: params}}
+		encryptionKey = pbkdf2.Key(passphrase, salt, pbkdf2Iterations, 32, sha256.New)
+	default:
+		return nil, fmt.Errorf("unsupported key derivation function %s", kdf)
+	}
+
+	ivParams, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: keyDerivationFunc,
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParams}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	padding := aes.BlockSize - len(der)%aes.BlockSize
+	plaintext := make([]byte, len(der)+padding)
+	copy(plaintext, der)
+	for i := len(der); i < len(plaintext); i++ {
+		plaintext[i] = byte(padding)
+	}
+	encrypted := make([]byte, len(plaintext))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, plaintext)
+
+	info, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedData: encrypted,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pem.Block{Type: EncryptedPrivateKeyType, Bytes: info}, nil
+}
+
+// DecryptPrivateKey はPBES2で暗号化したPKCS#8形式の秘密鍵を復号します。
+// 鍵導出関数は scrypt と PBKDF2(HMAC-SHA1/SHA256/SHA384/SHA512)、暗号化方式は AES-CBC に対応しています。
+func DecryptPrivateKey(block *pem.Block, passphrase []byte) (crypto.Signer, error) {
+	if block.Type != EncryptedPrivateKeyType {
+		return nil, fmt.Errorf("invalid encrypted private key type %s", block.Type)
+	}
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(block.Bytes, &info); err != nil {
+		return nil, err
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported encryption algorithm %s", info.Algorithm.Algorithm)
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, err
+	}
+
+	var keySize int
+	switch {
+	case params.EncryptionScheme.Algorithm.Equal(oidAES128CBC):
+		keySize = 16
+	case params.EncryptionScheme.Algorithm.Equal(oidAES192CBC):
+		keySize = 24
+	case params.EncryptionScheme.Algorithm.Equal(oidAES256CBC):
+		keySize = 32
+	default:
+		return nil, fmt.Errorf("unsupported encryption scheme %s", params.EncryptionScheme.Algorithm)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, err
+	}
+	if len(iv) != aes.BlockSize {
+		return nil, errors.New("invalid iv length")
+	}
+
+	var encryptionKey []byte
+	switch {
+	case params.KeyDerivationFunc.Algorithm.Equal(oidScrypt):
+		var kdfParams scryptParams
+		if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdfParams); err != nil {
+			return nil, err
+		}
+		if err := checkKeyLength(kdfParams.KeyLength, keySize); err != nil {
+			return nil, err
+		}
+		n, r, p := kdfParams.CostParameter, kdfParams.BlockSize, kdfParams.ParallelizationParameter
+		if n <= 1 || n > maxScryptN || n&(n-1) != 0 || r <= 0 || p <= 0 || r > maxScryptRP || p > maxScryptRP ||
+			r*p > maxScryptRP || 128*int64(n)*int64(r) > maxScryptMemory {
+			return nil, fmt.Errorf("unsupported scrypt parameters N=%d r=%d p=%d", n, r, p)
+		}
+		var err error
+		encryptionKey, err = scrypt.Key(passphrase, kdfParams.Salt, kdfParams.CostParameter, kdfParams.BlockSize, kdfParams.ParallelizationParameter, keySize)
+		if err != nil {
+			return nil, err
+		}
+	case params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2):
+		var kdfParams pbkdf2Params
+		if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdfParams); err != nil {
+			return nil, err
+		}
+		if err := checkKeyLength(kdfParams.KeyLength, keySize); err != nil {
+			return nil, err
+		}
+		if kdfParams.IterationCount <= 0 || kdfParams.IterationCount > maxPBKDF2Iterations {
+			return nil, fmt.Errorf("unsupported pbkdf2 iteration count %d", kdfParams.IterationCount)
+		}
+		var h func() hash.Hash
+		switch prf := kdfParams.PRF.Algorithm; {
+		case len(prf) == 0, prf.Equal(oidHMACWithSHA1):
+			h = sha1.New
+		case prf.Equal(oidHMACWithSHA256):
+			h = sha256.New
+		case prf.Equal(oidHMACWithSHA384):
+			h = sha512.New384
+		case prf.Equal(oidHMACWithSHA512):
+			h = sha512.New
+		default:
+			return nil, fmt.Errorf("unsupported pbkdf2 prf %s", prf)
+		}
+		encryptionKey = pbkdf2.Key(passphrase, kdfParams.Salt, kdfParams.IterationCount, keySize, h)
+	default:
+		return nil, fmt.Errorf("unsupported key derivation function %s", params.KeyDerivationFunc.Algorithm)
+	}
+
+	cipherBlock, err := aes.NewCipher(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(info.EncryptedData) == 0 || len(info.EncryptedData)%aes.BlockSize != 0 {
+		return nil, errors.New("invalid encrypted data length")
+	}
+	plaintext := make([]byte, len(info.EncryptedData))
+	cipher.NewCBCDecrypter(cipherBlock, iv).CryptBlocks(plaintext, info.EncryptedData)
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize || subtle.ConstantTimeCompare(plaintext[len(plaintext)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) != 1 {
+		return nil, ErrIncorrectPassphrase
+	}
+	keyInterface, err := x509.ParsePKCS8PrivateKey(plaintext[:len(plaintext)-padding])
+	if err != nil {
+		return nil, ErrIncorrectPassphrase
+	}
+	key, ok := keyInterface.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported private key")
+	}
+	return key, nil
+}
+
+// checkKeyLength は鍵導出関数のパラメータの鍵長(省略可能)が暗号化方式の鍵長と一致するか確認します。
+func checkKeyLength(keyLength, keySize int) error {
+	if keyLength != 0 && keyLength != keySize {
+		return fmt.Errorf("key length %d does not match the encryption scheme", keyLength)
+	}
+	return nil
+}
+
+// ParseEncryptedPrivateKeyPEM はPEMデータの最初のブロックから秘密鍵を読み込みます。
+// 暗号化されている場合は passphrase で復号します。
+func ParseEncryptedPrivateKeyPEM(data, passphrase []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid private key data")
+	}
+	if block.Type == EncryptedPrivateKeyType {
+		return DecryptPrivateKey(block, passphrase)
+	}
+	return ParsePrivateKey(block)
+}
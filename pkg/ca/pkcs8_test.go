@@ -0,0 +1,189 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncryptPrivateKey(t *testing.T) {
+	tests := []struct {
+		kdf     string
+		keyType string
+	}{
+		{kdf: KDFScrypt, keyType: KeyTypeECDSAP256},
+		{kdf: "", keyType: KeyTypeEd25519},
+		{kdf: KDFPBKDF2, keyType: KeyTypeECDSAP384},
+		{kdf: KDFPBKDF2, keyType: KeyTypeRSA},
+	}
+	for _, tt := range tests {
+		t.Run(tt.kdf+"/"+tt.keyType, func(t *testing.T) {
+			key, err := GenerateKey(tt.keyType, 2048)
+			if err != nil {
+				t.Fatal(err)
+			}
+			block, err := EncryptPrivateKey(key, []byte("secret"), tt.kdf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if block.Type != EncryptedPrivateKeyType {
+				t.Errorf("type: %s", block.Type)
+			}
+			data := pem.EncodeToMemory(block)
+			if _, err := ParsePrivateKeyPEM(data); !errors.Is(err, ErrEncryptedPrivateKey) {
+				t.Errorf("parse without passphrase: %v", err)
+			}
+			decrypted, err := ParseEncryptedPrivateKeyPEM(data, []byte("secret"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !decrypted.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(key.Public()) {
+				t.Error("decrypted key mismatch")
+			}
+			if _, err := DecryptPrivateKey(block, []byte("wrong")); !errors.Is(err, ErrIncorrectPassphrase) {
+				t.Errorf("wrong passphrase: %v", err)
+			}
+		})
+	}
+}
+
+func TestEncryptPrivateKeyError(t *testing.T) {
+	key, err := GenerateKey(KeyTypeECDSAP256, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := EncryptPrivateKey(key, []byte("secret"), "bcrypt"); err == nil {
+		t.Error("unsupported kdf must be an error")
+	}
+	block, err := MarshalPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecryptPrivateKey(block, []byte("secret")); err == nil {
+		t.Error("unencrypted block must be an error")
+	}
+	// 暗号化されていない鍵はパスフレーズを指定しても読み込めます
+	if _, err := ParseEncryptedPrivateKeyPEM(pem.EncodeToMemory(block), []byte("secret")); err != nil {
+		t.Error(err)
+	}
+}
+
+// testdata の暗号化された秘密鍵は次のコマンドで作成しました(パスフレーズは secret)。
+//
+//	openssl genpkey -algorithm EC -pkeyopt ec_paramgen_curve:P-256 -out openssl-key.pem
+//	openssl pkcs8 -topk8 -in openssl-key.pem -v2 aes-256-cbc -passout pass:secret -out openssl-pbkdf2-sha256-aes256.pem
+//	openssl pkcs8 -topk8 -in openssl-key.pem -v2 aes-128-cbc -v2prf hmacWithSHA1 -iter 2048 -passout pass:secret -out openssl-pbkdf2-sha1-aes128.pem
+//	openssl pkcs8 -topk8 -in openssl-key.pem -scrypt -scrypt_N 1024 -scrypt_r 8 -scrypt_p 1 -passout pass:secret -out openssl-scrypt-aes256.pem
+func TestDecryptOpenSSLPrivateKey(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "openssl-key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := ParsePrivateKeyPEM(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"openssl-pbkdf2-sha256-aes256.pem", "openssl-pbkdf2-sha1-aes128.pem", "openssl-scrypt-aes256.pem"} {
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			key, err := ParseEncryptedPrivateKeyPEM(data, []byte("secret"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !key.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(want.Public()) {
+				t.Error("decrypted key mismatch")
+			}
+			if _, err := ParseEncryptedPrivateKeyPEM(data, []byte("Secret")); !errors.Is(err, ErrIncorrectPassphrase) {
+				t.Errorf("wrong passphrase: %v", err)
+			}
+		})
+	}
+}
+
+// rewriteKDFParams は暗号化した秘密鍵の鍵導出関数のパラメータを書き換えます。
+func rewriteKDFParams(t *testing.T, block *pem.Block, params interface{}) *pem.Block {
+	t.Helper()
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(block.Bytes, &info); err != nil {
+		t.Fatal(err)
+	}
+	var pbes2 pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &pbes2); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	if pbes2.KeyDerivationFunc.Parameters.FullBytes, err = asn1.Marshal(params); err != nil {
+		t.Fatal(err)
+	}
+	if info.Algorithm.Parameters.FullBytes, err = asn1.Marshal(pbes2); err != nil {
+		t.Fatal(err)
+	}
+	der, err := asn1.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &pem.Block{Type: block.Type, Bytes: der}
+}
+
+// TestDecryptPrivateKeyLimits は鍵導出関数のパラメータが上限を超える場合に、鍵を導出せずにエラーにすることを確認します。
+func TestDecryptPrivateKeyLimits(t *testing.T) {
+	key, err := GenerateKey(KeyTypeECDSAP256, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scryptBlock, err := EncryptPrivateKey(key, []byte("secret"), KDFScrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pbkdf2Block, err := EncryptPrivateKey(key, []byte("secret"), KDFPBKDF2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	salt := []byte("0123456789abcdef")
+	prf := pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue}
+
+	tests := []struct {
+		name   string
+		block  *pem.Block
+		params interface{}
+		err    string
+	}{
+		{name: "scrypt", block: scryptBlock, params: scryptParams{Salt: salt, CostParameter: scryptN, BlockSize: scryptR, ParallelizationParameter: scryptP}},
+		{name: "scrypt key length", block: scryptBlock, params: scryptParams{Salt: salt, CostParameter: scryptN, BlockSize: scryptR, ParallelizationParameter: scryptP, KeyLength: 32}},
+		{name: "scrypt N too large", block: scryptBlock, params: scryptParams{Salt: salt, CostParameter: 1 << 30, BlockSize: 8, ParallelizationParameter: 1}, err: "unsupported scrypt parameters"},
+		{name: "scrypt N not power of 2", block: scryptBlock, params: scryptParams{Salt: salt, CostParameter: 1000, BlockSize: 8, ParallelizationParameter: 1}, err: "unsupported scrypt parameters"},
+		{name: "scrypt memory", block: scryptBlock, params: scryptParams{Salt: salt, CostParameter: 1 << 20, BlockSize: 16, ParallelizationParameter: 1}, err: "unsupported scrypt parameters"},
+		{name: "scrypt r*p", block: scryptBlock, params: scryptParams{Salt: salt, CostParameter: 1 << 10, BlockSize: 8, ParallelizationParameter: 1 << 20}, err: "unsupported scrypt parameters"},
+		{name: "scrypt zero", block: scryptBlock, params: scryptParams{Salt: salt, CostParameter: 1 << 10, BlockSize: 0, ParallelizationParameter: 1}, err: "unsupported scrypt parameters"},
+		{name: "scrypt key length mismatch", block: scryptBlock, params: scryptParams{Salt: salt, CostParameter: scryptN, BlockSize: scryptR, ParallelizationParameter: scryptP, KeyLength: 16}, err: "key length 16"},
+		{name: "pbkdf2", block: pbkdf2Block, params: pbkdf2Params{Salt: salt, IterationCount: 1000, PRF: prf}},
+		{name: "pbkdf2 key length", block: pbkdf2Block, params: pbkdf2Params{Salt: salt, IterationCount: 1000, KeyLength: 32, PRF: prf}},
+		{name: "pbkdf2 iterations too large", block: pbkdf2Block, params: pbkdf2Params{Salt: salt, IterationCount: 1 << 30, PRF: prf}, err: "unsupported pbkdf2 iteration count"},
+		{name: "pbkdf2 zero iterations", block: pbkdf2Block, params: pbkdf2Params{Salt: salt, IterationCount: 0, PRF: prf}, err: "unsupported pbkdf2 iteration count"},
+		{name: "pbkdf2 key length mismatch", block: pbkdf2Block, params: pbkdf2Params{Salt: salt, IterationCount: 1000, KeyLength: 1 << 30, PRF: prf}, err: "does not match the encryption scheme"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecryptPrivateKey(rewriteKDFParams(t, tt.block, tt.params), []byte("secret"))
+			if tt.err == "" {
+				// saltを書き換えているため、パラメータが正しければパスフレーズの誤りになります
+				if !errors.Is(err, ErrIncorrectPassphrase) {
+					t.Errorf("got %v, want incorrect passphrase", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got %v, want error containing %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+// Package ssctest はGoのテスト用に、使い捨てのCAと証明書をメモリ上で作成します。
+//
+// 証明書と秘密鍵は t.TempDir() 以下のファイルにも書き出すため、ファイル名を受け取る
+// サーバーやクライアントにもそのまま渡せます。ファイルはテスト終了時に削除されます。
+//
+//	authority := ssctest.NewCA(t)
+//	server := httptest.NewUnstartedServer(handler)
+//	server.TLS = authority.IssueServer(t).ServerConfig()
+//	server.StartTLS()
+//	client := &http.Client{Transport: &http.Transport{TLSClientConfig: authority.ClientConfig()}}
+package ssctest
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/n-creativesystem/self-signed-certificate/pkg/ca"
+)
+
+// Validity は作成する証明書の有効期間です。
+const Validity = 24 * time.Hour
+
+// CA はテスト用のCAです。
+type CA struct {
+	*ca.CA
+	// CertFile はCA証明書のファイル名です。
+	CertFile string
+	pool     *x509.CertPool
+}
+
+// Cert はテスト用CAが発行した証明書です。
+type Cert struct {
+	*ca.Certificate
+	// CertFile、KeyFile、ChainFile は証明書、秘密鍵、証明書チェーン(証明書とCA証明書)のファイル名です。
+	CertFile  string
+	KeyFile   string
+	ChainFile string
+	// TLS は証明書チェーンと秘密鍵の tls.Certificate です。
+	TLS  tls.Certificate
+	pool *x509.CertPool
+}
+
+// NewCA はテスト用の自己署名CAを作成します。
+func NewCA(t testing.TB) *CA {
+	t.Helper()
+	authority, err := ca.New(&ca.IssueRequest{
+		Subject:   pkix.Name{CommonName: "ssctest CA " + t.Name()},
+		KeyType:   ca.KeyTypeECDSAP256,
+		NotBefore: time.Now().Add(-time.Minute),
+		Validity:  Validity,
+	})
+	if err != nil {
+		t.Fatalf("ssctest: create ca: %v", err)
+	}
+	c := &CA{CA: authority, pool: x509.NewCertPool()}
+	c.pool.AddCert(authority.Certificate)
+	c.CertFile = writeFile(t, t.TempDir(), "ca.crt", authority.CertificatePEM())
+	return c
+}
+
+// CertPool はCA証明書を含む x509.CertPool を返します。
+func (c *CA) CertPool() *x509.CertPool {
+	return c.pool
+}
+
+// ClientConfig はCA証明書でサーバー証明書を検証するクライアント側の tls.Config を返します。
+func (c *CA) ClientConfig() *tls.Config {
+	return &tls.Config{RootCAs: c.pool}
+}
+
+// IssueServer は hosts(DNS名またはIPアドレス)のサーバー証明書を発行します。
+// hosts を指定しない場合は localhost、127.0.0.1、::1 です。
+func (c *CA) IssueServer(t testing.TB, hosts ...string) *Cert {
+	t.Helper()
+	if len(hosts) == 0 {
+		hosts = []string{"localhost", "127.0.0.1", "::1"}
+	}
+	req := &ca.IssueRequest{
+		Subject:     pkix.Name{CommonName: hosts[0]},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			req.IPAddresses = append(req.IPAddresses, ip)
+		} else {
+			req.DNSNames = append(req.DNSNames, host)
+		}
+	}
+	return c.issue(t, "server", req)
+}
+
+// IssueClient は name をCNとするクライアント証明書を発行します。
+func (c *CA) IssueClient(t testing.TB, name string) *Cert {
+	t.Helper()
+	return c.issue(t, "client", &ca.IssueRequest{
+		Subject:     pkix.Name{CommonName: name},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+}
+
+// IssueCert は req の内容で証明書を発行します。鍵の種類と有効期間を指定しない場合は ECDSA P-256、Validity です。
+func (c *CA) IssueCert(t testing.TB, req *ca.IssueRequest) *Cert {
+	t.Helper()
+	return c.issue(t, "cert", req)
+}
+
+func (c *CA) issue(t testing.TB, name string, req *ca.IssueRequest) *Cert {
+	t.Helper()
+	if req.KeyType == "" && req.PublicKey == nil {
+		req.KeyType = ca.KeyTypeECDSAP256
+	}
+	if req.NotBefore.IsZero() {
+		req.NotBefore = time.Now().Add(-time.Minute)
+	}
+	if req.Validity == 0 {
+		req.Validity = Validity
+	}
+	issued, err := c.CA.Issue(req)
+	if err != nil {
+		t.Fatalf("ssctest: issue %s certificate: %v", name, err)
+	}
+	cert := &Cert{Certificate: issued, pool: c.pool}
+	dir := t.TempDir()
+	cert.CertFile = writeFile(t, dir, name+".crt", issued.CertificatePEM())
+	cert.ChainFile = writeFile(t, dir, name+"-chain.crt", issued.ChainPEM())
+	if issued.PrivateKey != nil {
+		keyPEM, err := issued.PrivateKeyPEM()
+		if err != nil {
+			t.Fatalf("ssctest: marshal %s private key: %v", name, err)
+		}
+		cert.KeyFile = writeFile(t, dir, name+".key", keyPEM)
+		cert.TLS, err = issued.TLSCertificate()
+		if err != nil {
+			t.Fatalf("ssctest: %v", err)
+		}
+	}
+	return cert
+}
+
+// ServerConfig は証明書をサーバー証明書として使用するサーバー側の tls.Config を返します。
+// クライアント証明書を送られた場合はCA証明書で検証します。
+// 相互TLSを必須にする場合は ClientAuth に tls.RequireAndVerifyClientCert を設定してください。
+func (c *Cert) ServerConfig() *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{c.TLS},
+		ClientCAs:    c.pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	}
+}
+
+// ClientConfig は証明書をクライアント証明書として送り、CA証明書でサーバー証明書を検証するクライアント側の tls.Config を返します。
+func (c *Cert) ClientConfig() *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{c.TLS},
+		RootCAs:      c.pool,
+	}
+}
+
+func writeFile(t testing.TB, dir, name string, data []byte) string {
+	t.Helper()
+	filename := filepath.Join(dir, name)
+	if err := os.WriteFile(filename, data, 0600); err != nil {
+		t.Fatalf("ssctest: %v", err)
+	}
+	return filename
+}
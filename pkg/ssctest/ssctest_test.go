@@ -0,0 +1,122 @@
+package ssctest_test
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/n-creativesystem/self-signed-certificate/pkg/ssctest"
+)
+
+func newServer(t *testing.T, config *tls.Config) *httptest.Server {
+	t.Helper()
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			io.WriteString(w, "anonymous")
+			return
+		}
+		io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	server.TLS = config
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func get(client *http.Client, url string) (string, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func TestTLS(t *testing.T) {
+	authority := ssctest.NewCA(t)
+	server := newServer(t, authority.IssueServer(t).ServerConfig())
+
+	// CA証明書だけを信頼するクライアント
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: authority.ClientConfig()}}
+	body, err := get(client, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "anonymous" {
+		t.Errorf("got %s", body)
+	}
+
+	// クライアント証明書はCA証明書で検証されます
+	alice := authority.IssueClient(t, "alice")
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: alice.ClientConfig()}}
+	body, err = get(client, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "alice" {
+		t.Errorf("got %s", body)
+	}
+
+	// 別のCAを信頼するクライアントはサーバー証明書を検証できません
+	other := ssctest.NewCA(t)
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: other.ClientConfig()}}
+	if _, err := get(client, server.URL); err == nil {
+		t.Error("server certificate from another CA must be rejected")
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	authority := ssctest.NewCA(t)
+	config := authority.IssueServer(t).ServerConfig()
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	server := newServer(t, config)
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: authority.ClientConfig()}}
+	if _, err := get(client, server.URL); err == nil {
+		t.Error("request without a client certificate must be rejected")
+	}
+
+	// 別のCAが発行したクライアント証明書は拒否されます
+	other := ssctest.NewCA(t)
+	mallory := other.IssueClient(t, "mallory")
+	tlsConfig := mallory.ClientConfig()
+	tlsConfig.RootCAs = authority.CertPool()
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	if _, err := get(client, server.URL); err == nil {
+		t.Error("client certificate from another CA must be rejected")
+	}
+
+	// ファイルに書き出した証明書と秘密鍵も使用できます
+	bob := authority.IssueClient(t, "bob")
+	cert, err := tls.LoadX509KeyPair(bob.ChainFile, bob.KeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      authority.CertPool(),
+	}}}
+	body, err := get(client, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "bob" {
+		t.Errorf("got %s", body)
+	}
+}
+
+func TestIssueServerHosts(t *testing.T) {
+	authority := ssctest.NewCA(t)
+	cert := authority.IssueServer(t, "example.test", "192.0.2.1")
+	for _, host := range []string{"example.test", "192.0.2.1"} {
+		if err := cert.Certificate.Certificate.VerifyHostname(host); err != nil {
+			t.Error(err)
+		}
+	}
+	if err := cert.Certificate.Certificate.VerifyHostname("localhost"); err == nil {
+		t.Error("localhost must not be included")
+	}
+}